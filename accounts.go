@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/go-gandi/go-gandi/config"
+	"k8s.io/klog/v2"
+)
+
+// secondaryAccount is an additional Gandi account serving the same domain,
+// e.g. for active-active DNS setups. Challenge records are written to it as
+// well.
+type secondaryAccount struct {
+	APIKeySecretRef cmmeta.SecretKeySelector `json:"apiKeySecretRef"`
+	// Zone is the domain managed in this account. It defaults to the root
+	// domain of the challenge.
+	Zone string `json:"zone,omitempty"`
+}
+
+// accountTarget is the RRset holding the challenge record in one account.
+type accountTarget struct {
+	name string
+	// credential identifies the API key of the account.
+	credential string
+	client     liveDNSClient
+	// batchClient is client without the operation budget of the challenge,
+	// for the writes coalesced with those of other challenges.
+	batchClient liveDNSClient
+	// expected are the values of the other presented challenges client
+	// expects to read, see expectValues.
+	expected  []string
+	root      string
+	subdomain string
+}
+
+// getAccountTargets returns the target of the primary account followed by the
+// targets of the secondary accounts, along with the client config of the
+// primary account. With discoverZone, the zone of the primary account is
+// the one it manages the record belongs to, if any.
+func (c *gandiDNSProviderSolver) getAccountTargets(cfg *gandiDNSProviderConfig, namespace string, budget *operationBudget, root, subdomain string) (*config.Config, []accountTarget, error) {
+	apiKey, err := c.getApiKey(cfg, namespace, root)
+	if err != nil {
+		return nil, nil, recordErrorf(root, subdomain, "get API key for", err)
+	}
+
+	clientcfg := gandiClientConfig(cfg, withAPIKey(*apiKey))
+	if cfg.DiscoverZone {
+		root, subdomain = c.discoverZone(clientcfg, keyHash(*apiKey), root, subdomain)
+	}
+	client, batchClient := c.accountClients(cfg, clientcfg, budget)
+
+	secondaries, err := c.getSecondaryTargets(cfg, namespace, budget, root, subdomain)
+	if err != nil {
+		return nil, nil, recordErrorf(root, subdomain, "write", err)
+	}
+	primary := accountTarget{name: "primary", credential: keyHash(*apiKey), client: client, batchClient: batchClient, root: root, subdomain: subdomain}
+	return &clientcfg, append([]accountTarget{primary}, secondaries...), nil
+}
+
+// accountClients returns the client of an account for a challenge, which
+// stops retrying once budget would be exceeded, and the client of the
+// account for the writes coalesced with other challenges, bound by no
+// budget.
+func (c *gandiDNSProviderSolver) accountClients(cfg *gandiDNSProviderConfig, clientcfg config.Config, budget *operationBudget) (liveDNSClient, liveDNSClient) {
+	retrying := newRetryingClient(c.newFailoverClient(clientcfg, cfg.apiEndpoints()), c.clock, defaultRetryPolicy, cfg.maintenanceRetryPolicy())
+	policy := cfg.unexpectedRecordTypePolicy()
+	return checkRecordTypes(retrying.withBudget(budget), policy), checkRecordTypes(retrying, policy)
+}
+
+// getSecondaryTargets returns a target for every secondary account, with a
+// client configured like the primary one except for the API key.
+func (c *gandiDNSProviderSolver) getSecondaryTargets(cfg *gandiDNSProviderConfig, namespace string, budget *operationBudget, root, subdomain string) ([]accountTarget, error) {
+	targets := make([]accountTarget, 0, len(cfg.SecondaryAccounts))
+	for i := range cfg.SecondaryAccounts {
+		account := &cfg.SecondaryAccounts[i]
+		name := fmt.Sprintf("secondary[%d]", i)
+
+		apiKey, err := c.waitForSecretValue(cfg, &account.APIKeySecretRef, cfg.credentialNamespace(namespace))
+		if err != nil {
+			return nil, fmt.Errorf("unable to get API key of %s account: %v", name, err)
+		}
+		accountcfg := gandiClientConfig(cfg, withAPIKey(string(apiKey)))
+		client, batchClient := c.accountClients(cfg, accountcfg, budget)
+
+		target := accountTarget{
+			name:        name,
+			credential:  keyHash(accountcfg.APIKey),
+			client:      client,
+			batchClient: batchClient,
+			root:        root,
+			subdomain:   subdomain,
+		}
+		if account.Zone != "" {
+			target.root = strings.ToLower(strings.Trim(account.Zone, "."))
+			target.subdomain, err = subdomainInZone(recordName(root, subdomain), target.root)
+			if err != nil {
+				return nil, fmt.Errorf("invalid zone of %s account: %v", name, err)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// targetResult is the outcome of an operation on the target of one account.
+type targetResult struct {
+	account string
+	record  string
+	err     error
+}
+
+func (r targetResult) String() string {
+	if r.err != nil {
+		return fmt.Sprintf("%s account: %v", r.account, r.err)
+	}
+	return fmt.Sprintf("%s account (%s)", r.account, r.record)
+}
+
+// partialFailureError is returned when an operation succeeded for fewer
+// targets than required. It lists the outcome for every target, so the
+// records left on the targets it succeeded for can be found.
+type partialFailureError struct {
+	quorum  int
+	results []targetResult
+}
+
+// outcomes returns the results of the targets the operation succeeded for
+// and of those it failed for.
+func (e *partialFailureError) outcomes() (succeeded, failed []targetResult) {
+	for _, r := range e.results {
+		if r.err != nil {
+			failed = append(failed, r)
+		} else {
+			succeeded = append(succeeded, r)
+		}
+	}
+	return succeeded, failed
+}
+
+func (e *partialFailureError) Error() string {
+	succeeded, failed := e.outcomes()
+	msg := fmt.Sprintf("succeeded for %d of %d accounts, %d required: %s",
+		len(succeeded), len(e.results), e.quorum, joinResults(failed))
+	if len(succeeded) > 0 {
+		msg += fmt.Sprintf("; succeeded for %s, which may be left with a partial change", joinResults(succeeded))
+	}
+	return msg
+}
+
+func joinResults(results []targetResult) string {
+	s := make([]string, 0, len(results))
+	for _, r := range results {
+		s = append(s, r.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+// forEachTarget calls fn for every target. It succeeds if fn succeeded for at
+// least quorum targets, failures below the quorum are only logged. Otherwise
+// it returns a *partialFailureError, or the error of the only target.
+func forEachTarget(targets []accountTarget, quorum int, fn func(accountTarget) error) error {
+	results := make([]targetResult, 0, len(targets))
+	succeeded := 0
+	for _, t := range targets {
+		result := targetResult{account: t.name, record: recordName(t.root, t.subdomain), err: fn(t)}
+		if result.err != nil {
+			klog.Warningf("%s", result)
+		} else {
+			succeeded++
+		}
+		results = append(results, result)
+	}
+	if succeeded >= quorum {
+		return nil
+	}
+	if len(targets) == 1 {
+		return results[0].err
+	}
+	return &partialFailureError{quorum: quorum, results: results}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestSolverWithSecondary returns a solver writing to a primary account
+// and a secondary account managing the zone example.com.
+func newTestSolverWithSecondary(t *testing.T) (*gandiDNSProviderSolver, *fakelivedns.Client, *fakelivedns.Client) {
+	t.Helper()
+
+	primary, secondary := fakelivedns.New(), fakelivedns.New()
+	solver := newTestSolver(primary)
+	_, err := solver.client.CoreV1().Secrets("default").Create(context.Background(),
+		newSecret("default", "gandi-secondary", map[string]string{"api-token": "secondary-secret"}), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	solver.newClient = func(cfg config.Config) liveDNSClient {
+		if cfg.APIKey == "secondary-secret" {
+			return secondary
+		}
+		return primary
+	}
+	return solver, primary, secondary
+}
+
+const secondaryConfig = `, "secondaryAccounts": [{"apiKeySecretRef": {"name": "gandi-secondary", "key": "api-token"}, "zone": "example.com."}]`
+
+func TestPresentSecondaryAccount(t *testing.T) {
+	solver, primary, secondary := newTestSolverWithSecondary(t)
+	ch := newTestChallengeRequest("_acme-challenge.www.example.com.", "example.com.", "key", secondaryConfig)
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{`"key"`}
+	for name, client := range map[string]*fakelivedns.Client{"primary": primary, "secondary": secondary} {
+		if got := client.Values("example.com", "_acme-challenge.www", "TXT"); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s values = %v, want %v", name, got, want)
+		}
+	}
+
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, client := range map[string]*fakelivedns.Client{"primary": primary, "secondary": secondary} {
+		if got := client.Values("example.com", "_acme-challenge.www", "TXT"); got != nil {
+			t.Errorf("%s values = %v after cleanup, want none", name, got)
+		}
+	}
+}
+
+func TestPresentSecondaryAccountQuorum(t *testing.T) {
+	solver, primary, secondary := newTestSolverWithSecondary(t)
+	secondary.Err = errors.New("403: Forbidden")
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", secondaryConfig)
+	err := solver.Present(ch)
+	if err == nil {
+		t.Fatal("expected an error when the secondary account fails")
+	}
+	if !strings.Contains(err.Error(), "succeeded for 1 of 2 accounts, 2 required") || !strings.Contains(err.Error(), "secondary[0] account: unable to create TXT record _acme-challenge in zone example.com: 403: Forbidden") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ch = newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", secondaryConfig+`, "writeQuorum": 1`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("unexpected error with a quorum of 1: %v", err)
+	}
+	if got := primary.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, []string{`"key"`}) {
+		t.Errorf("primary values = %v", got)
+	}
+}
+
+func TestSecondaryAccountPartialFailure(t *testing.T) {
+	solver, primary, secondary := newTestSolverWithSecondary(t)
+	ch := newTestChallengeRequest("_acme-challenge.www.example.com.", "example.com.", "key", secondaryConfig)
+
+	secondary.Err = errors.New("403: Forbidden")
+	err := solver.Present(ch)
+	var partial *partialFailureError
+	if !errors.As(err, &partial) {
+		t.Fatalf("Present() error = %v, want a partial failure", err)
+	}
+	succeeded, failed := partial.outcomes()
+	if len(succeeded) != 1 || succeeded[0].account != "primary" || succeeded[0].record != "_acme-challenge.www.example.com" {
+		t.Errorf("succeeded = %v, want the primary account", succeeded)
+	}
+	if len(failed) != 1 || failed[0].account != "secondary[0]" {
+		t.Errorf("failed = %v, want the secondary account", failed)
+	}
+	if want := "succeeded for primary account (_acme-challenge.www.example.com), which may be left with a partial change"; !strings.Contains(err.Error(), want) {
+		t.Errorf("Present() error = %v, want it to contain %q", err, want)
+	}
+
+	// Present again once the secondary account is back, then fail the clean
+	// up of the primary account.
+	secondary.Err = nil
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	primary.DeleteErr = errors.New("500: Internal Server Error")
+	err = solver.CleanUp(ch)
+	if !errors.As(err, &partial) {
+		t.Fatalf("CleanUp() error = %v, want a partial failure", err)
+	}
+	succeeded, failed = partial.outcomes()
+	if len(succeeded) != 1 || succeeded[0].account != "secondary[0]" || len(failed) != 1 || failed[0].account != "primary" {
+		t.Errorf("succeeded = %v, failed = %v", succeeded, failed)
+	}
+	if got := primary.Values("example.com", "_acme-challenge.www", "TXT"); !reflect.DeepEqual(got, []string{`"key"`}) {
+		t.Errorf("primary values = %v, want the value left in place", got)
+	}
+}
+
+func TestForEachTargetSingleTarget(t *testing.T) {
+	want := errors.New("403: Forbidden")
+	err := forEachTarget([]accountTarget{{name: "primary", root: "example.com", subdomain: "_acme-challenge"}}, 1, func(accountTarget) error {
+		return want
+	})
+	if err != want {
+		t.Errorf("forEachTarget() = %v, want the error of the only target", err)
+	}
+}
+
+func TestLoadConfigWriteQuorum(t *testing.T) {
+	for _, quorum := range []string{"-1", "3"} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", secondaryConfig+`, "writeQuorum": `+quorum)
+		if _, err := loadConfig(ch.Config); err == nil {
+			t.Errorf("expected an error for writeQuorum %s", quorum)
+		}
+	}
+}
+
+func TestSubdomainInZone(t *testing.T) {
+	sub, err := subdomainInZone("_acme-challenge.www.Example.com", "example.com.")
+	if err != nil || sub != "_acme-challenge.www" {
+		t.Errorf("subdomainInZone = %q, %v", sub, err)
+	}
+	if _, err := subdomainInZone("_acme-challenge.example.org", "example.com"); err == nil {
+		t.Error("expected an error for a name outside the zone")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// newAdminHandler returns the handler of the admin endpoint, listing the
+// challenge records the webhook believes it presented and the challenges in
+// flight, and repairing the records of presented challenges with repair if
+// set. Every request must carry token as a bearer token.
+func newAdminHandler(tracker valueTracker, challenges *challengeRegistry, repair func() repairReport, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/in-flight", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(challenges.List())
+	})
+	mux.HandleFunc("/challenges", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		values, err := tracker.List()
+		if err != nil {
+			klog.Errorf("unable to list tracked values: %v", err)
+			http.Error(w, "unable to list tracked values", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(values)
+	})
+	if repair != nil {
+		mux.HandleFunc("/repair", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(repair())
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// startAdminServer serves the admin endpoint on address until stopCh is
+// closed.
+func startAdminServer(address, token string, tracker valueTracker, challenges *challengeRegistry, repair func() repairReport, stopCh <-chan struct{}) error {
+	if token == "" {
+		return fmt.Errorf("ADMIN_TOKEN must be specified with ADMIN_ADDRESS")
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("unable to listen on admin address %s: %v", address, err)
+	}
+	server := &http.Server{
+		Handler:           newAdminHandler(tracker, challenges, repair, token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("admin server stopped: %v", err)
+		}
+	}()
+	go func() {
+		<-stopCh
+		_ = server.Shutdown(context.Background())
+	}()
+	klog.V(2).Infof("serving admin endpoint on %s", listener.Addr())
+	return nil
+}
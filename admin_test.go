@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	tracker := newMemoryTracker()
+	if err := tracker.Add("_acme-challenge.example.com", "key"); err != nil {
+		t.Fatal(err)
+	}
+	handler := newAdminHandler(tracker, newChallengeRegistry(newFakeClock()), nil, "admin-token")
+
+	for _, tt := range []struct {
+		name   string
+		auth   string
+		method string
+		status int
+	}{
+		{name: "no token", method: http.MethodGet, status: http.StatusUnauthorized},
+		{name: "wrong token", auth: "Bearer other", method: http.MethodGet, status: http.StatusUnauthorized},
+		{name: "wrong scheme", auth: "Basic admin-token", method: http.MethodGet, status: http.StatusUnauthorized},
+		{name: "wrong method", auth: "Bearer admin-token", method: http.MethodDelete, status: http.StatusMethodNotAllowed},
+		{name: "ok", auth: "Bearer admin-token", method: http.MethodGet, status: http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/challenges", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var values []trackedValue
+			if err := json.NewDecoder(rec.Body).Decode(&values); err != nil {
+				t.Fatal(err)
+			}
+			want := []trackedValue{{
+				FQDN:        "_acme-challenge.example.com",
+				Zone:        "example.com",
+				TrackingKey: trackingKey("_acme-challenge.example.com", "key"),
+			}}
+			if !reflect.DeepEqual(values, want) {
+				t.Errorf("values = %v, want %v", values, want)
+			}
+		})
+	}
+}
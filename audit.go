@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"k8s.io/klog/v2"
+)
+
+// challengeContext returns the identifiers of the ACME challenge behind ch
+// that are safe to log, so a DNS change can be tied back to it: the UID of
+// the Challenge resource and the name being validated, each omitted if
+// unset. cert-manager passes no order or account identifier to webhooks.
+func challengeContext(ch *v1alpha1.ChallengeRequest) string {
+	var fields []string
+	if ch.UID != "" {
+		fields = append(fields, "challenge="+string(ch.UID))
+	}
+	if ch.DNSName != "" {
+		fields = append(fields, "dnsName="+ch.DNSName)
+	}
+	return strings.Join(fields, " ")
+}
+
+// withContext appends context to a log message, if there is any.
+func withContext(msg, context string) string {
+	if context == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s (%s)", msg, context)
+}
+
+// auditClient is a liveDNSClient reading from Gandi but only logging the
+// writes it is asked to make, so the decisions of the solver can be checked
+// against real challenges without changing any record.
+type auditClient struct {
+	account string
+	// context identifies the challenge the writes are made for.
+	context string
+	next    liveDNSClient
+}
+
+// auditTargets returns targets with their clients replaced by audit clients
+// logging the writes made for the challenge ch.
+func auditTargets(targets []accountTarget, ch *v1alpha1.ChallengeRequest) []accountTarget {
+	audited := make([]accountTarget, 0, len(targets))
+	for _, t := range targets {
+		t.client = &auditClient{account: t.name, context: challengeContext(ch), next: t.client}
+		audited = append(audited, t)
+	}
+	return audited
+}
+
+// audited reports whether t was returned by auditTargets.
+func (t accountTarget) audited() bool {
+	_, ok := t.client.(*auditClient)
+	return ok
+}
+
+func (a *auditClient) GetDomainRecordsByName(fqdn, name string) ([]livedns.DomainRecord, error) {
+	return a.next.GetDomainRecordsByName(fqdn, name)
+}
+
+func (a *auditClient) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	return a.next.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+}
+
+func (a *auditClient) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	klog.Info(withContext(fmt.Sprintf("audit: would create %s record %s in zone %s of %s account with values %v", recordtype, name, fqdn, a.account, keyHashAll(values)), a.context))
+	return types.StandardResponse{}, nil
+}
+
+func (a *auditClient) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	klog.Info(withContext(fmt.Sprintf("audit: would update %s record %s in zone %s of %s account to values %v", recordtype, name, fqdn, a.account, keyHashAll(values)), a.context))
+	return types.StandardResponse{}, nil
+}
+
+func (a *auditClient) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	klog.Info(withContext(fmt.Sprintf("audit: would delete %s record %s in zone %s of %s account", recordtype, name, fqdn, a.account), a.context))
+	return nil
+}
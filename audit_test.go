@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+func TestAuditOnly(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"apex"}); err != nil {
+		t.Fatal(err)
+	}
+	gandiClient.ResetCalls()
+	solver := newTestSolver(gandiClient)
+
+	for _, key := range []string{"wildcard", "apex"} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", key, `, "auditOnly": true, "waitForPropagation": true`)
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("present %s: %v", key, err)
+		}
+		if err := solver.CleanUp(ch); err != nil {
+			t.Fatalf("clean up %s: %v", key, err)
+		}
+	}
+
+	want := map[string]int{"GetDomainRecordsByName": 4}
+	if !reflect.DeepEqual(gandiClient.CallCounts(), want) {
+		t.Errorf("calls = %v, want %v", gandiClient.CallCounts(), want)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, []string{`"apex"`}) {
+		t.Errorf("values = %v, want the original value", got)
+	}
+	if ok, _ := solver.tracker.Has("_acme-challenge.example.com", "wildcard"); ok {
+		t.Error("audited value was tracked")
+	}
+}
+
+func TestChallengeContext(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ch   *v1alpha1.ChallengeRequest
+		want string
+	}{
+		{"both", &v1alpha1.ChallengeRequest{UID: "3b7c1e2a", DNSName: "example.com", Key: "secret"}, "challenge=3b7c1e2a dnsName=example.com"},
+		{"no uid", &v1alpha1.ChallengeRequest{DNSName: "example.com"}, "dnsName=example.com"},
+		{"none", &v1alpha1.ChallengeRequest{Key: "secret"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := challengeContext(tc.ch)
+			if got != tc.want {
+				t.Errorf("challengeContext() = %q, want %q", got, tc.want)
+			}
+			if strings.Contains(got, "secret") {
+				t.Errorf("challengeContext() = %q, contains the key", got)
+			}
+		})
+	}
+
+	if got := withContext("audit: would delete", ""); got != "audit: would delete" {
+		t.Errorf("withContext() = %q without context", got)
+	}
+	if got := withContext("audit: would delete", "dnsName=example.com"); got != "audit: would delete (dnsName=example.com)" {
+		t.Errorf("withContext() = %q", got)
+	}
+}
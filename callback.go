@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/klog/v2"
+)
+
+// defaultCallbackTimeout bounds a lifecycle callback unless CALLBACK_TIMEOUT
+// is set.
+const defaultCallbackTimeout = 5 * time.Second
+
+// lifecycleEvent is the JSON payload of a lifecycle callback, sent once
+// Present or CleanUp returns.
+type lifecycleEvent struct {
+	Solver    string    `json:"solver"`
+	Operation string    `json:"operation"`
+	FQDN      string    `json:"fqdn"`
+	Zone      string    `json:"zone"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	ValueHash string    `json:"valueHash"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// lifecycleCallback posts lifecycle events to an HTTP endpoint, for external
+// automation or notifications.
+type lifecycleCallback struct {
+	url           string
+	authorization string
+	client        *http.Client
+}
+
+// lifecycleCallbackFromEnv returns the callback to CALLBACK_URL with the
+// Authorization header CALLBACK_AUTHORIZATION, each request bounded by
+// CALLBACK_TIMEOUT, or nil if CALLBACK_URL is not set.
+func lifecycleCallbackFromEnv() (*lifecycleCallback, error) {
+	address := os.Getenv("CALLBACK_URL")
+	if address == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid callback URL %q, must be an http or https URL", redactURL(address))
+	}
+	timeout, err := durationFromEnv("CALLBACK_TIMEOUT", defaultCallbackTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return newLifecycleCallback(address, os.Getenv("CALLBACK_AUTHORIZATION"), timeout), nil
+}
+
+func newLifecycleCallback(address, authorization string, timeout time.Duration) *lifecycleCallback {
+	return &lifecycleCallback{
+		url:           address,
+		authorization: authorization,
+		client:        &http.Client{Timeout: timeout},
+	}
+}
+
+// notify posts the outcome of operation on the challenge ch in the
+// background, so a slow or failing endpoint never holds up the challenge.
+// Failures are logged. A nil callback does nothing.
+func (cb *lifecycleCallback) notify(solver, operation string, ch *v1alpha1.ChallengeRequest, err error, now time.Time) {
+	if cb == nil {
+		return
+	}
+	event := lifecycleEvent{
+		Solver:    solver,
+		Operation: operation,
+		FQDN:      strings.TrimSuffix(ch.ResolvedFQDN, "."),
+		Zone:      strings.TrimSuffix(ch.ResolvedZone, "."),
+		Outcome:   "success",
+		ValueHash: keyHash(ch.Key),
+		Timestamp: now.UTC(),
+	}
+	if err != nil {
+		event.Outcome = "failure"
+		event.Error = err.Error()
+	}
+	go cb.post(event)
+}
+
+func (cb *lifecycleCallback) post(event lifecycleEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		klog.Warningf("Unable to encode %s callback for %s: %v", event.Operation, event.FQDN, err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, cb.url, bytes.NewReader(body))
+	if err != nil {
+		klog.Warningf("Unable to send %s callback for %s: %v", event.Operation, event.FQDN, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cb.authorization != "" {
+		req.Header.Set("Authorization", cb.authorization)
+	}
+	resp, err := cb.client.Do(req)
+	if err != nil {
+		klog.Warningf("Unable to send %s callback for %s: %v", event.Operation, event.FQDN, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		klog.Warningf("Callback for %s of %s returned %s", event.Operation, event.FQDN, resp.Status)
+		return
+	}
+	klog.V(6).Infof("sent %s callback for %s", event.Operation, event.FQDN)
+}
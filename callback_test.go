@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+)
+
+func TestLifecycleCallback(t *testing.T) {
+	events := make(chan lifecycleEvent, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q, want %q", got, "Bearer token")
+		}
+		var event lifecycleEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		events <- event
+	}))
+	defer server.Close()
+
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	solver.callback = newLifecycleCallback(server.URL, "Bearer token", time.Second)
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+
+	gandiClient.Err = fakelivedns.StatusError(403, "Forbidden")
+	if err := solver.Present(ch); err == nil {
+		t.Fatal("expected a present error")
+	}
+	event := <-events
+	if event.Operation != "present" || event.Outcome != "failure" || event.Error == "" {
+		t.Errorf("event = %+v, want a failed present", event)
+	}
+
+	gandiClient.Err = nil
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	<-events
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	event = <-events
+	if event.Operation != "cleanUp" || event.Outcome != "success" || event.FQDN != "_acme-challenge.example.com" ||
+		event.Zone != "example.com" || event.ValueHash != keyHash("key") || event.Error != "" {
+		t.Errorf("event = %+v, want a successful clean up of the challenge", event)
+	}
+}
+
+func TestLifecycleCallbackDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	solver := newTestSolver(fakelivedns.New())
+	solver.callback = newLifecycleCallback(server.URL, "", time.Minute)
+	done := make(chan error)
+	go func() {
+		done <- solver.Present(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", ""))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Present waited for the callback")
+	}
+}
+
+func TestLifecycleCallbackFromEnv(t *testing.T) {
+	t.Setenv("CALLBACK_URL", "")
+	if cb, err := lifecycleCallbackFromEnv(); cb != nil || err != nil {
+		t.Errorf("lifecycleCallbackFromEnv() = %v, %v, want none", cb, err)
+	}
+	for _, address := range []string{"ftp://example.com", "example.com/hook", "http://"} {
+		t.Setenv("CALLBACK_URL", address)
+		if _, err := lifecycleCallbackFromEnv(); err == nil {
+			t.Errorf("CALLBACK_URL=%s: expected an error", address)
+		}
+	}
+	t.Setenv("CALLBACK_URL", "https://example.com/hook")
+	t.Setenv("CALLBACK_TIMEOUT", "2s")
+	cb, err := lifecycleCallbackFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.client.Timeout != 2*time.Second {
+		t.Errorf("timeout = %s, want 2s", cb.client.Timeout)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+// challengeState is the stage an in-flight challenge is in.
+type challengeState string
+
+const (
+	challengePresenting challengeState = "presenting"
+	challengePresented  challengeState = "presented"
+	challengeCleaning   challengeState = "cleaning"
+)
+
+var challengeStates = []challengeState{challengePresenting, challengePresented, challengeCleaning}
+
+var challengesInFlight = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Name:           "gandi_challenges_in_flight",
+		Help:           "Number of challenges between their first Present and their successful CleanUp, by state.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"state"},
+)
+
+var cleanUpErrors = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Name:           "gandi_cleanup_errors_ignored_total",
+		Help:           "Number of CleanUp errors ignored because of failOnCleanupError, by solver. Each may have left a TXT value behind.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"solver"},
+)
+
+func init() {
+	legacyregistry.MustRegister(challengesInFlight, cleanUpErrors)
+}
+
+// challengeStatus is the status of an in-flight challenge. Attempts counts
+// the calls to Present and CleanUp, failed ones included.
+type challengeStatus struct {
+	FQDN     string         `json:"fqdn"`
+	State    challengeState `json:"state"`
+	Started  time.Time      `json:"started"`
+	Since    time.Time      `json:"since"`
+	Attempts int            `json:"attempts"`
+}
+
+// defaultChallengeMaxAge is how long a challenge stays in the registry
+// without changing state by default.
+const defaultChallengeMaxAge = 24 * time.Hour
+
+// challengeRegistry keeps the status of the challenges the webhook is
+// solving, from their first Present until their successful CleanUp, so stuck
+// challenges can be spotted. Challenges whose CleanUp never comes, e.g. as
+// the Challenge was deleted or the webhook restarted in between, are
+// forgotten once they stayed maxAge in the same state.
+type challengeRegistry struct {
+	mu         sync.Mutex
+	clock      clock
+	maxAge     time.Duration
+	challenges map[string]*challengeStatus
+	// requests holds the requests of the presented challenges, to repair
+	// their records.
+	requests map[string]presentedChallenge
+}
+
+// presentedChallenge is the request of a presented challenge and the solver
+// that presented it, whose config and credentials its records are repaired
+// with.
+type presentedChallenge struct {
+	request *v1alpha1.ChallengeRequest
+	solver  *gandiDNSProviderSolver
+}
+
+func newChallengeRegistry(clk clock) *challengeRegistry {
+	return &challengeRegistry{clock: clk, maxAge: defaultChallengeMaxAge, challenges: map[string]*challengeStatus{}, requests: map[string]presentedChallenge{}}
+}
+
+// evictStale forgets the challenges that stayed maxAge in the same state.
+// Their values remain tracked, so a late CleanUp still removes them.
+func (r *challengeRegistry) evictStale() {
+	now := r.clock.Now()
+	evicted := false
+	for key, status := range r.challenges {
+		if now.Sub(status.Since) >= r.maxAge {
+			klog.Warningf("Forgetting challenge for %s, %s since %s", status.FQDN, status.State, status.Since.Format(time.RFC3339))
+			delete(r.challenges, key)
+			delete(r.requests, key)
+			evicted = true
+		}
+	}
+	if evicted {
+		r.updateGauge()
+	}
+}
+
+// attempt records a call to Present or CleanUp moving the challenge of key
+// at fqdn to state.
+func (r *challengeRegistry) attempt(fqdn, key string, state challengeState) {
+	r.update(fqdn, key, state, true)
+}
+
+// transition moves the challenge of key at fqdn to state.
+func (r *challengeRegistry) transition(fqdn, key string, state challengeState) {
+	r.update(fqdn, key, state, false)
+}
+
+func (r *challengeRegistry) update(fqdn, key string, state challengeState, attempt bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evictStale()
+	now := r.clock.Now()
+	status, ok := r.challenges[trackingKey(fqdn, key)]
+	if !ok {
+		status = &challengeStatus{FQDN: fqdn, Started: now}
+		r.challenges[trackingKey(fqdn, key)] = status
+	}
+	if status.State != state {
+		status.State = state
+		status.Since = now
+	}
+	if attempt {
+		status.Attempts++
+	}
+	r.updateGauge()
+}
+
+// presented moves the challenge of ch at fqdn to the presented state and
+// keeps ch, along with the solver that presented it, until the challenge is
+// cleaned up.
+func (r *challengeRegistry) presented(fqdn string, ch *v1alpha1.ChallengeRequest, solver *gandiDNSProviderSolver) {
+	r.transition(fqdn, ch.Key, challengePresented)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests[trackingKey(fqdn, ch.Key)] = presentedChallenge{request: ch.DeepCopy(), solver: solver}
+}
+
+// presentedRequests returns the challenges in the presented state, ordered by
+// FQDN.
+func (r *challengeRegistry) presentedRequests() []presentedChallenge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evictStale()
+	var keys []string
+	for key, p := range r.requests {
+		if status, ok := r.challenges[key]; ok && status.State == challengePresented && p.request != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if r.challenges[keys[i]].FQDN != r.challenges[keys[j]].FQDN {
+			return r.challenges[keys[i]].FQDN < r.challenges[keys[j]].FQDN
+		}
+		return keys[i] < keys[j]
+	})
+	requests := make([]presentedChallenge, 0, len(keys))
+	for _, key := range keys {
+		p := r.requests[key]
+		requests = append(requests, presentedChallenge{request: p.request.DeepCopy(), solver: p.solver})
+	}
+	return requests
+}
+
+// presentedKeys returns the keys of the challenges in the presented state at
+// fqdn, but for except.
+func (r *challengeRegistry) presentedKeys(fqdn, except string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var keys []string
+	for key, p := range r.requests {
+		if status, ok := r.challenges[key]; ok && status.State == challengePresented && status.FQDN == fqdn && p.request.Key != except {
+			keys = append(keys, p.request.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// done forgets the challenge of key at fqdn once it is cleaned up.
+func (r *challengeRegistry) done(fqdn, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.challenges, trackingKey(fqdn, key))
+	delete(r.requests, trackingKey(fqdn, key))
+	r.updateGauge()
+}
+
+// List returns the in-flight challenges, oldest first.
+func (r *challengeRegistry) List() []challengeStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evictStale()
+	list := make([]challengeStatus, 0, len(r.challenges))
+	for _, status := range r.challenges {
+		list = append(list, *status)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Started.Equal(list[j].Started) {
+			return list[i].Started.Before(list[j].Started)
+		}
+		return list[i].FQDN < list[j].FQDN
+	})
+	return list
+}
+
+func (r *challengeRegistry) updateGauge() {
+	counts := map[challengeState]int{}
+	for _, status := range r.challenges {
+		counts[status.State]++
+	}
+	for _, state := range challengeStates {
+		challengesInFlight.WithLabelValues(string(state)).Set(float64(counts[state]))
+	}
+}
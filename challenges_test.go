@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+)
+
+func TestChallengeRegistry(t *testing.T) {
+	clk := newFakeClock()
+	start := clk.Now()
+	r := newChallengeRegistry(clk)
+
+	r.attempt("_acme-challenge.example.com", "key", challengePresenting)
+	clk.Sleep(time.Second)
+	r.attempt("_acme-challenge.example.com", "key", challengePresenting)
+	clk.Sleep(time.Second)
+	r.transition("_acme-challenge.example.com", "key", challengePresented)
+	r.attempt("_acme-challenge.example.org", "key", challengePresenting)
+	clk.Sleep(time.Second)
+	r.attempt("_acme-challenge.example.com", "key", challengeCleaning)
+
+	want := []challengeStatus{
+		{FQDN: "_acme-challenge.example.com", State: challengeCleaning, Started: start, Since: start.Add(3 * time.Second), Attempts: 3},
+		{FQDN: "_acme-challenge.example.org", State: challengePresenting, Started: start.Add(2 * time.Second), Since: start.Add(2 * time.Second), Attempts: 1},
+	}
+	if got := r.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+
+	r.done("_acme-challenge.example.com", "key")
+	if got := r.List(); len(got) != 1 || got[0].FQDN != "_acme-challenge.example.org" {
+		t.Errorf("List() after done = %+v", got)
+	}
+}
+
+func TestChallengeRegistryEvictsStale(t *testing.T) {
+	clk := newFakeClock()
+	r := newChallengeRegistry(clk)
+	r.maxAge = time.Hour
+	stale := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "stale-key", "")
+	r.attempt("_acme-challenge.example.com", stale.Key, challengePresenting)
+	r.presented("_acme-challenge.example.com", stale, nil)
+	clk.Sleep(30 * time.Minute)
+	r.attempt("_acme-challenge.example.org", "key", challengePresenting)
+
+	// The CleanUp of the first challenge never comes.
+	clk.Sleep(30 * time.Minute)
+	if got := r.List(); len(got) != 1 || got[0].FQDN != "_acme-challenge.example.org" {
+		t.Errorf("List() = %+v, want only the recent challenge", got)
+	}
+	if got := r.presentedRequests(); len(got) != 0 {
+		t.Errorf("presented requests = %d, want the stale one forgotten", len(got))
+	}
+	if got := r.presentedKeys("_acme-challenge.example.com", ""); len(got) != 0 {
+		t.Errorf("presented keys = %v, want none", got)
+	}
+
+	t.Setenv("CHALLENGE_MAX_AGE", "2h")
+	solvers, err := solversFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxAge := solvers[0].(*gandiDNSProviderSolver).challenges.maxAge; maxAge != 2*time.Hour {
+		t.Errorf("max age = %s, want 2h", maxAge)
+	}
+	t.Setenv("CHALLENGE_MAX_AGE", "forever")
+	if _, err := solversFromEnv(); err == nil {
+		t.Error("expected an error for an invalid CHALLENGE_MAX_AGE")
+	}
+}
+
+func TestSolverRegistersChallenges(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	list := solver.challenges.List()
+	if len(list) != 1 || list[0].State != challengePresented || list[0].Attempts != 1 {
+		t.Errorf("in-flight challenges after Present = %+v", list)
+	}
+
+	handler := newAdminHandler(solver.tracker, solver.challenges, nil, "admin-token")
+	req := httptest.NewRequest(http.MethodGet, "/in-flight", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var listed []challengeStatus
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].FQDN != "_acme-challenge.example.com" {
+		t.Errorf("listed in-flight challenges = %+v", listed)
+	}
+
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if list := solver.challenges.List(); len(list) != 0 {
+		t.Errorf("in-flight challenges after CleanUp = %+v", list)
+	}
+}
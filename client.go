@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gandi/go-gandi"
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// liveDNSClient is the subset of the go-gandi LiveDNS client used by the
+// solver. It is satisfied by *livedns.LiveDNS.
+type liveDNSClient interface {
+	GetDomainRecordsByName(fqdn, name string) ([]livedns.DomainRecord, error)
+	GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error)
+	CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error)
+	UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error)
+	DeleteDomainRecord(fqdn, name, recordtype string) error
+}
+
+var _ liveDNSClient = (*livedns.LiveDNS)(nil)
+
+const (
+	defaultAPIURL     = "https://api.gandi.net"
+	defaultAPIVersion = "v5"
+
+	// defaultDialTimeout and defaultKeepAlive are the settings of Go's
+	// default transport.
+	defaultDialTimeout = 30 * time.Second
+	defaultKeepAlive   = 30 * time.Second
+
+	// defaultMaxResponseSize bounds the responses read from Gandi unless
+	// GANDI_MAX_RESPONSE_SIZE is set. The largest are zone listings, far
+	// below it even for zones with thousands of records.
+	defaultMaxResponseSize = 10 << 20
+)
+
+// supportedAPIVersions are the Gandi API versions the webhook was tested with.
+var supportedAPIVersions = []string{"v5"}
+
+// apiVersionFromEnv returns the API version set by GANDI_API_VERSION, if any.
+func apiVersionFromEnv() string {
+	if v := os.Getenv("GANDI_API_VERSION"); v != "" {
+		return v
+	}
+	return defaultAPIVersion
+}
+
+// validateAPIVersion ensures version is a supported Gandi API version.
+func validateAPIVersion(version string) error {
+	for _, v := range supportedAPIVersions {
+		if version == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported Gandi API version %q, supported versions are %v", version, supportedAPIVersions)
+}
+
+// validateAPIURL ensures apiURL is an absolute HTTP(S) URL.
+func validateAPIURL(apiURL string) error {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return fmt.Errorf("invalid Gandi API URL %q: %v", apiURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid Gandi API URL %q: must be an absolute http or https URL", apiURL)
+	}
+	return nil
+}
+
+// apiEndpoint returns the base URL apiURL as expected by go-gandi, which
+// appends the API version (/v5/) and path (e.g. livedns/) to it itself. The
+// API version is only validated, as go-gandi cannot target another one.
+func apiEndpoint(apiURL string) string {
+	return strings.TrimRight(apiURL, "/")
+}
+
+// clientOption adjusts the go-gandi config a client is created with.
+type clientOption func(*config.Config)
+
+// withAPIKey sets the API key of a client.
+func withAPIKey(apiKey string) clientOption {
+	return func(clientcfg *config.Config) {
+		clientcfg.APIKey = apiKey
+	}
+}
+
+// withEndpoint sets the API endpoint of a client, the first of the config by
+// default.
+func withEndpoint(endpoint string) clientOption {
+	return func(clientcfg *config.Config) {
+		clientcfg.APIURL = endpoint
+	}
+}
+
+// gandiClientConfig returns the go-gandi config of the clients of cfg, the
+// only place it is built so all clients agree: the first API endpoint of
+// cfg, request dumps unless logs are redacted and no dry run, adjusted by
+// opts. go-gandi has no per-client transport nor timeout: every client
+// shares the transport set up by newGandiTransport, which gandiHostTransport
+// routes their requests to.
+func gandiClientConfig(cfg *gandiDNSProviderConfig, opts ...clientOption) config.Config {
+	clientcfg := config.Config{
+		APIURL: cfg.apiEndpoint(),
+		Debug:  !redactLogs,
+		DryRun: false,
+	}
+	for _, opt := range opts {
+		opt(&clientcfg)
+	}
+	return clientcfg
+}
+
+// newGandiClient returns a client created with the config gandiClientConfig
+// returns for cfg and opts.
+func (c *gandiDNSProviderSolver) newGandiClient(cfg *gandiDNSProviderConfig, opts ...clientOption) liveDNSClient {
+	return c.newClient(gandiClientConfig(cfg, opts...))
+}
+
+// newLiveDNSClient returns a client for the Gandi LiveDNS API, whose
+// requests go through the Gandi transport.
+func newLiveDNSClient(cfg config.Config) liveDNSClient {
+	if gandiRouting != nil {
+		gandiRouting.addEndpoint(cfg.APIURL)
+	}
+	return gandi.NewLiveDNSClient(cfg)
+}
+
+// gandiRouting is the default transport once main installed it, nil in tests.
+var gandiRouting *gandiHostTransport
+
+// gandiHostTransport is installed as the default transport, as go-gandi has
+// no per-client transport and sends its requests through the default one. It
+// only sends the requests to the hosts of the Gandi API endpoints clients
+// were created for through the Gandi transport, with its proxy, timeouts,
+// rate limit and response size limit. Requests of any other code using the
+// default transport go through the transport it replaced, unaffected.
+type gandiHostTransport struct {
+	mu    sync.RWMutex
+	hosts map[string]bool
+	gandi http.RoundTripper
+	other http.RoundTripper
+}
+
+func newGandiHostTransport(gandi, other http.RoundTripper) *gandiHostTransport {
+	return &gandiHostTransport{hosts: map[string]bool{}, gandi: gandi, other: other}
+}
+
+// addEndpoint sends the requests to the host of the API endpoint apiURL, the
+// go-gandi default if empty, through the Gandi transport.
+func (t *gandiHostTransport) addEndpoint(apiURL string) {
+	if apiURL == "" {
+		apiURL = defaultAPIURL
+	}
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		// Endpoints are validated with the config.
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hosts[u.Host] = true
+}
+
+func (t *gandiHostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.RLock()
+	gandi := t.hosts[req.URL.Host]
+	t.mu.RUnlock()
+	if gandi {
+		return t.gandi.RoundTrip(req)
+	}
+	return t.other.RoundTrip(req)
+}
+
+// durationFromEnv returns the duration set by the environment variable name,
+// or def if it is not set. The duration must be positive.
+func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %v", v, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", v)
+	}
+	return d, nil
+}
+
+// maxResponseSizeFromEnv returns the maximum size of the responses read
+// from Gandi set by GANDI_MAX_RESPONSE_SIZE, a number of bytes such as
+// 1048576 or 1Mi.
+func maxResponseSizeFromEnv() (int64, error) {
+	v := os.Getenv("GANDI_MAX_RESPONSE_SIZE")
+	if v == "" {
+		return defaultMaxResponseSize, nil
+	}
+	q, err := resource.ParseQuantity(v)
+	if err != nil || q.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid size %q, must be a positive number of bytes such as 1048576 or 1Mi", v)
+	}
+	return q.Value(), nil
+}
+
+// responseLimitTransport is an http.RoundTripper failing the read of
+// response bodies larger than max bytes, so an oversized response cannot
+// exhaust the memory of the webhook.
+type responseLimitTransport struct {
+	next http.RoundTripper
+	max  int64
+}
+
+func newResponseLimitTransport(next http.RoundTripper, max int64) *responseLimitTransport {
+	return &responseLimitTransport{next: next, max: max}
+}
+
+func (t *responseLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body := &limitedBody{
+		ReadCloser: resp.Body,
+		remaining:  t.max,
+		err:        fmt.Errorf("response to %s %s exceeds the maximum size of %d bytes set by GANDI_MAX_RESPONSE_SIZE", req.Method, req.URL.Path, t.max),
+	}
+	if resp.ContentLength > t.max {
+		// Fail the read rather than the request, which would be retried as
+		// a connection error.
+		body.remaining = -1
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// limitedBody is a response body returning err once more than remaining
+// bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, b.err
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n, b.remaining = int(b.remaining), -1
+		return n, b.err
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// newGandiTransport returns the transport go-gandi sends its requests
+// through. It uses proxyURL as proxy if set, or else the proxy configured by
+// the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables, and throttles
+// requests following the rate limit Gandi reports. Connections time out after
+// dialTimeout and are kept alive with probes every keepAlive. Responses
+// larger than maxResponseSize bytes fail to be read.
+func newGandiTransport(proxyURL string, dialTimeout, keepAlive time.Duration, maxResponseSize int64, clk clock) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: keepAlive}).DialContext
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", proxyURL)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return newRateLimitTransport(newResponseLimitTransport(transport, maxResponseSize), clk), nil
+}
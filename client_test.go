@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/config"
+)
+
+func TestAPIEndpoint(t *testing.T) {
+	tests := []struct {
+		config   string
+		endpoint string
+		wantErr  bool
+	}{
+		{config: ``, endpoint: "https://api.gandi.net"},
+		{config: `, "apiVersion": "v5"`, endpoint: "https://api.gandi.net"},
+		{config: `, "apiURL": "https://api.sandbox.gandi.net/"`, endpoint: "https://api.sandbox.gandi.net"},
+		{config: `, "apiURL": "http://127.0.0.1:8080"`, endpoint: "http://127.0.0.1:8080"},
+		{config: `, "apiVersion": "v4"`, wantErr: true},
+		{config: `, "apiURL": "api.gandi.net"`, wantErr: true},
+		{config: `, "apiURL": "ftp://api.gandi.net"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", tt.config)
+		cfg, err := loadConfig(ch.Config)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.config)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.config, err)
+			continue
+		}
+		if got := cfg.apiEndpoint(); got != tt.endpoint {
+			t.Errorf("%s: endpoint = %q, want %q", tt.config, got, tt.endpoint)
+		}
+	}
+}
+
+func TestAPIVersionFromEnv(t *testing.T) {
+	t.Setenv("GANDI_API_VERSION", "v6")
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+	if _, err := loadConfig(ch.Config); err == nil {
+		t.Error("expected an error for an unsupported GANDI_API_VERSION")
+	}
+}
+
+func TestDurationFromEnv(t *testing.T) {
+	d, err := durationFromEnv("GANDI_DIAL_TIMEOUT", defaultDialTimeout)
+	if err != nil || d != defaultDialTimeout {
+		t.Errorf("unset: got %s, %v, want the default", d, err)
+	}
+	t.Setenv("GANDI_DIAL_TIMEOUT", "1m30s")
+	if d, err := durationFromEnv("GANDI_DIAL_TIMEOUT", defaultDialTimeout); err != nil || d != 90*time.Second {
+		t.Errorf("1m30s: got %s, %v, want 1m30s", d, err)
+	}
+	for _, v := range []string{"30", "-1s", "0s"} {
+		t.Setenv("GANDI_DIAL_TIMEOUT", v)
+		if _, err := durationFromEnv("GANDI_DIAL_TIMEOUT", defaultDialTimeout); err == nil {
+			t.Errorf("%s: expected an error", v)
+		}
+	}
+}
+
+func TestNewGandiTransportProxy(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://api.gandi.net/v5/livedns/domains", nil)
+
+	rt, err := newGandiTransport("http://proxy.example.com:3128", defaultDialTimeout, defaultKeepAlive, defaultMaxResponseSize, newFakeClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := rt.(*rateLimitTransport).next.(*responseLimitTransport).next.(*http.Transport)
+	proxy, err := transport.Proxy(req)
+	if err != nil || proxy == nil || proxy.String() != "http://proxy.example.com:3128" {
+		t.Errorf("proxy = %v, %v, want the configured proxy", proxy, err)
+	}
+
+	rt, err = newGandiTransport("", defaultDialTimeout, defaultKeepAlive, defaultMaxResponseSize, newFakeClock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.(*rateLimitTransport).next.(*responseLimitTransport).next.(*http.Transport).Proxy == nil {
+		t.Error("proxy from the environment is not used")
+	}
+
+	if _, err := newGandiTransport("proxy.example.com:3128", defaultDialTimeout, defaultKeepAlive, defaultMaxResponseSize, newFakeClock()); err == nil {
+		t.Error("expected an error for a proxy URL without scheme")
+	}
+}
+
+func TestResponseLimitTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := []byte(strings.Repeat("x", 2048))
+		if r.URL.Query().Get("chunked") != "" {
+			// Without Content-Length, the size is only known once read.
+			w.Write(body[:1024])
+			w.(http.Flusher).Flush()
+			body = body[1024:]
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newResponseLimitTransport(http.DefaultTransport, 1024)}
+	for _, query := range []string{"", "?chunked=1"} {
+		resp, err := client.Get(server.URL + "/v5/livedns/domains" + query)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", query, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil || !strings.Contains(err.Error(), "response to GET /v5/livedns/domains exceeds the maximum size of 1024 bytes") {
+			t.Errorf("%q: error = %v, want the maximum size exceeded", query, err)
+		}
+		if len(body) > 1024 {
+			t.Errorf("%q: read %d bytes, want at most 1024", query, len(body))
+		}
+		if isRetryableError(err) {
+			t.Errorf("%q: oversized response is retryable", query)
+		}
+	}
+
+	client = &http.Client{Transport: newResponseLimitTransport(http.DefaultTransport, 2048)}
+	resp, err := client.Get(server.URL + "/v5/livedns/domains?chunked=1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, err := io.ReadAll(resp.Body); err != nil || len(body) != 2048 {
+		t.Errorf("read %d bytes, %v, want the whole response", len(body), err)
+	}
+
+	for v, want := range map[string]int64{"": defaultMaxResponseSize, "4096": 4096, "2Mi": 2 << 20} {
+		t.Setenv("GANDI_MAX_RESPONSE_SIZE", v)
+		if got, err := maxResponseSizeFromEnv(); err != nil || got != want {
+			t.Errorf("%q: got %d, %v, want %d", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"0", "-1", "lots"} {
+		t.Setenv("GANDI_MAX_RESPONSE_SIZE", v)
+		if _, err := maxResponseSizeFromEnv(); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
+
+// countingTransport counts the requests it sends through next, by host.
+type countingTransport struct {
+	next     http.RoundTripper
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.requests[req.URL.Host]++
+	t.mu.Unlock()
+	return t.next.RoundTrip(req)
+}
+
+func TestGandiHostTransport(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	gandiServer, otherServer := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer gandiServer.Close()
+	defer otherServer.Close()
+
+	gandi := &countingTransport{next: http.DefaultTransport, requests: map[string]int{}}
+	other := &countingTransport{next: http.DefaultTransport, requests: map[string]int{}}
+	routing := newGandiHostTransport(gandi, other)
+	gandiRouting = routing
+	defer func() { gandiRouting = nil }()
+
+	// Creating a client for an endpoint routes the requests to its host.
+	newLiveDNSClient(config.Config{APIURL: gandiServer.URL})
+	client := &http.Client{Transport: routing}
+	for _, u := range []string{gandiServer.URL + "/v5/livedns/domains", otherServer.URL + "/metrics"} {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", u, err)
+		}
+		resp.Body.Close()
+	}
+
+	gandiHost, otherHost := strings.TrimPrefix(gandiServer.URL, "http://"), strings.TrimPrefix(otherServer.URL, "http://")
+	if gandi.requests[gandiHost] != 1 || gandi.requests[otherHost] != 0 {
+		t.Errorf("Gandi transport requests = %v, want only the one to %s", gandi.requests, gandiHost)
+	}
+	if other.requests[otherHost] != 1 || other.requests[gandiHost] != 0 {
+		t.Errorf("other transport requests = %v, want only the one to %s", other.requests, otherHost)
+	}
+
+	// go-gandi falls back to its default endpoint without an API URL.
+	routing.addEndpoint("")
+	if !routing.hosts["api.gandi.net"] {
+		t.Error("default Gandi API host is not routed to the Gandi transport")
+	}
+}
+
+func TestClientConfigsAgree(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	solver.defaults = json.RawMessage(`{"apiKeySecretRef": {"name": "gandi-credentials", "key": "api-token"},
+		"credentialScope": "cluster", "credentialNamespace": "default", "apiURL": "https://api.sandbox.gandi.net"}`)
+	var configs []config.Config
+	solver.newClient = func(clientcfg config.Config) liveDNSClient {
+		configs = append(configs, clientcfg)
+		return gandiClient
+	}
+
+	// The startup check of the API keys and a challenge create their
+	// clients the same way.
+	solver.verifyCredentials()
+	if err := solver.Present(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("%d clients created, want 2", len(configs))
+	}
+	if !reflect.DeepEqual(configs[0], configs[1]) {
+		t.Errorf("verification client config %+v differs from challenge client config %+v", configs[0], configs[1])
+	}
+	if configs[0].APIURL != "https://api.sandbox.gandi.net" || configs[0].APIKey != "secret" || configs[0].Debug != !redactLogs {
+		t.Errorf("client config = %+v, want the endpoint, API key and debug setting of the solver", configs[0])
+	}
+}
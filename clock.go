@@ -0,0 +1,20 @@
+package main
+
+import (
+	"time"
+)
+
+// clock abstracts the passage of time so time-dependent behaviour such as
+// polling and timeouts can be tested without real sleeps.
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// maxSecretNotFoundTimeout bounds secretNotFoundTimeout: a secret
+	// missing for longer is a real error.
+	maxSecretNotFoundTimeout = time.Minute
+	// secretNotFoundInterval is the interval between the reads of a secret
+	// that does not exist yet.
+	secretNotFoundInterval = time.Second
+)
+
+// defaultAPIKeySecretKey is the key of the API key within its secret when a
+// secret reference gives none.
+var defaultAPIKeySecretKey = apiKeySecretKeyFromEnv()
+
+// apiKeySecretKeyFromEnv returns the default key set by API_KEY_SECRET_KEY,
+// api-key by default.
+func apiKeySecretKeyFromEnv() string {
+	if v := os.Getenv("API_KEY_SECRET_KEY"); v != "" {
+		return v
+	}
+	return "api-key"
+}
+
+const (
+	// credentialScopeIssuer reads secrets from the namespace of the
+	// challenge.
+	credentialScopeIssuer = "issuer"
+	// credentialScopeCluster reads secrets from a fixed namespace.
+	credentialScopeCluster = "cluster"
+)
+
+// Get Gandi API key from Kubernetes secret for a challenge in namespace. The
+// API key is selected by tag if there is a tag map, else by domain if there
+// is a domain map. Without any secret reference, the API key is read from
+// the environment if ALLOW_ENV_API_KEY allows it.
+func (c *gandiDNSProviderSolver) getApiKey(cfg *gandiDNSProviderConfig, challengeNamespace string, domain string) (*string, error) {
+	namespace := cfg.credentialNamespace(challengeNamespace)
+	if cfg.APIKeyTagMapSecretRef != nil {
+		secBytes, err := c.waitForSecretValue(cfg, cfg.APIKeyTagMapSecretRef, namespace)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys, err := parseApiKeyTagMap(secBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API key tag map in secret \"%s/%s\": %v", namespace,
+				cfg.APIKeyTagMapSecretRef.LocalObjectReference.Name, err)
+		}
+		return selectApiKeyByTag(apiKeys, cfg.apiKeyTag(challengeNamespace))
+	}
+	if cfg.APIKeyMapSecretRef != nil {
+		secBytes, err := c.waitForSecretValue(cfg, cfg.APIKeyMapSecretRef, namespace)
+		if err != nil {
+			return nil, err
+		}
+		apiKeys, err := parseApiKeyMap(secBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API key map in secret \"%s/%s\": %v", namespace,
+				cfg.APIKeyMapSecretRef.LocalObjectReference.Name, err)
+		}
+		return selectApiKey(apiKeys, domain)
+	}
+	if cfg.APIKeySecretRef.Name == "" {
+		if !allowEnvApiKeyFromEnv() {
+			return nil, fmt.Errorf("no API key configured: set apiKeySecretRef in the solver config")
+		}
+		return envApiKey(domain)
+	}
+
+	secBytes, err := c.waitForSecretValue(cfg, &cfg.APIKeySecretRef, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey := string(secBytes)
+	return &apiKey, nil
+}
+
+// allowEnvApiKeyFromEnv reports whether ALLOW_ENV_API_KEY lets the challenges
+// of issuers referencing no secret use the API keys of the environment. Off
+// by default, as they are then handed to any issuer of any namespace.
+func allowEnvApiKeyFromEnv() bool {
+	return os.Getenv("ALLOW_ENV_API_KEY") == "true"
+}
+
+// envApiKey returns the API key of domain set in the environment, used when
+// the config references no secret, such as the empty config of the
+// conformance tests. The variable qualified by domain, see envApiKeyName,
+// takes precedence over GANDI_API_KEY, so one webhook can hold the API keys
+// of several domains without secrets. Like GANDI_API_KEY, it is only used
+// with ALLOW_ENV_API_KEY: any namespace could otherwise get the API key of
+// a domain by asking for it.
+func envApiKey(domain string) (*string, error) {
+	name := envApiKeyName(domain)
+	if apiKey := os.Getenv(name); apiKey != "" {
+		klog.V(6).Infof("using the API key of %s from %s", domain, name)
+		return &apiKey, nil
+	}
+	apiKey := os.Getenv("GANDI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("no API key configured: set apiKeySecretRef in the solver config, %s or GANDI_API_KEY", name)
+	}
+	return &apiKey, nil
+}
+
+// envApiKeyName returns the name of the environment variable holding the API
+// key of domain: GANDI_API_KEY_ followed by the domain in upper case, with
+// dots and hyphens replaced by underscores, e.g. GANDI_API_KEY_EXAMPLE_COM
+// for example.com.
+func envApiKeyName(domain string) string {
+	name := strings.ToUpper(strings.Trim(domain, "."))
+	return "GANDI_API_KEY_" + strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+// waitForSecretValue returns the value referenced by ref in namespace like
+// getSecretValue, reading the secret again while it does not exist for up to
+// the secretNotFoundTimeout of cfg.
+func (c *gandiDNSProviderSolver) waitForSecretValue(cfg *gandiDNSProviderConfig, ref *cmmeta.SecretKeySelector, namespace string) ([]byte, error) {
+	deadline := c.clock.Now().Add(cfg.secretNotFoundTimeout())
+	for {
+		value, err := c.getSecretValue(ref, namespace)
+		if !apierrors.IsNotFound(err) || !c.clock.Now().Before(deadline) {
+			return value, err
+		}
+		klog.V(2).Infof("secret %s/%s not found, reading it again in %s", namespace, ref.LocalObjectReference.Name, secretNotFoundInterval)
+		c.clock.Sleep(secretNotFoundInterval)
+	}
+}
+
+// getSecretValue returns the value referenced by ref in the given namespace.
+// The default key is used if ref has none.
+func (c *gandiDNSProviderSolver) getSecretValue(ref *cmmeta.SecretKeySelector, namespace string) ([]byte, error) {
+	secretName := ref.LocalObjectReference.Name
+	key := ref.Key
+	if key == "" {
+		key = defaultAPIKeySecretKey
+	}
+
+	klog.V(6).Infof("try to load secret `%s` with key `%s`", secretName, key)
+
+	sec, err := c.client.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+	if err != nil {
+		// Wrapped for waitForSecretValue to tell secrets not found.
+		return nil, fmt.Errorf("unable to get secret `%s/%s`; %w", namespace, secretName, err)
+	}
+
+	secBytes, ok := sec.Data[key]
+	if !ok && ref.Key == "" {
+		return nil, fmt.Errorf("no key given and default key %q not found in secret \"%s/%s\"", key,
+			namespace, secretName)
+	}
+	if !ok {
+		return nil, fmt.Errorf("key %q not found in secret \"%s/%s\"", key,
+			namespace, secretName)
+	}
+	return secBytes, nil
+}
+
+// parseApiKeyMap decodes a JSON object mapping domain suffixes to API keys.
+// Suffixes are normalised to lower case without surrounding dots.
+func parseApiKeyMap(data []byte) (map[string]string, error) {
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("expected a JSON object of domain to API key: %v", err)
+	}
+	apiKeys := make(map[string]string, len(raw))
+	for suffix, apiKey := range raw {
+		normalized := strings.ToLower(strings.Trim(suffix, "."))
+		if normalized == "" {
+			return nil, fmt.Errorf("empty domain")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("empty API key for domain %q", suffix)
+		}
+		if _, ok := apiKeys[normalized]; ok {
+			return nil, fmt.Errorf("duplicate domain %q", suffix)
+		}
+		apiKeys[normalized] = apiKey
+	}
+	return apiKeys, nil
+}
+
+// parseApiKeyTagMap decodes a JSON object mapping tags to API keys.
+func parseApiKeyTagMap(data []byte) (map[string]string, error) {
+	var apiKeys map[string]string
+	if err := json.Unmarshal(data, &apiKeys); err != nil {
+		return nil, fmt.Errorf("expected a JSON object of tag to API key: %v", err)
+	}
+	for tag, apiKey := range apiKeys {
+		if tag == "" {
+			return nil, fmt.Errorf("empty tag")
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("empty API key for tag %q", tag)
+		}
+	}
+	return apiKeys, nil
+}
+
+// selectApiKeyByTag returns the API key of tag. There is no fallback to
+// another API key, so one tenant never gets the credentials of another.
+func selectApiKeyByTag(apiKeys map[string]string, tag string) (*string, error) {
+	apiKey, ok := apiKeys[tag]
+	if !ok {
+		return nil, fmt.Errorf("no API key configured for tag %q", tag)
+	}
+	return &apiKey, nil
+}
+
+// selectApiKey returns the API key of the longest domain suffix matching
+// domain on a label boundary.
+func selectApiKey(apiKeys map[string]string, domain string) (*string, error) {
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	best := ""
+	for suffix := range apiKeys {
+		if domain != suffix && !strings.HasSuffix(domain, "."+suffix) {
+			continue
+		}
+		if len(suffix) > len(best) {
+			best = suffix
+		}
+	}
+	if best == "" {
+		return nil, fmt.Errorf("no API key configured for domain %q", domain)
+	}
+	apiKey := apiKeys[best]
+	return &apiKey, nil
+}
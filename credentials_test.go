@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	"github.com/go-gandi/go-gandi/config"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newSecret(namespace, name string, data map[string]string) *corev1.Secret {
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range data {
+		sec.Data[k] = []byte(v)
+	}
+	return sec
+}
+
+func TestGetApiKey(t *testing.T) {
+	c := newGandiDNSProviderSolver()
+	c.client = fake.NewSimpleClientset(newSecret("default", "gandi", map[string]string{"api-token": "secret"}))
+
+	cfg := &gandiDNSProviderConfig{
+		APIKeySecretRef: cmmeta.SecretKeySelector{
+			LocalObjectReference: cmmeta.LocalObjectReference{Name: "gandi"},
+			Key:                  "api-token",
+		},
+	}
+	apiKey, err := c.getApiKey(cfg, "default", "example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *apiKey != "secret" {
+		t.Errorf("API key = %q, want %q", *apiKey, "secret")
+	}
+
+	cfg.APIKeySecretRef.Key = "missing"
+	if _, err := c.getApiKey(cfg, "default", "example.com"); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestEmptyConfigUsesEnvApiKey(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+
+	for _, raw := range []string{"", "{}"} {
+		ch := &v1alpha1.ChallengeRequest{
+			Key:               "key",
+			ResourceNamespace: "default",
+			ResolvedFQDN:      "_acme-challenge.example.com.",
+			ResolvedZone:      "example.com.",
+		}
+		if raw != "" {
+			ch.Config = &extapi.JSON{Raw: []byte(raw)}
+		}
+
+		// The API key of the webhook is not handed out unless allowed.
+		t.Setenv("ALLOW_ENV_API_KEY", "")
+		t.Setenv("GANDI_API_KEY", "secret")
+		if err := solver.Present(ch); err == nil || !strings.Contains(err.Error(), "no API key configured") {
+			t.Fatalf("config %q without ALLOW_ENV_API_KEY: error = %v, want no API key configured", raw, err)
+		}
+
+		t.Setenv("ALLOW_ENV_API_KEY", "true")
+		t.Setenv("GANDI_API_KEY", "")
+		if err := solver.Present(ch); err == nil || !strings.Contains(err.Error(), "no API key configured") {
+			t.Fatalf("config %q without GANDI_API_KEY: error = %v, want no API key configured", raw, err)
+		}
+
+		t.Setenv("GANDI_API_KEY", "secret")
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("config %q: present: %v", raw, err)
+		}
+		if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); len(got) != 1 || got[0] != `"key"` {
+			t.Errorf("config %q: values = %v, want the presented key", raw, got)
+		}
+		if err := solver.CleanUp(ch); err != nil {
+			t.Fatalf("config %q: clean up: %v", raw, err)
+		}
+		if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); got != nil {
+			t.Errorf("config %q: values = %v, want the RRset deleted", raw, got)
+		}
+	}
+}
+
+func TestEnvApiKeyByDomain(t *testing.T) {
+	for _, tt := range []struct {
+		domain string
+		name   string
+	}{
+		{domain: "example.com", name: "GANDI_API_KEY_EXAMPLE_COM"},
+		{domain: "example.com.", name: "GANDI_API_KEY_EXAMPLE_COM"},
+		{domain: "My-Shop.example", name: "GANDI_API_KEY_MY_SHOP_EXAMPLE"},
+	} {
+		if got := envApiKeyName(tt.domain); got != tt.name {
+			t.Errorf("envApiKeyName(%q) = %q, want %q", tt.domain, got, tt.name)
+		}
+	}
+
+	t.Setenv("GANDI_API_KEY", "default")
+	t.Setenv("GANDI_API_KEY_EXAMPLE_COM", "example-com")
+	t.Setenv("GANDI_API_KEY_MY_SHOP_EXAMPLE", "my-shop")
+	t.Setenv("GANDI_API_KEY_EXAMPLE_ORG", "")
+	for _, tt := range []struct {
+		domain string
+		want   string
+	}{
+		{domain: "example.com", want: "example-com"},
+		{domain: "my-shop.example", want: "my-shop"},
+		// Only the root domain selects a key, not its parent domains.
+		{domain: "sub.example.com", want: "default"},
+		{domain: "example.org", want: "default"},
+	} {
+		apiKey, err := envApiKey(tt.domain)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.domain, err)
+		}
+		if *apiKey != tt.want {
+			t.Errorf("%s: API key = %q, want %q", tt.domain, *apiKey, tt.want)
+		}
+	}
+
+	t.Setenv("GANDI_API_KEY", "")
+	if _, err := envApiKey("example.org"); err == nil || !strings.Contains(err.Error(), "GANDI_API_KEY_EXAMPLE_ORG") {
+		t.Errorf("error = %v, want one naming GANDI_API_KEY_EXAMPLE_ORG", err)
+	}
+
+	// Present reads the API key of the zone of the challenge, only if
+	// allowed.
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	var used []string
+	solver.newClient = func(cfg config.Config) liveDNSClient {
+		used = append(used, cfg.APIKey)
+		return gandiClient
+	}
+	ch := &v1alpha1.ChallengeRequest{
+		Key:               "key",
+		ResourceNamespace: "default",
+		ResolvedFQDN:      "_acme-challenge.www.example.com.",
+		ResolvedZone:      "example.com.",
+	}
+	t.Setenv("ALLOW_ENV_API_KEY", "")
+	if err := solver.Present(ch); err == nil || !strings.Contains(err.Error(), "no API key configured") {
+		t.Fatalf("present without ALLOW_ENV_API_KEY: error = %v, want no API key configured", err)
+	}
+	if len(used) != 0 {
+		t.Fatalf("clients created with API keys %v without ALLOW_ENV_API_KEY, want none", used)
+	}
+	t.Setenv("ALLOW_ENV_API_KEY", "true")
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if len(used) == 0 || used[0] != "example-com" {
+		t.Errorf("clients created with API keys %v, want example-com", used)
+	}
+}
+
+func TestSecretNotFoundTimeout(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	reads := 0
+	clientset.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// The secret is created right after the first read.
+		if reads++; reads == 1 {
+			return false, nil, nil
+		}
+		return true, newSecret("default", "gandi", map[string]string{"api-token": "secret"}), nil
+	})
+	c := newGandiDNSProviderSolver()
+	c.clock = newFakeClock()
+	c.client = clientset
+	cfg := &gandiDNSProviderConfig{
+		APIKeySecretRef: cmmeta.SecretKeySelector{
+			LocalObjectReference: cmmeta.LocalObjectReference{Name: "gandi"},
+			Key:                  "api-token",
+		},
+	}
+
+	if _, err := c.getApiKey(cfg, "default", "example.com"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("error = %v, want the secret not found without secretNotFoundTimeout", err)
+	}
+
+	reads = 0
+	cfg.SecretNotFoundTimeout = &metav1.Duration{Duration: 5 * time.Second}
+	apiKey, err := c.getApiKey(cfg, "default", "example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *apiKey != "secret" || reads != 2 {
+		t.Errorf("API key = %q after %d reads, want %q after 2", *apiKey, reads, "secret")
+	}
+}
+
+func TestGetApiKeyDefaultKey(t *testing.T) {
+	c := newGandiDNSProviderSolver()
+	c.client = fake.NewSimpleClientset(
+		newSecret("default", "gandi", map[string]string{"api-key": "secret"}),
+		newSecret("default", "other", map[string]string{"api-token": "secret"}),
+	)
+
+	cfg := &gandiDNSProviderConfig{
+		APIKeySecretRef: cmmeta.SecretKeySelector{
+			LocalObjectReference: cmmeta.LocalObjectReference{Name: "gandi"},
+		},
+	}
+	apiKey, err := c.getApiKey(cfg, "default", "example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *apiKey != "secret" {
+		t.Errorf("API key = %q, want %q", *apiKey, "secret")
+	}
+
+	cfg.APIKeySecretRef.Name = "other"
+	_, err = c.getApiKey(cfg, "default", "example.com")
+	if err == nil || !strings.Contains(err.Error(), `default key "api-key" not found in secret "default/other"`) {
+		t.Errorf("error = %v, want the default key not found", err)
+	}
+}
+
+func TestGetApiKeyFromMap(t *testing.T) {
+	c := newGandiDNSProviderSolver()
+	c.client = fake.NewSimpleClientset(
+		newSecret("default", "gandi", map[string]string{
+			"keys":      `{"example.com": "key-a", "example.org.": "key-b", "Sub.Example.org": "key-c"}`,
+			"malformed": `{"example.com": `,
+		}),
+	)
+
+	cfg := &gandiDNSProviderConfig{
+		APIKeyMapSecretRef: &cmmeta.SecretKeySelector{
+			LocalObjectReference: cmmeta.LocalObjectReference{Name: "gandi"},
+			Key:                  "keys",
+		},
+	}
+
+	tests := []struct {
+		domain string
+		apiKey string
+	}{
+		{domain: "example.com", apiKey: "key-a"},
+		{domain: "EXAMPLE.com.", apiKey: "key-a"},
+		{domain: "example.org", apiKey: "key-b"},
+		{domain: "other.example.org", apiKey: "key-b"},
+		{domain: "sub.example.org", apiKey: "key-c"},
+	}
+	for _, tt := range tests {
+		apiKey, err := c.getApiKey(cfg, "default", tt.domain)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.domain, err)
+			continue
+		}
+		if *apiKey != tt.apiKey {
+			t.Errorf("%s: API key = %q, want %q", tt.domain, *apiKey, tt.apiKey)
+		}
+	}
+
+	if _, err := c.getApiKey(cfg, "default", "myexample.com"); err == nil {
+		t.Error("expected an error for a domain without API key")
+	}
+
+	cfg.APIKeyMapSecretRef.Key = "malformed"
+	_, err := c.getApiKey(cfg, "default", "example.com")
+	if err == nil || !strings.Contains(err.Error(), "invalid API key map") {
+		t.Errorf("expected an invalid API key map error, got %v", err)
+	}
+}
+
+func TestGetApiKeyByTag(t *testing.T) {
+	c := newGandiDNSProviderSolver()
+	c.client = fake.NewSimpleClientset(
+		newSecret("team-a", "gandi", map[string]string{
+			"tags":    `{"team-a": "key-a", "team-b": "key-b"}`,
+			"domains": `{"example.com": "key-domain"}`,
+		}),
+	)
+
+	cfg := &gandiDNSProviderConfig{
+		APIKeyTagMapSecretRef: &cmmeta.SecretKeySelector{
+			LocalObjectReference: cmmeta.LocalObjectReference{Name: "gandi"},
+			Key:                  "tags",
+		},
+		APIKeyMapSecretRef: &cmmeta.SecretKeySelector{
+			LocalObjectReference: cmmeta.LocalObjectReference{Name: "gandi"},
+			Key:                  "domains",
+		},
+	}
+
+	tests := []struct {
+		tag    string
+		apiKey string
+	}{
+		{tag: "", apiKey: "key-a"},
+		{tag: "team-b", apiKey: "key-b"},
+	}
+	for _, tt := range tests {
+		cfg.APIKeyTag = tt.tag
+		apiKey, err := c.getApiKey(cfg, "team-a", "example.com")
+		if err != nil {
+			t.Errorf("tag %q: unexpected error: %v", tt.tag, err)
+			continue
+		}
+		if *apiKey != tt.apiKey {
+			t.Errorf("tag %q: API key = %q, want %q", tt.tag, *apiKey, tt.apiKey)
+		}
+	}
+
+	// An unknown tag must not fall back to the domain map.
+	cfg.APIKeyTag = "team-c"
+	if _, err := c.getApiKey(cfg, "team-a", "example.com"); err == nil || !strings.Contains(err.Error(), `no API key configured for tag "team-c"`) {
+		t.Errorf("expected an error for a tag without API key, got %v", err)
+	}
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "apiKeyTag": "team-a"`)
+	if _, err := loadConfig(ch.Config); err == nil {
+		t.Error("expected an error for apiKeyTag without apiKeyTagMapSecretRef")
+	}
+
+	for _, data := range []string{`[]`, `{"": "key"}`, `{"team-a": ""}`} {
+		if _, err := parseApiKeyTagMap([]byte(data)); err == nil {
+			t.Errorf("expected an error for %s", data)
+		}
+	}
+}
+
+func TestParseApiKeyMap(t *testing.T) {
+	for _, data := range []string{
+		`[]`,
+		`{"example.com": 42}`,
+		`{"": "key"}`,
+		`{"example.com": ""}`,
+		`{"example.com": "a", "example.com.": "b"}`,
+	} {
+		if _, err := parseApiKeyMap([]byte(data)); err == nil {
+			t.Errorf("expected an error for %s", data)
+		}
+	}
+}
+
+func TestCredentialScope(t *testing.T) {
+	c := newGandiDNSProviderSolver()
+	c.client = fake.NewSimpleClientset(
+		newSecret("team-a", "gandi", map[string]string{"api-token": "team-secret"}),
+		newSecret("gandi-credentials", "gandi", map[string]string{"api-token": "cluster-secret"}),
+	)
+	ref := cmmeta.SecretKeySelector{LocalObjectReference: cmmeta.LocalObjectReference{Name: "gandi"}, Key: "api-token"}
+
+	tests := []struct {
+		name string
+		cfg  gandiDNSProviderConfig
+		want string
+	}{
+		{name: "default", cfg: gandiDNSProviderConfig{APIKeySecretRef: ref}, want: "team-secret"},
+		{name: "issuer", cfg: gandiDNSProviderConfig{APIKeySecretRef: ref, CredentialScope: "issuer"}, want: "team-secret"},
+		{name: "cluster", cfg: gandiDNSProviderConfig{APIKeySecretRef: ref, CredentialScope: "cluster", CredentialNamespace: "gandi-credentials"}, want: "cluster-secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.validate(); err != nil {
+				t.Fatal(err)
+			}
+			apiKey, err := c.getApiKey(&tt.cfg, "team-a", "example.com")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *apiKey != tt.want {
+				t.Errorf("API key = %q, want %q", *apiKey, tt.want)
+			}
+		})
+	}
+
+	for _, cfg := range []gandiDNSProviderConfig{
+		{CredentialScope: "cluster"},
+		{CredentialNamespace: "gandi-credentials"},
+		{CredentialScope: "namespace", CredentialNamespace: "gandi-credentials"},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("expected an error for credentialScope %q and credentialNamespace %q", cfg.CredentialScope, cfg.CredentialNamespace)
+		}
+	}
+}
+
+func TestCredentialScopeOnlyFromDefaults(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	solver.client = fake.NewSimpleClientset(newSecret("other-tenant", "gandi-credentials", map[string]string{"api-token": "other-secret"}))
+	var apiKeys []string
+	solver.newClient = func(cfg config.Config) liveDNSClient {
+		apiKeys = append(apiKeys, cfg.APIKey)
+		return gandiClient
+	}
+
+	// An issuer in the default namespace cannot read the secret of another
+	// tenant.
+	for _, extra := range []string{
+		`, "credentialScope": "cluster", "credentialNamespace": "other-tenant"`,
+		`, "credentialNamespace": "other-tenant"`,
+	} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", extra)
+		if err := solver.Present(ch); err == nil || !strings.Contains(err.Error(), "can only be set in the solver defaults") {
+			t.Errorf("%s: error = %v, want a rejected config", extra, err)
+		}
+	}
+	if len(apiKeys) != 0 {
+		t.Errorf("clients created with API keys %v, want none", apiKeys)
+	}
+
+	// The solver defaults can, and issuers may repeat them.
+	solver.defaults = json.RawMessage(`{"credentialScope": "cluster", "credentialNamespace": "other-tenant"}`)
+	for _, extra := range []string{"", `, "credentialScope": "cluster", "credentialNamespace": "other-tenant"`} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", extra)
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("%s: unexpected error: %v", extra, err)
+		}
+	}
+	if len(apiKeys) != 2 || apiKeys[0] != "other-secret" {
+		t.Errorf("clients created with API keys %v, want the secret of the credential namespace", apiKeys)
+	}
+}
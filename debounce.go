@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// maxMinWriteInterval bounds minWriteInterval, and how long writes are
+// remembered.
+const maxMinWriteInterval = 10 * time.Minute
+
+// writeDebouncer remembers the last successful write of every challenge
+// value, so the same write repeated shortly after, e.g. by cert-manager
+// retrying a challenge, can be skipped.
+type writeDebouncer struct {
+	mu     sync.Mutex
+	writes map[string]lastWrite
+}
+
+// lastWrite is whether a value was last presented or cleaned up, and when.
+type lastWrite struct {
+	present bool
+	at      time.Time
+}
+
+func newWriteDebouncer() *writeDebouncer {
+	return &writeDebouncer{writes: map[string]lastWrite{}}
+}
+
+func debounceKey(t accountTarget, key string) string {
+	return t.credential + "/" + trackingKey(recordName(t.root, t.subdomain), key)
+}
+
+// recent reports whether key was presented, or cleaned up if present is
+// false, in the RRset of target less than interval before now.
+func (d *writeDebouncer) recent(t accountTarget, key string, present bool, interval time.Duration, now time.Time) bool {
+	if interval == 0 {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.writes[debounceKey(t, key)]
+	return ok && w.present == present && now.Sub(w.at) < interval
+}
+
+// record remembers that key was presented, or cleaned up if present is
+// false, in the RRset of target at now, and forgets writes too old to
+// matter.
+func (d *writeDebouncer) record(t accountTarget, key string, present bool, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, w := range d.writes {
+		if now.Sub(w.at) >= maxMinWriteInterval {
+			delete(d.writes, k)
+		}
+	}
+	d.writes[debounceKey(t, key)] = lastWrite{present: present, at: now}
+}
+
+// forget forgets the last write of key in the RRset of target, e.g. once it
+// is known to be undone.
+func (d *writeDebouncer) forget(t accountTarget, key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.writes, debounceKey(t, key))
+}
+
+// writeValue presents key in the RRset of target, or cleans it up if present
+// is false, unless that was done less than minWriteInterval ago. Audited
+// targets are never written to, so their writes are not remembered.
+func (c *gandiDNSProviderSolver) writeValue(t accountTarget, key string, present bool, cfg *gandiDNSProviderConfig) error {
+	if c.writes.recent(t, key, present, cfg.minWriteInterval(), c.clock.Now()) {
+		klog.V(4).Infof("TXT value %s for %s was written less than %s ago, skipping the write", keyHash(key), recordName(t.root, t.subdomain), cfg.minWriteInterval())
+		return nil
+	}
+	var err error
+	if present {
+		err = c.presentValue(t, key, cfg.recordOptions())
+	} else {
+		err = c.cleanUpValue(t, key, cfg.recordOptions())
+	}
+	if err == nil && !t.audited() {
+		c.writes.record(t, key, present, c.clock.Now())
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+)
+
+func TestMinWriteInterval(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	clk := solver.clock.(*fakeClock)
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "minWriteInterval": "30s"`)
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	// Presenting again within the interval does not call Gandi at all.
+	gandiClient.ResetCalls()
+	clk.Sleep(10 * time.Second)
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if n := gandiClient.TotalCalls(); n != 0 {
+		t.Errorf("%d calls to Gandi presenting within the interval, want none", n)
+	}
+
+	// Once the interval elapsed, the record is checked again and repaired.
+	if err := gandiClient.DeleteDomainRecord("example.com", "_acme-challenge", "TXT"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Sleep(30 * time.Second)
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("values after the interval = %v, want the key", got)
+	}
+
+	// A clean up within the interval of the last Present is not skipped,
+	// nor is a Present following it.
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("values after clean up = %v, want none", got)
+	}
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("values after presenting again = %v, want the key", got)
+	}
+}
+
+func TestMinWriteIntervalDisabled(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+
+	for i := 0; i < 2; i++ {
+		if err := solver.Present(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := gandiClient.Calls("GetDomainRecordsByName"); n != 2 {
+		t.Errorf("%d reads, want every Present to read the record", n)
+	}
+}
+
+func TestLoadConfigMinWriteInterval(t *testing.T) {
+	for _, interval := range []string{`"0s"`, `"-1s"`, `"11m"`} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "minWriteInterval": `+interval)
+		if _, err := loadConfig(ch.Config); err == nil {
+			t.Errorf("expected an error for minWriteInterval %s", interval)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// defaultsConfigMapKey is the key of the defaults ConfigMap holding the
+// solver config, in the same JSON form as the config of an issuer.
+const defaultsConfigMapKey = "config"
+
+// defaultsConfigMap reads solver config defaults shared by all solvers from
+// a ConfigMap. Like secrets, it is read again on every challenge, so the
+// defaults can be tuned without restarting the webhook. The last valid
+// defaults are kept when the ConfigMap cannot be read or is invalid.
+type defaultsConfigMap struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+
+	mu sync.Mutex
+	// version is the resource version of the ConfigMap last read, empty if
+	// it did not exist.
+	version string
+	config  json.RawMessage
+}
+
+func newDefaultsConfigMap(client kubernetes.Interface, namespace, name string) *defaultsConfigMap {
+	return &defaultsConfigMap{client: client, namespace: namespace, name: name}
+}
+
+// get returns the defaults of the ConfigMap, nil if it does not exist.
+func (d *defaultsConfigMap) get() json.RawMessage {
+	cm, err := d.client.CoreV1().ConfigMaps(d.namespace).Get(context.Background(), d.name, metav1.GetOptions{})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case apierrors.IsNotFound(err):
+		if d.version != "" {
+			klog.Infof("defaults configmap %s/%s was deleted, using no defaults", d.namespace, d.name)
+		}
+		d.version, d.config = "", nil
+	case err != nil:
+		klog.Warningf("Unable to get defaults configmap %s/%s, using the defaults last read: %v", d.namespace, d.name, err)
+	case cm.ResourceVersion != d.version:
+		d.version = cm.ResourceVersion
+		config := json.RawMessage(cm.Data[defaultsConfigMapKey])
+		if _, err := loadConfigWithDefaults(config, nil); err != nil {
+			klog.Errorf("Ignoring invalid defaults configmap %s/%s version %s, using the defaults last read: %v", d.namespace, d.name, cm.ResourceVersion, err)
+			break
+		}
+		d.config = config
+		klog.Infof("loaded solver config defaults from configmap %s/%s version %s", d.namespace, d.name, cm.ResourceVersion)
+	}
+	return d.config
+}
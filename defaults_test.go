@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newDefaultsConfigMapObject(version, config string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "gandi-defaults", Namespace: "cert-manager", ResourceVersion: version},
+		Data:       map[string]string{defaultsConfigMapKey: config},
+	}
+}
+
+func TestDefaultsConfigMap(t *testing.T) {
+	solver := newTestSolver(nil)
+	solver.defaults = json.RawMessage(`{"ttl": 900}`)
+	solver.sharedDefaults = newDefaultsConfigMap(solver.client, "cert-manager", "gandi-defaults")
+	configMaps := solver.client.CoreV1().ConfigMaps("cert-manager")
+	issuer := &extapi.JSON{Raw: []byte(`{"operationTimeout": "2m"}`)}
+
+	check := func(step string, ttl int, operationTimeout, propagationTimeout time.Duration) {
+		t.Helper()
+		cfg, err := solver.solverConfig(issuer)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step, err)
+		}
+		if cfg.TTL != ttl {
+			t.Errorf("%s: ttl = %d, want %d", step, cfg.TTL, ttl)
+		}
+		if got := cfg.operationTimeout(); got != operationTimeout {
+			t.Errorf("%s: operationTimeout = %s, want %s", step, got, operationTimeout)
+		}
+		var got time.Duration
+		if cfg.PropagationTimeout != nil {
+			got = cfg.PropagationTimeout.Duration
+		}
+		if got != propagationTimeout {
+			t.Errorf("%s: propagationTimeout = %s, want %s", step, got, propagationTimeout)
+		}
+	}
+
+	check("no configmap", 900, 2*time.Minute, 0)
+
+	// The solver defaults and the issuer override the ConfigMap.
+	cm := newDefaultsConfigMapObject("1", `{"ttl": 600, "operationTimeout": "1m", "propagationTimeout": "5m"}`)
+	if _, err := configMaps.Create(context.Background(), cm, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	check("created", 900, 2*time.Minute, 5*time.Minute)
+
+	cm = newDefaultsConfigMapObject("2", `{"propagationTimeout": "3m"}`)
+	if _, err := configMaps.Update(context.Background(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	check("updated", 900, 2*time.Minute, 3*time.Minute)
+
+	// Invalid contents are ignored, keeping the defaults last read.
+	for _, config := range []string{`{"propagationTimeout": "-1m"}`, `{"propagationTimeout": `} {
+		cm = newDefaultsConfigMapObject("3"+config, config)
+		if _, err := configMaps.Update(context.Background(), cm, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		check("invalid "+config, 900, 2*time.Minute, 3*time.Minute)
+	}
+
+	if err := configMaps.Delete(context.Background(), "gandi-defaults", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	check("deleted", 900, 2*time.Minute, 0)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dohTimeout bounds a DNS-over-HTTPS query.
+	dohTimeout = 5 * time.Second
+	// dohMessageType is the media type of DNS messages sent over HTTPS.
+	dohMessageType = "application/dns-message"
+	// maxDNSMessageSize is the largest DNS message, as its length is sent
+	// in 16 bits over TCP.
+	maxDNSMessageSize = 65535
+)
+
+// dohResolver is a txtResolver sending its queries to a DNS-over-HTTPS
+// server (RFC 8484), for networks blocking DNS traffic on port 53.
+type dohResolver struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHResolver(address string) *dohResolver {
+	return &dohResolver{url: address, client: &http.Client{Timeout: dohTimeout}}
+}
+
+// validateDoHURL ensures address is the URL of a DNS-over-HTTPS server.
+func validateDoHURL(address string) error {
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.Fragment != "" {
+		return fmt.Errorf("invalid DNS-over-HTTPS URL %q, must be an https URL such as https://cloudflare-dns.com/dns-query", address)
+	}
+	return nil
+}
+
+func (r *dohResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	name = strings.TrimSuffix(name, ".") + "."
+	query, err := newTXTQuery(name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMessageType)
+	req.Header.Set("Accept", dohMessageType)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS server %s returned %s", r.url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDNSMessageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the answer of DNS-over-HTTPS server %s: %v", r.url, err)
+	}
+	if len(body) > maxDNSMessageSize {
+		return nil, fmt.Errorf("DNS-over-HTTPS server %s returned an answer larger than a DNS message", r.url)
+	}
+	return r.parseTXTAnswer(name, body)
+}
+
+// newTXTQuery returns the DNS message querying the TXT records of name. Its
+// ID is 0, as RFC 8484 recommends to make answers cacheable.
+func newTXTQuery(name string) ([]byte, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name %s: %v", name, err)
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: n, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseTXTAnswer returns the TXT records of the DNS message msg answering
+// the query for name, the strings of each joined as net.Resolver does.
+// Error codes are reported like net.Resolver reports them, so a SERVFAIL is
+// recognized as such.
+func (r *dohResolver) parseTXTAnswer(name string, msg []byte) ([]string, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid answer of DNS-over-HTTPS server %s: %v", r.url, err)
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: name, Server: r.url, IsNotFound: true}
+	case dnsmessage.RCodeServerFailure:
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, Server: r.url}
+	default:
+		return nil, &net.DNSError{Err: "DNS error " + header.RCode.String(), Name: name, Server: r.url}
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, fmt.Errorf("invalid answer of DNS-over-HTTPS server %s: %v", r.url, err)
+	}
+	var values []string
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return values, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid answer of DNS-over-HTTPS server %s: %v", r.url, err)
+		}
+		if h.Type != dnsmessage.TypeTXT {
+			// Such as the CNAME records leading to the TXT records.
+			if err := p.SkipAnswer(); err != nil {
+				return nil, fmt.Errorf("invalid answer of DNS-over-HTTPS server %s: %v", r.url, err)
+			}
+			continue
+		}
+		txt, err := p.TXTResource()
+		if err != nil {
+			return nil, fmt.Errorf("invalid answer of DNS-over-HTTPS server %s: %v", r.url, err)
+		}
+		values = append(values, strings.Join(txt.TXT, ""))
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newStubDoHServer returns a DNS-over-HTTPS server answering TXT queries
+// from records, with NXDOMAIN for other names and with rcode if it is not
+// success.
+func newStubDoHServer(t *testing.T, records map[string][][]string, rcode dnsmessage.RCode) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohMessageType {
+			http.Error(w, "unsupported request", http.StatusBadRequest)
+			return
+		}
+		var p dnsmessage.Parser
+		header, err := p.Start(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q, err := p.Question()
+		if err != nil || q.Type != dnsmessage.TypeTXT {
+			http.Error(w, "not a TXT query", http.StatusBadRequest)
+			return
+		}
+		values, ok := records[q.Name.String()]
+		header.Response, header.RCode = true, rcode
+		if rcode == dnsmessage.RCodeSuccess && !ok {
+			header.RCode = dnsmessage.RCodeNameError
+		}
+		b := dnsmessage.NewBuilder(nil, header)
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+		if header.RCode == dnsmessage.RCodeSuccess {
+			// A CNAME leading to the TXT records, as resolvers return
+			// for delegated challenges.
+			target := dnsmessage.MustNewName("_acme-challenge.example.net.")
+			b.CNAMEResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 300}, dnsmessage.CNAMEResource{CNAME: target})
+			for _, txt := range values {
+				b.TXTResource(dnsmessage.ResourceHeader{Name: target, Class: dnsmessage.ClassINET, TTL: 300}, dnsmessage.TXTResource{TXT: txt})
+			}
+		}
+		msg, err := b.Finish()
+		if err != nil {
+			t.Errorf("unable to build the answer: %v", err)
+		}
+		w.Header().Set("Content-Type", dohMessageType)
+		w.Write(msg)
+	}))
+}
+
+func testDoHResolver(server *httptest.Server) *dohResolver {
+	r := newDoHResolver(server.URL + "/dns-query")
+	r.client = server.Client()
+	return r
+}
+
+func TestDoHResolver(t *testing.T) {
+	server := newStubDoHServer(t, map[string][][]string{
+		"_acme-challenge.example.com.": {{"other"}, {"ke", "y"}},
+	}, dnsmessage.RCodeSuccess)
+	defer server.Close()
+	resolver := testDoHResolver(server)
+
+	values, err := resolver.LookupTXT(context.Background(), "_acme-challenge.example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"other", "key"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %q, want %q", values, want)
+	}
+
+	_, err = resolver.LookupTXT(context.Background(), "_acme-challenge.example.org.")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("error = %v, want not found", err)
+	}
+
+	// The propagation wait succeeds once the value is served.
+	resolvers := []namedResolver{{name: server.URL, resolver: resolver}}
+	if err := waitForPropagation(newFakeClock(), resolvers, "_acme-challenge.example.com.", "key", pollSchedule{interval: time.Second}, time.Minute, 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDoHResolverErrors(t *testing.T) {
+	servfail := newStubDoHServer(t, nil, dnsmessage.RCodeServerFailure)
+	defer servfail.Close()
+	if _, err := testDoHResolver(servfail).LookupTXT(context.Background(), "_acme-challenge.example.com."); err == nil || !isServfailError(err) {
+		t.Errorf("error = %v, want SERVFAIL", err)
+	}
+
+	broken := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	if _, err := testDoHResolver(broken).LookupTXT(context.Background(), "_acme-challenge.example.com."); err == nil || !strings.Contains(err.Error(), "503 Service Unavailable") {
+		t.Errorf("error = %v, want the HTTP status", err)
+	}
+
+	garbage := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>"))
+	}))
+	defer garbage.Close()
+	if _, err := testDoHResolver(garbage).LookupTXT(context.Background(), "_acme-challenge.example.com."); err == nil || !strings.Contains(err.Error(), "invalid answer") {
+		t.Errorf("error = %v, want an invalid answer", err)
+	}
+}
+
+func TestPropagationDoHURLConfig(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		valid  bool
+	}{
+		{config: `"propagationDoHURL": "https://cloudflare-dns.com/dns-query"`, valid: true},
+		{config: `"propagationDoHURL": "https://dns.google/dns-query?ct=application/dns-message"`, valid: true},
+		{config: `"propagationDoHURL": "http://cloudflare-dns.com/dns-query"`},
+		{config: `"propagationDoHURL": "cloudflare-dns.com"`},
+		{config: `"propagationDoHURL": "https:///dns-query"`},
+		{config: `"propagationDoHURL": "https://cloudflare-dns.com/dns-query", "propagationNameservers": ["1.1.1.1:53"]`},
+		{config: `"propagationDoHURL": "https://cloudflare-dns.com/dns-query", "propagationAuthoritative": true`},
+	} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", ", "+tt.config)
+		if _, err := loadConfig(ch.Config); (err == nil) != tt.valid {
+			t.Errorf("%s: error = %v, want valid %v", tt.config, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"golang.org/x/net/publicsuffix"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	maxLabelLength = 63  // RFC 1035 section 2.3.4
+	maxNameLength  = 253 // RFC 1035 section 2.3.4, without the final dot
+
+	// apexName is the RRset name Gandi uses for the apex of a zone.
+	apexName = "@"
+)
+
+var domainParseFallbacks = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Name:           "gandi_domain_parse_fallback_total",
+		Help:           "Number of challenges whose zone was guessed from the last two labels of their domain, as the Public Suffix List did not confirm it.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(domainParseFallbacks)
+}
+
+// splitLabels splits a domain name into its labels, dropping empty labels
+// produced by leading, trailing or repeated dots.
+func splitLabels(name string) []string {
+	var labels []string
+	for _, label := range strings.Split(name, ".") {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// validateLabels ensures every label is one Gandi accepts as part of an RRset
+// name, and that the name they form is not too long to exist in DNS.
+// Wildcard labels are rejected so a literal "*" never reaches Gandi. Labels
+// follow the letter-digit-hyphen rule of RFC 1123, except that underscores
+// are valid anywhere, as in "_acme-challenge", since TXT owner names are not
+// restricted to host name syntax.
+func validateLabels(labels []string) error {
+	if name := strings.Join(labels, "."); len(name) > maxNameLength {
+		return fmt.Errorf("name %q is %d characters long, exceeds the DNS limit of %d characters", name, len(name), maxNameLength)
+	}
+	for _, label := range labels {
+		switch {
+		case label == "":
+			return fmt.Errorf("empty label in %q", strings.Join(labels, "."))
+		case label == "*":
+			return fmt.Errorf("wildcard label in %q is not a valid RRset name", strings.Join(labels, "."))
+		case len(label) > maxLabelLength:
+			return fmt.Errorf("label %q exceeds the DNS limit of %d characters", label, maxLabelLength)
+		case strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-"):
+			return fmt.Errorf("label %q starts or ends with a hyphen", label)
+		}
+		for _, r := range label {
+			if !isLabelChar(r) {
+				return fmt.Errorf("label %q contains the invalid character %q", label, r)
+			}
+		}
+	}
+	return nil
+}
+
+// isLabelChar reports whether r may appear in a label accepted by
+// validateLabels. Names are lowercased before being validated, but upper
+// case letters are accepted too.
+func isLabelChar(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	default:
+		return r == '-' || r == '_'
+	}
+}
+
+// extractRootAndSubDomain splits fqdn into the registrable domain managed at
+// Gandi (its last two labels) and the RRset name of entry relative to it.
+// Both are returned in lower case, as DNS names are case-insensitive and
+// Gandi lists its zones in lower case.
+func extractRootAndSubDomain(fqdn string, entry string) (string, string, error) {
+	parts := splitLabels(strings.ToLower(fqdn))
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("domain %q has less than two labels", fqdn)
+	}
+	sub := append(splitLabels(strings.ToLower(entry)), parts[0:len(parts)-2]...)
+	if err := validateLabels(append(sub, parts[len(parts)-2:]...)); err != nil {
+		return "", "", fmt.Errorf("invalid domain %q: %v", fqdn, err)
+	}
+	domain := parts[len(parts)-2] + "." + parts[len(parts)-1]
+	if len(sub) == 0 {
+		return domain, apexName, nil
+	}
+
+	return domain, strings.Join(sub, "."), nil
+}
+
+// registrableDomain returns the domain registered under a public suffix of
+// the ICANN section of the Public Suffix List that name belongs to. It fails
+// when the suffix of name is unknown.
+func registrableDomain(name string) (string, error) {
+	name = strings.ToLower(strings.Trim(name, "."))
+	suffix, icann := publicsuffix.PublicSuffix(name)
+	if !icann {
+		return "", fmt.Errorf("cannot determine the registrable domain of %q: %q is not a known public suffix", name, suffix)
+	}
+	if name == suffix {
+		return "", fmt.Errorf("cannot determine the registrable domain of %q: it is a public suffix", name)
+	}
+	labels := splitLabels(strings.TrimSuffix(name, "."+suffix))
+	return labels[len(labels)-1] + "." + suffix, nil
+}
+
+// rootAndSubDomain splits the challenge record like extractRootAndSubDomain,
+// unless the config names the zone managed at Gandi or asks for the
+// registrable domain to be looked up in the Public Suffix List rather than
+// guessed.
+func (cfg *gandiDNSProviderConfig) rootAndSubDomain(domain, entry string) (string, string, error) {
+	if cfg.ZoneName == "" && !cfg.StrictDomainParsing {
+		root, subdomain, err := extractRootAndSubDomain(domain, entry)
+		if err == nil {
+			checkGuessedZone(recordName(root, subdomain), root)
+		}
+		return root, subdomain, err
+	}
+	fqdn := strings.Join(append(splitLabels(entry), splitLabels(domain)...), ".")
+	if err := validateLabels(splitLabels(fqdn)); err != nil {
+		return "", "", fmt.Errorf("invalid domain %q: %v", fqdn, err)
+	}
+	zone := strings.ToLower(strings.Trim(cfg.ZoneName, "."))
+	if zone == "" {
+		var err error
+		zone, err = registrableDomain(domain)
+		if err != nil {
+			return "", "", fmt.Errorf("%v, set zoneName to the zone managed at Gandi", err)
+		}
+	}
+	subdomain, err := subdomainInZone(fqdn, zone)
+	if err != nil {
+		return "", "", err
+	}
+	return zone, subdomain, nil
+}
+
+// checkGuessedZone counts and logs a zone guessed by extractRootAndSubDomain
+// for fqdn that is not the registrable domain of the Public Suffix List, such
+// as "co.uk" for a name under "example.co.uk", as the record would then be
+// written to the wrong zone.
+func checkGuessedZone(fqdn, zone string) {
+	registrable, err := registrableDomain(fqdn)
+	if err == nil && strings.EqualFold(registrable, zone) {
+		return
+	}
+	domainParseFallbacks.Inc()
+	if err != nil {
+		klog.Warningf("Guessed zone %s for %s from its last two labels: %v; set zoneName if it is not the zone managed at Gandi", zone, fqdn, err)
+		return
+	}
+	klog.Warningf("Guessed zone %s for %s from its last two labels, but its registrable domain is %s; set zoneName to the zone managed at Gandi", zone, fqdn, registrable)
+}
+
+// trimWildcard strips the wildcard label some configurations pass through for
+// wildcard certificates, whether it leads name or follows the challenge label:
+// "*.example.com" becomes "example.com" and "_acme-challenge.*.example.com"
+// becomes "_acme-challenge.example.com". Trailing dots are trimmed.
+func trimWildcard(name string) string {
+	name = strings.TrimPrefix(strings.TrimRight(name, "."), "*.")
+	if rest := strings.TrimPrefix(name, challengeLabel+".*."); rest != name {
+		return challengeLabel + "." + rest
+	}
+	return name
+}
+
+// validateChallengeRequest ensures ch has the fields the solver needs, and
+// that its record is within its zone, whatever the case of either name.
+func validateChallengeRequest(ch *v1alpha1.ChallengeRequest) error {
+	if ch == nil {
+		return fmt.Errorf("no challenge request")
+	}
+	fqdn := trimWildcard(ch.ResolvedFQDN)
+	zone := trimWildcard(ch.ResolvedZone)
+	switch {
+	case fqdn == "":
+		return fmt.Errorf("resolvedFQDN is empty")
+	case zone == "":
+		return fmt.Errorf("resolvedZone is empty")
+	case ch.Key == "":
+		return fmt.Errorf("key is empty")
+	}
+	fqdn, zone = strings.ToLower(fqdn), strings.ToLower(zone)
+	if fqdn != zone && !strings.HasSuffix(fqdn, "."+zone) {
+		return fmt.Errorf("resolvedFQDN %s is not within resolvedZone %s", ch.ResolvedFQDN, ch.ResolvedZone)
+	}
+	return nil
+}
+
+// verifyChallengeName ensures the resolvedFQDN of ch is the challenge record
+// of the name being validated, so a request cannot make the solver write to
+// an arbitrary name of the zone. Names are compared case-insensitively, with
+// wildcard labels stripped.
+func verifyChallengeName(ch *v1alpha1.ChallengeRequest) error {
+	name := trimWildcard(ch.DNSName)
+	if name == "" {
+		return fmt.Errorf("dnsName is empty, unable to verify resolvedFQDN %s", ch.ResolvedFQDN)
+	}
+	if !strings.EqualFold(trimWildcard(ch.ResolvedFQDN), challengeLabel+"."+name) {
+		return fmt.Errorf("resolvedFQDN %s is not the challenge record of dnsName %s", ch.ResolvedFQDN, ch.DNSName)
+	}
+	return nil
+}
+
+// getDomainAndEntry returns the name of the challenge record relative to the
+// resolved zone, and the zone itself. cert-manager passes both names fully
+// qualified with a trailing dot; any number of trailing dots is tolerated, and
+// wildcard labels are stripped. Both are lowercased, so a mixed-case name
+// still matches its zone.
+func (c *gandiDNSProviderSolver) getDomainAndEntry(ch *v1alpha1.ChallengeRequest) (string, string) {
+	fqdn := trimWildcard(strings.ToLower(ch.ResolvedFQDN))
+	domain := trimWildcard(strings.ToLower(ch.ResolvedZone))
+	if fqdn == domain {
+		return "", domain
+	}
+	entry := strings.TrimSuffix(fqdn, "."+domain)
+	return entry, domain
+}
+
+// subdomainInZone returns the RRset name of fqdn relative to zone, apexName
+// for the zone itself.
+func subdomainInZone(fqdn, zone string) (string, error) {
+	fqdn = strings.ToLower(strings.TrimRight(fqdn, "."))
+	zone = strings.ToLower(strings.Trim(zone, "."))
+	if fqdn == zone {
+		return apexName, nil
+	}
+	if !strings.HasSuffix(fqdn, "."+zone) {
+		return "", fmt.Errorf("%s is not within zone %s", fqdn, zone)
+	}
+	return strings.TrimSuffix(fqdn, "."+zone), nil
+}
+
+// recordName returns the fully qualified name of the RRset subdomain of zone
+// root.
+func recordName(root, subdomain string) string {
+	if subdomain == apexName {
+		return root
+	}
+	return subdomain + "." + root
+}
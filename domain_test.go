@@ -0,0 +1,335 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+func TestExtractRootAndSubDomain(t *testing.T) {
+	maxLabel := strings.Repeat("a", maxLabelLength)
+
+	tests := []struct {
+		name      string
+		fqdn      string
+		entry     string
+		root      string
+		subdomain string
+		wantErr   bool
+	}{
+		{name: "apex", fqdn: "example.com", entry: "_acme-challenge", root: "example.com", subdomain: "_acme-challenge"},
+		{name: "subdomain", fqdn: "sub.example.com", entry: "_acme-challenge", root: "example.com", subdomain: "_acme-challenge.sub"},
+		{name: "nested subdomain", fqdn: "a.b.example.com", entry: "_acme-challenge", root: "example.com", subdomain: "_acme-challenge.a.b"},
+		{name: "dots are trimmed", fqdn: ".sub.example.com.", entry: "._acme-challenge.", root: "example.com", subdomain: "_acme-challenge.sub"},
+		{name: "empty entry", fqdn: "sub.example.com", entry: "", root: "example.com", subdomain: "sub"},
+		{name: "zone apex", fqdn: "example.com", entry: "", root: "example.com", subdomain: "@"},
+		{name: "multi label entry", fqdn: "example.com", entry: "_acme-challenge.www", root: "example.com", subdomain: "_acme-challenge.www"},
+		{name: "inner hyphens", fqdn: "my-sub.my-example.com", entry: "_acme-challenge", root: "my-example.com", subdomain: "_acme-challenge.my-sub"},
+		{name: "punycode", fqdn: "xn--bcher-kva.example.com", entry: "_acme-challenge", root: "example.com", subdomain: "_acme-challenge.xn--bcher-kva"},
+		{name: "numeric labels", fqdn: "123.456.example.com", entry: "_acme-challenge", root: "example.com", subdomain: "_acme-challenge.123.456"},
+		{name: "numeric root", fqdn: "sub.42.com", entry: "_acme-challenge", root: "42.com", subdomain: "_acme-challenge.sub"},
+		{name: "max length labels", fqdn: maxLabel + "." + maxLabel + ".com", entry: "_acme-challenge", root: maxLabel + ".com", subdomain: "_acme-challenge." + maxLabel},
+		{name: "mixed case", fqdn: "WWW.Example.COM", entry: "_ACME-Challenge", root: "example.com", subdomain: "_acme-challenge.www"},
+		{name: "wildcard label", fqdn: "a.*.example.com", entry: "_acme-challenge", wantErr: true},
+		{name: "leading hyphen", fqdn: "-sub.example.com", entry: "_acme-challenge", wantErr: true},
+		{name: "trailing hyphen", fqdn: "sub-.example.com", entry: "_acme-challenge", wantErr: true},
+		{name: "trailing hyphen in root", fqdn: "sub.example-.com", entry: "_acme-challenge", wantErr: true},
+		{name: "invalid character", fqdn: "sub/x.example.com", entry: "_acme-challenge", wantErr: true},
+		{name: "over length label", fqdn: maxLabel + "a.example.com", entry: "_acme-challenge", wantErr: true},
+		{name: "over length name", fqdn: maxLabel + "." + maxLabel + "." + maxLabel + "." + maxLabel + ".example.com", entry: "_acme-challenge", wantErr: true},
+		{name: "over length root", fqdn: "sub." + maxLabel + "a.com", entry: "_acme-challenge", wantErr: true},
+		{name: "single label", fqdn: "com", entry: "_acme-challenge", wantErr: true},
+		{name: "empty", fqdn: "", entry: "_acme-challenge", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, subdomain, err := extractRootAndSubDomain(tt.fqdn, tt.entry)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got root=%q subdomain=%q", root, subdomain)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if root != tt.root {
+				t.Errorf("root = %q, want %q", root, tt.root)
+			}
+			if subdomain != tt.subdomain {
+				t.Errorf("subdomain = %q, want %q", subdomain, tt.subdomain)
+			}
+		})
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  []string
+		wantErr bool
+	}{
+		{name: "host name", labels: []string{"www", "example", "com"}},
+		{name: "challenge label", labels: []string{"_acme-challenge", "example", "com"}},
+		{name: "inner hyphens", labels: []string{"a-b--c", "example", "com"}},
+		{name: "punycode", labels: []string{"xn--bcher-kva", "example", "com"}},
+		{name: "digits", labels: []string{"0", "123", "com"}},
+		{name: "upper case", labels: []string{"WWW", "Example", "com"}},
+		{name: "max length label", labels: []string{strings.Repeat("a", maxLabelLength), "com"}},
+		{name: "empty label", labels: []string{"www", "", "com"}, wantErr: true},
+		{name: "leading hyphen", labels: []string{"-www", "example", "com"}, wantErr: true},
+		{name: "trailing hyphen", labels: []string{"www-", "example", "com"}, wantErr: true},
+		{name: "only a hyphen", labels: []string{"-", "example", "com"}, wantErr: true},
+		{name: "wildcard", labels: []string{"*", "example", "com"}, wantErr: true},
+		{name: "embedded wildcard", labels: []string{"a*b", "example", "com"}, wantErr: true},
+		{name: "space", labels: []string{"w w", "example", "com"}, wantErr: true},
+		{name: "slash", labels: []string{"a/b", "example", "com"}, wantErr: true},
+		{name: "at sign", labels: []string{"@", "example", "com"}, wantErr: true},
+		{name: "non-ascii", labels: []string{"bücher", "example", "com"}, wantErr: true},
+		{name: "over length label", labels: []string{strings.Repeat("a", maxLabelLength+1), "com"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLabels(tt.labels)
+			if tt.wantErr && err == nil {
+				t.Errorf("validateLabels(%q) succeeded, want an error", tt.labels)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("validateLabels(%q) = %v, want no error", tt.labels, err)
+			}
+		})
+	}
+}
+
+func TestGetDomainAndEntry(t *testing.T) {
+	tests := []struct {
+		name   string
+		fqdn   string
+		zone   string
+		entry  string
+		domain string
+	}{
+		{name: "trailing dots", fqdn: "_acme-challenge.sub.example.com.", zone: "example.com.", entry: "_acme-challenge.sub", domain: "example.com"},
+		{name: "no trailing dots", fqdn: "_acme-challenge.sub.example.com", zone: "example.com", entry: "_acme-challenge.sub", domain: "example.com"},
+		{name: "only fqdn dotted", fqdn: "_acme-challenge.example.com.", zone: "example.com", entry: "_acme-challenge", domain: "example.com"},
+		{name: "only zone dotted", fqdn: "_acme-challenge.example.com", zone: "example.com.", entry: "_acme-challenge", domain: "example.com"},
+		{name: "multiple trailing dots", fqdn: "_acme-challenge.example.com..", zone: "example.com...", entry: "_acme-challenge", domain: "example.com"},
+		{name: "fqdn is zone", fqdn: "example.com.", zone: "example.com", entry: "", domain: "example.com"},
+		{name: "wildcard fqdn", fqdn: "_acme-challenge.*.sub.example.com.", zone: "example.com.", entry: "_acme-challenge.sub", domain: "example.com"},
+		{name: "wildcard zone", fqdn: "_acme-challenge.example.com.", zone: "*.example.com.", entry: "_acme-challenge", domain: "example.com"},
+		{name: "leading wildcard", fqdn: "*.sub.example.com.", zone: "example.com.", entry: "sub", domain: "example.com"},
+		{name: "mixed case", fqdn: "_acme-challenge.WWW.Example.COM.", zone: "Example.com.", entry: "_acme-challenge.www", domain: "example.com"},
+		{name: "mixed case wildcard", fqdn: "_ACME-CHALLENGE.*.Example.com.", zone: "*.EXAMPLE.com.", entry: "_acme-challenge", domain: "example.com"},
+		{name: "zone is not a label suffix", fqdn: "_acme-challenge.myexample.com.", zone: "example.com.", entry: "_acme-challenge.myexample.com", domain: "example.com"},
+	}
+
+	c := &gandiDNSProviderSolver{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, domain := c.getDomainAndEntry(&v1alpha1.ChallengeRequest{ResolvedFQDN: tt.fqdn, ResolvedZone: tt.zone})
+			if entry != tt.entry {
+				t.Errorf("entry = %q, want %q", entry, tt.entry)
+			}
+			if domain != tt.domain {
+				t.Errorf("domain = %q, want %q", domain, tt.domain)
+			}
+		})
+	}
+}
+
+func TestRootAndSubDomain(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       gandiDNSProviderConfig
+		domain    string
+		entry     string
+		root      string
+		subdomain string
+		wantErr   string
+	}{
+		{name: "heuristic", domain: "example.co.uk", entry: "_acme-challenge", root: "co.uk", subdomain: "_acme-challenge.example"},
+		{name: "strict", cfg: gandiDNSProviderConfig{StrictDomainParsing: true}, domain: "sub.example.co.uk", entry: "_acme-challenge", root: "example.co.uk", subdomain: "_acme-challenge.sub"},
+		{name: "strict apex", cfg: gandiDNSProviderConfig{StrictDomainParsing: true}, domain: "example.com.", entry: "_acme-challenge", root: "example.com", subdomain: "_acme-challenge"},
+		{name: "strict unknown suffix", cfg: gandiDNSProviderConfig{StrictDomainParsing: true}, domain: "example.internal", entry: "_acme-challenge", wantErr: "set zoneName"},
+		{name: "strict public suffix", cfg: gandiDNSProviderConfig{StrictDomainParsing: true}, domain: "co.uk", entry: "_acme-challenge", wantErr: "is a public suffix"},
+		{name: "zone name", cfg: gandiDNSProviderConfig{ZoneName: "example.internal."}, domain: "sub.example.internal", entry: "_acme-challenge", root: "example.internal", subdomain: "_acme-challenge.sub"},
+		{name: "zone name overrides strict", cfg: gandiDNSProviderConfig{ZoneName: "sub.example.com", StrictDomainParsing: true}, domain: "sub.example.com", entry: "_acme-challenge", root: "sub.example.com", subdomain: "_acme-challenge"},
+		{name: "zone name apex", cfg: gandiDNSProviderConfig{ZoneName: "example.com"}, domain: "example.com", entry: "", root: "example.com", subdomain: "@"},
+		{name: "strict zone apex", cfg: gandiDNSProviderConfig{StrictDomainParsing: true}, domain: "example.com", entry: "", root: "example.com", subdomain: "@"},
+		{name: "outside zone name", cfg: gandiDNSProviderConfig{ZoneName: "example.org"}, domain: "example.com", entry: "_acme-challenge", wantErr: "is not within zone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, subdomain, err := tt.cfg.rootAndSubDomain(tt.domain, tt.entry)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if root != tt.root || subdomain != tt.subdomain {
+				t.Errorf("got %q, %q, want %q, %q", root, subdomain, tt.root, tt.subdomain)
+			}
+		})
+	}
+}
+
+func TestPresentAndCleanUpApex(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("example.com.", "example.com.", "key", "")
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if got, want := gandiClient.Values("example.com", "@", "TXT"), []string{`"key"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("apex values = %v, want %v", got, want)
+	}
+	if ok, _ := solver.tracker.Has("example.com", "key"); !ok {
+		t.Error("apex value not tracked under the zone name")
+	}
+
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("clean up: %v", err)
+	}
+	if got := gandiClient.Values("example.com", "@", "TXT"); got != nil {
+		t.Errorf("apex values after clean up = %v, want none", got)
+	}
+}
+
+func TestPresentWildcardFQDN(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("_acme-challenge.*.example.com.", "*.example.com.", "key", "")
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if got, want := gandiClient.Values("example.com", "_acme-challenge", "TXT"), []string{`"key"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("clean up: %v", err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("values after clean up = %v, want none", got)
+	}
+}
+
+func TestPresentMixedCaseFQDN(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("_acme-challenge.WWW.Example.COM.", "Example.com.", "key", "")
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if got, want := gandiClient.Values("example.com", "_acme-challenge.www", "TXT"), []string{`"key"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("clean up: %v", err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge.www", "TXT"); got != nil {
+		t.Errorf("values after clean up = %v, want none", got)
+	}
+}
+
+func TestInvalidChallengeRequest(t *testing.T) {
+	long := strings.Repeat(strings.Repeat("a", maxLabelLength)+".", 4)
+
+	tests := []struct {
+		name string
+		ch   *v1alpha1.ChallengeRequest
+		want string
+	}{
+		{name: "nil", ch: nil, want: "no challenge request"},
+		{name: "empty", ch: &v1alpha1.ChallengeRequest{}, want: "resolvedFQDN is empty"},
+		{name: "dots only", ch: &v1alpha1.ChallengeRequest{ResolvedFQDN: ".", ResolvedZone: "example.com.", Key: "key"}, want: "resolvedFQDN is empty"},
+		{name: "no zone", ch: &v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge.example.com.", Key: "key"}, want: "resolvedZone is empty"},
+		{name: "no key", ch: &v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge.example.com.", ResolvedZone: "example.com."}, want: "key is empty"},
+		{name: "outside zone", ch: &v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge.example.org.", ResolvedZone: "example.com.", Key: "key"}, want: "is not within resolvedZone"},
+		{name: "zone suffix", ch: &v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge.myexample.com.", ResolvedZone: "example.com.", Key: "key"}, want: "is not within resolvedZone"},
+		{name: "over length name", ch: &v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge." + long + "example.com.", ResolvedZone: "example.com.", Key: "key"}, want: "exceeds the DNS limit of 253 characters"},
+	}
+
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := solver.Present(tt.ch); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("present: error = %v, want %q", err, tt.want)
+			}
+			if err := solver.CleanUp(tt.ch); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("clean up: error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+	if n := gandiClient.TotalCalls(); n != 0 {
+		t.Errorf("made %d calls to Gandi for invalid challenge requests", n)
+	}
+}
+
+func TestVerifyChallengeName(t *testing.T) {
+	tests := []struct {
+		name    string
+		dnsName string
+		fqdn    string
+		zone    string
+		want    string
+	}{
+		{name: "matching", dnsName: "www.example.com", fqdn: "_acme-challenge.www.example.com.", zone: "example.com."},
+		{name: "wildcard", dnsName: "*.example.com", fqdn: "_acme-challenge.example.com.", zone: "example.com."},
+		{name: "case", dnsName: "WWW.Example.com", fqdn: "_acme-challenge.www.example.com.", zone: "example.com."},
+		{name: "no dnsName", fqdn: "_acme-challenge.www.example.com.", zone: "example.com.", want: "dnsName is empty"},
+		{name: "other name", dnsName: "www.example.com", fqdn: "_acme-challenge.mail.example.com.", zone: "example.com.", want: "is not the challenge record of dnsName"},
+		{name: "other domain", dnsName: "www.example.org", fqdn: "_acme-challenge.www.example.com.", zone: "example.com.", want: "is not the challenge record of dnsName"},
+		{name: "not a challenge record", dnsName: "example.com", fqdn: "example.com.", zone: "example.com.", want: "is not the challenge record of dnsName"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			solver := newTestSolver(gandiClient)
+			ch := newTestChallengeRequest(tt.fqdn, tt.zone, "key", `, "verifyChallengeName": true`)
+			ch.DNSName = tt.dnsName
+
+			err := solver.Present(ch)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("present: %v", err)
+				}
+				if err := solver.CleanUp(ch); err != nil {
+					t.Fatalf("clean up: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("present: error = %v, want %q", err, tt.want)
+			}
+			if err := solver.CleanUp(ch); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("clean up: error = %v, want %q", err, tt.want)
+			}
+			if n := gandiClient.TotalCalls(); n != 0 {
+				t.Errorf("made %d calls to Gandi for a rejected challenge request", n)
+			}
+		})
+	}
+
+	// Without the option, the names are not compared.
+	gandiClient := fakelivedns.New()
+	ch := newTestChallengeRequest("_acme-challenge.mail.example.com.", "example.com.", "key", "")
+	ch.DNSName = "www.example.com"
+	if err := newTestSolver(gandiClient).Present(ch); err != nil {
+		t.Errorf("present without verifyChallengeName: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// effectiveConfig is the configuration a solver runs with once defaults and
+// environment variables are applied, as logged at startup. It never holds
+// credentials: only the kind of secret reference API keys are read from.
+// Issuers may override the solver config fields per challenge.
+type effectiveConfig struct {
+	Solver              string   `json:"solver"`
+	APIEndpoints        []string `json:"apiEndpoints"`
+	APIVersion          string   `json:"apiVersion"`
+	CredentialSource    string   `json:"credentialSource"`
+	CredentialScope     string   `json:"credentialScope"`
+	TTL                 int      `json:"ttl"`
+	MaxTTL              int      `json:"maxTTL,omitempty"`
+	OperationTimeout    string   `json:"operationTimeout"`
+	DialTimeout         string   `json:"dialTimeout"`
+	KeepAlive           string   `json:"keepAlive"`
+	Proxy               string   `json:"proxy,omitempty"`
+	RetryJitter         string   `json:"retryJitter"`
+	RetryBudget         string   `json:"retryBudget"`
+	RateLimitThreshold  int      `json:"rateLimitThreshold"`
+	WorkerPoolSize      int      `json:"workerPoolSize"`
+	TrackingConfigMap   string   `json:"trackingConfigMap,omitempty"`
+	DefaultsConfigMap   string   `json:"defaultsConfigMap,omitempty"`
+	RedactLogs          bool     `json:"redactLogs"`
+	LogHash             string   `json:"logHash"`
+	WriteQuorum         int      `json:"writeQuorum"`
+	FailOnCleanupError  bool     `json:"failOnCleanupError"`
+	SnapshotBeforeWrite bool     `json:"snapshotBeforeWrite,omitempty"`
+
+	Callback string `json:"callback,omitempty"`
+}
+
+// credentialSource returns the kind of secret reference the API key is read
+// from, the one taking precedence if several are set.
+func (cfg *gandiDNSProviderConfig) credentialSource() string {
+	switch {
+	case cfg.APIKeyTagMapSecretRef != nil:
+		return "apiKeyTagMapSecretRef"
+	case cfg.APIKeyMapSecretRef != nil:
+		return "apiKeyMapSecretRef"
+	case cfg.APIKeySecretRef.Name != "":
+		return "apiKeySecretRef"
+	}
+	return "issuer"
+}
+
+// redactURL returns u without the password it may hold.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.User == nil {
+		return u
+	}
+	return parsed.Redacted()
+}
+
+// effectiveConfig returns the configuration of the solver.
+func (c *gandiDNSProviderSolver) effectiveConfig() (effectiveConfig, error) {
+	cfg, err := c.solverConfig(nil)
+	if err != nil {
+		return effectiveConfig{}, err
+	}
+	dialTimeout, _ := durationFromEnv("GANDI_DIAL_TIMEOUT", defaultDialTimeout)
+	keepAlive, _ := durationFromEnv("GANDI_KEEP_ALIVE", defaultKeepAlive)
+	workers, _ := workerPoolSizeFromEnv()
+
+	effective := effectiveConfig{
+		Solver:              c.name,
+		APIVersion:          cfg.apiVersion(),
+		CredentialSource:    cfg.credentialSource(),
+		CredentialScope:     credentialScopeIssuer,
+		TTL:                 cfg.recordOptions().recordTTL(),
+		MaxTTL:              cfg.MaxTTL,
+		OperationTimeout:    "none",
+		DialTimeout:         dialTimeout.String(),
+		KeepAlive:           keepAlive.String(),
+		Proxy:               redactURL(os.Getenv("GANDI_PROXY_URL")),
+		RetryJitter:         defaultRetryPolicy.jitter,
+		RetryBudget:         defaultRetryPolicy.budget.String(),
+		RateLimitThreshold:  rateLimitSlowdownThreshold,
+		WorkerPoolSize:      workers,
+		TrackingConfigMap:   os.Getenv("TRACKING_CONFIGMAP"),
+		DefaultsConfigMap:   os.Getenv("DEFAULTS_CONFIGMAP"),
+		RedactLogs:          redactLogs,
+		LogHash:             fmt.Sprintf("%s/%d", keyHashAlgorithm, keyHashLength),
+		WriteQuorum:         cfg.writeQuorum(),
+		FailOnCleanupError:  cfg.failOnCleanupError(),
+		SnapshotBeforeWrite: cfg.SnapshotBeforeWrite,
+	}
+	if c.callback != nil {
+		effective.Callback = redactURL(c.callback.url)
+	}
+	for _, endpoint := range cfg.apiEndpoints() {
+		effective.APIEndpoints = append(effective.APIEndpoints, redactURL(endpoint))
+	}
+	if cfg.CredentialScope == credentialScopeCluster {
+		effective.CredentialScope = credentialScopeCluster + ":" + cfg.CredentialNamespace
+	}
+	if timeout := cfg.operationTimeout(); timeout != 0 {
+		effective.OperationTimeout = timeout.String()
+	}
+	return effective, nil
+}
+
+// logEffectiveConfig logs the configuration of the solver.
+func (c *gandiDNSProviderSolver) logEffectiveConfig() {
+	effective, err := c.effectiveConfig()
+	if err != nil {
+		klog.Warningf("Unable to resolve the configuration of solver %s: %v", c.name, err)
+		return
+	}
+	b, err := json.Marshal(effective)
+	if err != nil {
+		klog.Warningf("Unable to encode the configuration of solver %s: %v", c.name, err)
+		return
+	}
+	klog.Infof("Solver %s runs with the configuration %s", c.name, b)
+}
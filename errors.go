@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-gandi/go-gandi/types"
+)
+
+// apiErrorPattern matches the status and message of the errors returned by
+// go-gandi, "404: message", which may be wrapped after a colon or by the
+// "StatusCode: 404 ; Err: " prefix of a go-gandi request error. Numbers
+// elsewhere in the message, such as in zone or record names, are ignored.
+var apiErrorPattern = regexp.MustCompile(`(?:^|: )([45][0-9]{2})(?:: (.*?))?\s*$`)
+
+// apiError returns the HTTP status and the message of the Gandi API reported
+// by err, or 0 and an empty message if there are none.
+func apiError(err error) (int, string) {
+	var reqErr *types.RequestError
+	if errors.As(err, &reqErr) {
+		if reqErr.Err == nil {
+			return reqErr.StatusCode, ""
+		}
+		msg := reqErr.Err.Error()
+		if m := apiErrorPattern.FindStringSubmatch(msg); m != nil {
+			msg = m[2]
+		}
+		return reqErr.StatusCode, msg
+	}
+	m := apiErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, ""
+	}
+	code, _ := strconv.Atoi(m[1])
+	return code, m[2]
+}
+
+// errorStatusCode returns the HTTP status reported by err, or 0 if there is
+// none.
+func errorStatusCode(err error) int {
+	code, _ := apiError(err)
+	return code
+}
+
+const (
+	// errorRetryable errors are retried with backoff.
+	errorRetryable = "retryable"
+	// errorPermanent errors fail the challenge right away.
+	errorPermanent = "permanent"
+	// errorMaintenance errors are retried as Gandi being down for
+	// maintenance.
+	errorMaintenance = "maintenance"
+	// errorSuspended errors fail the challenge as the domain being suspended.
+	errorSuspended = "suspended"
+	// errorNotOnLiveDNS errors fail the challenge as the domain not being
+	// managed by LiveDNS.
+	errorNotOnLiveDNS = "notOnLiveDNS"
+	// errorNotFound errors are read as the RRset not existing.
+	errorNotFound = "notFound"
+	// errorAlreadyExists errors are read as the RRset existing already.
+	errorAlreadyExists = "alreadyExists"
+	// errorMissingScope errors fail the challenge as the API key lacking
+	// the permission to manage DNS records.
+	errorMissingScope = "missingScope"
+)
+
+// errorOverride classifies the errors whose message matches Pattern as
+// Class, instead of the built-in classification.
+type errorOverride struct {
+	Pattern string `json:"pattern"`
+	Class   string `json:"class"`
+
+	re *regexp.Regexp
+}
+
+// errorOverrides are checked in order before the built-in classification,
+// the first matching an error deciding its class.
+var errorOverrides []errorOverride
+
+// errorOverridesFromEnv returns the overrides set by ERROR_CLASS_OVERRIDES,
+// a JSON list of patterns and classes, none if it is not set.
+func errorOverridesFromEnv() ([]errorOverride, error) {
+	env := os.Getenv("ERROR_CLASS_OVERRIDES")
+	if env == "" {
+		return nil, nil
+	}
+	var overrides []errorOverride
+	if err := json.Unmarshal([]byte(env), &overrides); err != nil {
+		return nil, fmt.Errorf("error decoding error class overrides: %v", err)
+	}
+	for i := range overrides {
+		o := &overrides[i]
+		switch o.Class {
+		case errorRetryable, errorPermanent, errorMaintenance, errorSuspended, errorNotOnLiveDNS, errorNotFound, errorAlreadyExists, errorMissingScope:
+		default:
+			return nil, fmt.Errorf("override %d: unknown class %q, must be %q, %q, %q, %q, %q, %q, %q or %q", i, o.Class,
+				errorRetryable, errorPermanent, errorMaintenance, errorSuspended, errorNotOnLiveDNS, errorNotFound, errorAlreadyExists, errorMissingScope)
+		}
+		if o.Pattern == "" {
+			return nil, fmt.Errorf("override %d has no pattern", i)
+		}
+		re, err := regexp.Compile(o.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("override %d: invalid pattern: %v", i, err)
+		}
+		o.re = re
+	}
+	return overrides, nil
+}
+
+// overriddenClass returns the class of the first of errorOverrides matching
+// the message of err, if any.
+func overriddenClass(err error) (string, bool) {
+	for _, o := range errorOverrides {
+		if o.re.MatchString(err.Error()) {
+			return o.Class, true
+		}
+	}
+	return "", false
+}
+
+// isNotFoundError reports whether err is Gandi not finding an RRset or the
+// domain it belongs to.
+func isNotFoundError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if class, ok := overriddenClass(err); ok {
+		return class == errorNotFound || class == errorNotOnLiveDNS
+	}
+	return errorStatusCode(err) == 404
+}
+
+// isAlreadyExistsError reports whether err is the error Gandi returns when
+// creating an RRset that already exists.
+func isAlreadyExistsError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if class, ok := overriddenClass(err); ok {
+		return class == errorAlreadyExists
+	}
+	return errorStatusCode(err) == 409 || strings.Contains(strings.ToLower(err.Error()), "already exists")
+}
+
+// isRetryableError reports whether err is transient: a rate limit, a server
+// side error or a failure to reach Gandi at all.
+func isRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if class, ok := overriddenClass(err); ok {
+		return class == errorRetryable || class == errorMaintenance
+	}
+	if isSuspendedError(err) {
+		return false
+	}
+	switch errorStatusCode(err) {
+	case 429, 500, 502, 503, 504:
+		return true
+	}
+	return isConnectionError(err)
+}
+
+// isConnectionError reports whether err is a failure to reach Gandi, rather
+// than an error response.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isMaintenanceError reports whether err indicates that Gandi is down for
+// maintenance.
+func isMaintenanceError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if class, ok := overriddenClass(err); ok {
+		return class == errorMaintenance
+	}
+	return errorStatusCode(err) == 503 && strings.Contains(strings.ToLower(err.Error()), "maintenance")
+}
+
+// isSuspendedError reports whether err is Gandi refusing to act on a domain
+// that is suspended or on hold, e.g. for an unpaid renewal or an abuse
+// report. Retrying does not help until the domain is reinstated. Only the
+// message of the API is looked at, not the names of the record or zone.
+func isSuspendedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if class, ok := overriddenClass(err); ok {
+		return class == errorSuspended
+	}
+	code, msg := apiError(err)
+	switch code {
+	case 403, 423, 503:
+	default:
+		return false
+	}
+	msg = strings.ToLower(msg)
+	for _, s := range []string{"suspended", "on hold", "on-hold", "clienthold", "serverhold"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNotOnLiveDNSError reports whether err is Gandi not finding the domain
+// itself in LiveDNS, as opposed to a record of it: the domain does not exist
+// or still uses Gandi's classic DNS.
+func isNotOnLiveDNSError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if class, ok := overriddenClass(err); ok {
+		return class == errorNotOnLiveDNS
+	}
+	code, msg := apiError(err)
+	if code != 404 {
+		return false
+	}
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "domain") && !strings.Contains(msg, "record")
+}
+
+// isMissingScopeError reports whether err is Gandi refusing a request as the
+// API key lacks the permission for it, such as the key of a user without
+// technical rights on the domain.
+func isMissingScopeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if class, ok := overriddenClass(err); ok {
+		return class == errorMissingScope
+	}
+	if errorStatusCode(err) != 403 {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"scope", "permission", "insufficient"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// explainDomainError explains err if it is Gandi refusing to act on the
+// domain root as a whole or the API key lacking permissions on it, and
+// returns it unchanged otherwise.
+func explainDomainError(root string, err error) error {
+	switch {
+	case isSuspendedError(err):
+		return fmt.Errorf("domain %s is suspended at Gandi; challenge cannot proceed: %v", root, err)
+	case isMissingScopeError(err):
+		return fmt.Errorf("the API key lacks the permission to manage the DNS records of domain %s; "+
+			"use the API key of a Gandi user with technical rights on the domain: %v", root, err)
+	case isNotOnLiveDNSError(err):
+		return fmt.Errorf("domain %s is not managed by Gandi LiveDNS; migrate it from classic DNS to LiveDNS in the Gandi admin, "+
+			"or set zoneName to the zone LiveDNS manages: %v", root, err)
+	}
+	return err
+}
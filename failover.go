@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"k8s.io/klog/v2"
+)
+
+// endpointClient is a client of a single Gandi API endpoint.
+type endpointClient struct {
+	endpoint string
+	client   liveDNSClient
+}
+
+// failoverClient sends every call to the first endpoint, and to the next ones
+// in turn as long as they cannot be reached. Error responses are returned as
+// is: an endpoint answering is not failed over.
+type failoverClient struct {
+	endpoints []endpointClient
+}
+
+// newFailoverClient returns a client of clientcfg for every endpoint, failing
+// over from one to the next. With a single endpoint, the client is returned
+// as is.
+func (c *gandiDNSProviderSolver) newFailoverClient(clientcfg config.Config, endpoints []string) liveDNSClient {
+	if len(endpoints) <= 1 {
+		return c.newClient(clientcfg)
+	}
+	f := &failoverClient{}
+	for _, endpoint := range endpoints {
+		endpointcfg := clientcfg
+		withEndpoint(endpoint)(&endpointcfg)
+		f.endpoints = append(f.endpoints, endpointClient{endpoint: endpoint, client: c.newClient(endpointcfg)})
+	}
+	return f
+}
+
+func (f *failoverClient) do(op string, fn func(liveDNSClient) error) error {
+	var err error
+	for i, e := range f.endpoints {
+		err = fn(e.client)
+		if !isConnectionError(err) {
+			if i > 0 {
+				klog.V(2).Infof("%s served by endpoint %s after %d unreachable endpoints", op, e.endpoint, i)
+			} else {
+				klog.V(6).Infof("%s served by endpoint %s", op, e.endpoint)
+			}
+			return err
+		}
+		klog.V(4).Infof("%s failed to reach endpoint %s: %v", op, e.endpoint, err)
+	}
+	return err
+}
+
+func (f *failoverClient) GetDomainRecordsByName(fqdn, name string) (records []livedns.DomainRecord, err error) {
+	err = f.do("GetDomainRecordsByName", func(client liveDNSClient) error {
+		records, err = client.GetDomainRecordsByName(fqdn, name)
+		return err
+	})
+	return
+}
+
+func (f *failoverClient) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (record livedns.DomainRecord, err error) {
+	err = f.do("GetDomainRecordByNameAndType", func(client liveDNSClient) error {
+		record, err = client.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+		return err
+	})
+	return
+}
+
+func (f *failoverClient) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (response types.StandardResponse, err error) {
+	err = f.do("CreateDomainRecord", func(client liveDNSClient) error {
+		response, err = client.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+		return err
+	})
+	return
+}
+
+func (f *failoverClient) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (response types.StandardResponse, err error) {
+	err = f.do("UpdateDomainRecordByNameAndType", func(client liveDNSClient) error {
+		response, err = client.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+		return err
+	})
+	return
+}
+
+func (f *failoverClient) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	return f.do("DeleteDomainRecord", func(client liveDNSClient) error {
+		return client.DeleteDomainRecord(fqdn, name, recordtype)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/config"
+)
+
+func TestFailoverClient(t *testing.T) {
+	clients := map[string]*fakelivedns.Client{
+		"https://eu.example.com": fakelivedns.New(),
+		"https://us.example.com": fakelivedns.New(),
+	}
+	solver := newGandiDNSProviderSolver()
+	solver.newClient = func(cfg config.Config) liveDNSClient { return clients[cfg.APIURL] }
+
+	gandiClient := solver.newFailoverClient(config.Config{}, []string{"https://eu.example.com", "https://us.example.com"})
+
+	// Unreachable endpoints are failed over.
+	clients["https://eu.example.com"].Err = &netError{errors.New("dial tcp: connect: connection refused")}
+	if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"key"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := clients["https://us.example.com"].Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, []string{`"key"`}) {
+		t.Errorf("values at the second endpoint = %v, want the key", got)
+	}
+
+	// Error responses are not.
+	clients["https://eu.example.com"].Err = nil
+	if err := gandiClient.DeleteDomainRecord("example.com", "_acme-challenge", "TXT"); err == nil || errorStatusCode(err) != 404 {
+		t.Errorf("error = %v, want the 404 of the first endpoint", err)
+	}
+	if n := clients["https://us.example.com"].Calls("DeleteDomainRecord"); n != 0 {
+		t.Errorf("second endpoint called %d times after an error response", n)
+	}
+}
+
+func TestAPIEndpoints(t *testing.T) {
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "apiURLs": ["https://eu.example.com", "https://us.example.com/"]`)
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"https://eu.example.com", "https://us.example.com"}
+	if got := cfg.apiEndpoints(); !reflect.DeepEqual(got, want) {
+		t.Errorf("endpoints = %v, want %v", got, want)
+	}
+
+	for _, config := range []string{
+		`, "apiURLs": ["eu.example.com"]`,
+		`, "apiURL": "https://api.gandi.net", "apiURLs": ["https://eu.example.com"]`,
+	} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", config)
+		if _, err := loadConfig(ch.Config); err == nil {
+			t.Errorf("%s: expected an error", config)
+		}
+	}
+}
+
+// netError is a connection failure, as returned by the HTTP client.
+type netError struct{ error }
+
+func (netError) Timeout() bool   { return false }
+func (netError) Temporary() bool { return false }
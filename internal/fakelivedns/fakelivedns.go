@@ -0,0 +1,323 @@
+// Package fakelivedns provides an in-memory Gandi LiveDNS client for tests.
+//
+// Client stores the RRsets of each zone in memory and behaves like Gandi as
+// seen through go-gandi: TXT values are returned enclosed in double quotes,
+// reading or deleting a missing RRset fails with a 404 error and creating an
+// existing one with a 409 error. Errors carry their HTTP status in their
+// message, as go-gandi's do. Faults can be injected per method.
+package fakelivedns
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+// Client is an in-memory LiveDNS client. It counts the calls made per method.
+type Client struct {
+	mu sync.Mutex
+	// zones maps a zone to its RRsets, by name and type.
+	zones map[string]map[string]livedns.DomainRecord
+	calls map[string]int
+	// faults holds the errors the next calls of a method return.
+	faults map[string][]error
+	// duplicates holds extra RRsets returned when listing the RRsets of a
+	// name, as Gandi would in an inconsistent state.
+	duplicates map[string][]livedns.DomainRecord
+	stale      map[string]*livedns.DomainRecord
+	lag        int
+	written    []string
+
+	// Err is returned by every call if set, UpdateErr and DeleteErr only by
+	// updates and deletes.
+	Err       error
+	UpdateErr error
+	DeleteErr error
+	// StaleReads is the number of reads after a create or update that still
+	// return the RRset as it was before the write.
+	StaleReads int
+}
+
+// New returns a client without any zone. Zones are created along with their
+// first RRset.
+func New() *Client {
+	return &Client{
+		zones:      map[string]map[string]livedns.DomainRecord{},
+		calls:      map[string]int{},
+		faults:     map[string][]error{},
+		duplicates: map[string][]livedns.DomainRecord{},
+		stale:      map[string]*livedns.DomainRecord{},
+	}
+}
+
+func recordKey(name, recordtype string) string {
+	return name + "/" + recordtype
+}
+
+// Quote encloses the values not already quoted in double quotes, the way
+// Gandi stores TXT values.
+func Quote(values []string) []string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		if !strings.HasPrefix(v, "\"") {
+			v = "\"" + v + "\""
+		}
+		quoted = append(quoted, v)
+	}
+	return quoted
+}
+
+// StatusError returns an error with the given HTTP status, formatted like the
+// errors of go-gandi.
+func StatusError(code int, message string) error {
+	return fmt.Errorf("%d: %s", code, message)
+}
+
+// Fail makes the next n calls of method fail with the given HTTP status,
+// e.g. 429 or 500, before it behaves normally again.
+func (f *Client) Fail(method string, code, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < n; i++ {
+		f.faults[method] = append(f.faults[method], StatusError(code, http.StatusText(code)))
+	}
+}
+
+// Set stores record in zone as is, without quoting its values.
+func (f *Client) Set(zone string, record livedns.DomainRecord) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.zone(zone)[recordKey(record.RrsetName, record.RrsetType)] = record
+}
+
+// AddDuplicate adds record to the RRsets returned when listing the RRsets of
+// its name in zone, next to the one stored. An update of the name and type
+// drops the duplicates.
+func (f *Client) AddDuplicate(zone string, record livedns.DomainRecord) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := zone + "/" + recordKey(record.RrsetName, record.RrsetType)
+	f.duplicates[key] = append(f.duplicates[key], record)
+}
+
+// Duplicates returns the number of duplicate RRsets left.
+func (f *Client) Duplicates() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, records := range f.duplicates {
+		n += len(records)
+	}
+	return n
+}
+
+// Calls returns the number of calls made to method.
+func (f *Client) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// CallCounts returns the number of calls made per method.
+func (f *Client) CallCounts() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]int, len(f.calls))
+	for method, n := range f.calls {
+		counts[method] = n
+	}
+	return counts
+}
+
+// ResetCalls forgets the calls made so far.
+func (f *Client) ResetCalls() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = map[string]int{}
+}
+
+// TotalCalls returns the number of calls made to all methods.
+func (f *Client) TotalCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	total := 0
+	for _, n := range f.calls {
+		total += n
+	}
+	return total
+}
+
+// Values returns the stored values of an RRset, or nil if it does not exist.
+func (f *Client) Values(zone, name, recordtype string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.zones[zone][recordKey(name, recordtype)].RrsetValues
+}
+
+// Written returns the values of the last create or update as sent.
+func (f *Client) Written() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.written
+}
+
+// zone returns the RRsets of zone, creating it if needed.
+func (f *Client) zone(zone string) map[string]livedns.DomainRecord {
+	records, ok := f.zones[zone]
+	if !ok {
+		records = map[string]livedns.DomainRecord{}
+		f.zones[zone] = records
+	}
+	return records
+}
+
+// call counts a call to method and returns the error it fails with, if any.
+func (f *Client) call(method string) error {
+	f.calls[method]++
+	if faults := f.faults[method]; len(faults) > 0 {
+		f.faults[method] = faults[1:]
+		return faults[0]
+	}
+	return f.Err
+}
+
+// snapshot keeps the RRset stored under key for stale reads.
+func (f *Client) snapshot(zone, key string) {
+	if f.StaleReads == 0 {
+		return
+	}
+	f.stale = map[string]*livedns.DomainRecord{}
+	if record, ok := f.zones[zone][key]; ok {
+		f.stale[zone+"/"+key] = &record
+	} else {
+		f.stale[zone+"/"+key] = nil
+	}
+	f.lag = f.StaleReads
+}
+
+// read returns the RRset stored under key as a read sees it.
+func (f *Client) read(zone, key string) (livedns.DomainRecord, bool) {
+	record, ok := f.zones[zone][key]
+	if old, isStale := f.stale[zone+"/"+key]; isStale && f.lag > 0 {
+		f.lag--
+		record, ok = livedns.DomainRecord{}, old != nil
+		if ok {
+			record = *old
+		}
+	}
+	record.RrsetValues = append([]string(nil), record.RrsetValues...)
+	return record, ok
+}
+
+func notFound(name, recordtype string) error {
+	return StatusError(http.StatusNotFound, fmt.Sprintf("Can't find the DNS record %s/%s in LiveDNS", name, recordtype))
+}
+
+func (f *Client) ListDomains() ([]livedns.Domain, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("ListDomains"); err != nil {
+		return nil, err
+	}
+	zones := make([]string, 0, len(f.zones))
+	for zone := range f.zones {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	domains := make([]livedns.Domain, 0, len(zones))
+	for _, zone := range zones {
+		domains = append(domains, livedns.Domain{FQDN: zone})
+	}
+	return domains, nil
+}
+
+func (f *Client) GetDomainRecordsByName(fqdn, name string) ([]livedns.DomainRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetDomainRecordsByName"); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for key := range f.zones[fqdn] {
+		if strings.HasPrefix(key, name+"/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var records []livedns.DomainRecord
+	for _, key := range keys {
+		if record, ok := f.read(fqdn, key); ok {
+			records = append(records, record)
+		}
+		records = append(records, f.duplicates[fqdn+"/"+key]...)
+	}
+	return records, nil
+}
+
+func (f *Client) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("GetDomainRecordByNameAndType"); err != nil {
+		return livedns.DomainRecord{}, err
+	}
+	record, ok := f.read(fqdn, recordKey(name, recordtype))
+	if !ok {
+		return livedns.DomainRecord{}, notFound(name, recordtype)
+	}
+	return record, nil
+}
+
+func (f *Client) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("CreateDomainRecord"); err != nil {
+		return types.StandardResponse{}, err
+	}
+	key := recordKey(name, recordtype)
+	if _, ok := f.zones[fqdn][key]; ok {
+		return types.StandardResponse{}, StatusError(http.StatusConflict, "A record with that name already exists")
+	}
+	f.snapshot(fqdn, key)
+	f.written = append([]string(nil), values...)
+	f.zone(fqdn)[key] = livedns.DomainRecord{RrsetType: recordtype, RrsetTTL: ttl, RrsetName: name, RrsetValues: Quote(values)}
+	return types.StandardResponse{Message: "DNS Record Created"}, nil
+}
+
+func (f *Client) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (types.StandardResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("UpdateDomainRecordByNameAndType"); err != nil {
+		return types.StandardResponse{}, err
+	}
+	if f.UpdateErr != nil {
+		return types.StandardResponse{}, f.UpdateErr
+	}
+	key := recordKey(name, recordtype)
+	f.snapshot(fqdn, key)
+	f.written = append([]string(nil), values...)
+	f.zone(fqdn)[key] = livedns.DomainRecord{RrsetType: recordtype, RrsetTTL: ttl, RrsetName: name, RrsetValues: Quote(values)}
+	delete(f.duplicates, fqdn+"/"+key)
+	return types.StandardResponse{Message: "DNS Record Created"}, nil
+}
+
+func (f *Client) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call("DeleteDomainRecord"); err != nil {
+		return err
+	}
+	if f.DeleteErr != nil {
+		return f.DeleteErr
+	}
+	key := recordKey(name, recordtype)
+	if _, ok := f.zones[fqdn][key]; !ok {
+		return notFound(name, recordtype)
+	}
+	delete(f.zones[fqdn], key)
+	return nil
+}
@@ -0,0 +1,65 @@
+package fakelivedns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	f := New()
+	if _, err := f.GetDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT"); err == nil || !strings.HasPrefix(err.Error(), "404: ") {
+		t.Errorf("get of a missing RRset: error = %v, want a 404 error", err)
+	}
+	if err := f.DeleteDomainRecord("example.com", "_acme-challenge", "TXT"); err == nil || !strings.HasPrefix(err.Error(), "404: ") {
+		t.Errorf("delete of a missing RRset: error = %v, want a 404 error", err)
+	}
+
+	if _, err := f.CreateDomainRecord("example.com", "_acme-challenge", "TXT", 300, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.CreateDomainRecord("example.com", "_acme-challenge", "TXT", 300, []string{"b"}); err == nil || !strings.HasPrefix(err.Error(), "409: ") {
+		t.Errorf("create of an existing RRset: error = %v, want a 409 error", err)
+	}
+	if _, err := f.UpdateDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT", 300, []string{"a", `"b"`}); err != nil {
+		t.Fatal(err)
+	}
+	record, err := f.GetDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`"a"`, `"b"`}; !reflect.DeepEqual(record.RrsetValues, want) {
+		t.Errorf("values = %v, want %v", record.RrsetValues, want)
+	}
+	if got := f.Values("example.org", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("values in another zone = %v, want none", got)
+	}
+	if err := f.DeleteDomainRecord("example.com", "_acme-challenge", "TXT"); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("values after delete = %v, want none", got)
+	}
+}
+
+func TestClientFail(t *testing.T) {
+	f := New()
+	f.Fail("CreateDomainRecord", 429, 1)
+	f.Fail("CreateDomainRecord", 500, 1)
+	for _, want := range []string{"429: Too Many Requests", "500: Internal Server Error", ""} {
+		_, err := f.CreateDomainRecord("example.com", "_acme-challenge", "TXT", 300, []string{"a"})
+		if got := errorString(err); got != want {
+			t.Errorf("error = %q, want %q", got, want)
+		}
+	}
+	if n := f.Calls("CreateDomainRecord"); n != 3 {
+		t.Errorf("calls = %d, want 3", n)
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+// livednsDomainsPath is the path of the LiveDNS domains on the Gandi API.
+const livednsDomainsPath = "/v5/livedns/domains/"
+
+// fakeGandiServer emulates the LiveDNS RRset endpoints of the Gandi API.
+type fakeGandiServer struct {
+	*httptest.Server
+
+	apiKey  string
+	mu      sync.Mutex
+	records map[string]livedns.DomainRecord
+	// requests records the method and path of every request.
+	requests []string
+}
+
+func newFakeGandiServer(t *testing.T, apiKey string) *fakeGandiServer {
+	s := &fakeGandiServer{apiKey: apiKey, records: map[string]livedns.DomainRecord{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Values returns the values of an RRset, or nil if it does not exist.
+func (s *fakeGandiServer) Values(zone, name, recordtype string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[zone+"/"+name+"/"+recordtype].RrsetValues
+}
+
+func (s *fakeGandiServer) reply(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (s *fakeGandiServer) error(w http.ResponseWriter, code int, message string) {
+	s.reply(w, code, map[string]interface{}{"code": code, "message": message, "object": "HTTPError"})
+}
+
+func (s *fakeGandiServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	if !strings.HasSuffix(r.Header.Get("Authorization"), s.apiKey) {
+		s.error(w, http.StatusForbidden, "Access was denied to this resource.")
+		return
+	}
+
+	// Paths are exactly /v5/livedns/domains/<zone>/records[/<name>[/<type>]],
+	// so a client sending requests to another path fails.
+	if !strings.HasPrefix(r.URL.Path, livednsDomainsPath) {
+		s.error(w, http.StatusNotFound, "The resource could not be found.")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, livednsDomainsPath), "/")
+	if len(parts) < 2 || parts[1] != "records" || containsString(parts, "") {
+		s.error(w, http.StatusNotFound, "The resource could not be found.")
+		return
+	}
+	zone := parts[0]
+
+	var body livedns.DomainRecord
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			s.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if len(parts) == 2 && r.Method == http.MethodPost {
+		key := zone + "/" + body.RrsetName + "/" + body.RrsetType
+		if _, ok := s.records[key]; ok {
+			s.error(w, http.StatusConflict, "A record with that name already exists")
+			return
+		}
+		body.RrsetValues = fakelivedns.Quote(body.RrsetValues)
+		s.records[key] = body
+		s.reply(w, http.StatusCreated, map[string]string{"message": "DNS Record Created"})
+		return
+	}
+	if len(parts) == 3 && r.Method == http.MethodGet {
+		records := []livedns.DomainRecord{}
+		for key, record := range s.records {
+			if strings.HasPrefix(key, zone+"/"+parts[2]+"/") {
+				records = append(records, record)
+			}
+		}
+		s.reply(w, http.StatusOK, records)
+		return
+	}
+	if len(parts) != 4 {
+		s.error(w, http.StatusNotFound, "The resource could not be found.")
+		return
+	}
+
+	key := zone + "/" + parts[2] + "/" + parts[3]
+	record, ok := s.records[key]
+	switch r.Method {
+	case http.MethodGet:
+		if !ok {
+			s.error(w, http.StatusNotFound, "Can't find the DNS record "+parts[2]+"/"+parts[3]+" in LiveDNS")
+			return
+		}
+		s.reply(w, http.StatusOK, record)
+	case http.MethodPut:
+		s.records[key] = livedns.DomainRecord{
+			RrsetName:   parts[2],
+			RrsetType:   parts[3],
+			RrsetTTL:    body.RrsetTTL,
+			RrsetValues: fakelivedns.Quote(body.RrsetValues),
+		}
+		s.reply(w, http.StatusCreated, map[string]string{"message": "DNS Record Created"})
+	case http.MethodDelete:
+		if !ok {
+			s.error(w, http.StatusNotFound, "Can't find the DNS record "+parts[2]+"/"+parts[3]+" in LiveDNS")
+			return
+		}
+		delete(s.records, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.error(w, http.StatusMethodNotAllowed, "The method is not allowed for the requested URL.")
+	}
+}
+
+func TestPresentCleanUpAgainstGandiAPI(t *testing.T) {
+	server := newFakeGandiServer(t, "secret")
+	solver := newTestSolver(nil)
+	solver.newClient = newLiveDNSClient
+
+	apiURL := `, "apiURL": "` + server.URL + `"`
+	apex := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "apex", apiURL)
+	wildcard := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "wildcard", apiURL)
+
+	if err := solver.Present(apex); err != nil {
+		t.Fatalf("present apex: %v", err)
+	}
+	if err := solver.Present(wildcard); err != nil {
+		t.Fatalf("present wildcard: %v", err)
+	}
+	want := []string{`"apex"`, `"wildcard"`}
+	if got := server.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+
+	// Presenting again is a no-op.
+	requests := len(server.requests)
+	if err := solver.Present(wildcard); err != nil {
+		t.Fatalf("present wildcard again: %v", err)
+	}
+	if got := server.requests[requests:]; len(got) != 1 || !strings.HasPrefix(got[0], "GET ") {
+		t.Errorf("present again made requests %v, want a single GET", got)
+	}
+
+	if err := solver.CleanUp(apex); err != nil {
+		t.Fatalf("clean up apex: %v", err)
+	}
+	want = []string{`"wildcard"`}
+	if got := server.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("values after apex cleanup = %v, want %v", got, want)
+	}
+
+	if err := solver.CleanUp(wildcard); err != nil {
+		t.Fatalf("clean up wildcard: %v", err)
+	}
+	if got := server.Values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("values after wildcard cleanup = %v, want none", got)
+	}
+
+	records := livednsDomainsPath + "example.com/records"
+	allowed := []string{
+		"GET " + records + "/_acme-challenge",
+		"GET " + records + "/_acme-challenge/TXT",
+		"POST " + records,
+		"PUT " + records + "/_acme-challenge/TXT",
+		"DELETE " + records + "/_acme-challenge/TXT",
+	}
+	for _, r := range server.requests {
+		if !containsString(allowed, r) {
+			t.Errorf("unexpected request %s", r)
+		}
+	}
+}
+
+func TestPresentAgainstGandiAPIForbidden(t *testing.T) {
+	server := newFakeGandiServer(t, "other-secret")
+	solver := newTestSolver(nil)
+	solver.newClient = newLiveDNSClient
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "apiURL": "`+server.URL+`"`)
+	err := solver.Present(ch)
+	if err == nil || !strings.Contains(err.Error(), "unable to create TXT record _acme-challenge in zone example.com") {
+		t.Errorf("error = %v, want a create error", err)
+	}
+}
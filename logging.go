@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// redactLogs masks challenge keys in logs and keeps the go-gandi request dump,
+// which contains the API key, disabled. Set LOG_REDACT=false to log values in
+// clear when debugging.
+var redactLogs = os.Getenv("LOG_REDACT") != "false"
+
+// gandiDebugVerbosity is the klog verbosity of the go-gandi request dumps.
+const gandiDebugVerbosity = 8
+
+// klogWriter logs every line written to it with klog at gandiDebugVerbosity.
+type klogWriter struct{}
+
+func (klogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		klog.V(gandiDebugVerbosity).Info(line)
+	}
+	return len(p), nil
+}
+
+// setupGandiDebugLog routes the request dumps go-gandi writes with the
+// standard log package when logs are not redacted to the file at path if
+// set, or else to klog, so they stay out of the logs at normal verbosity.
+func setupGandiDebugLog(path string) error {
+	if path == "" {
+		log.SetFlags(0)
+		log.SetOutput(klogWriter{})
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open debug log: %v", err)
+	}
+	log.SetOutput(f)
+	return nil
+}
+
+// keyHashAlgorithms are the hash functions keyHash may use, by name.
+var keyHashAlgorithms = map[string]func([]byte) []byte{
+	"sha256": func(b []byte) []byte { sum := sha256.Sum256(b); return sum[:] },
+	"sha512": func(b []byte) []byte { sum := sha512.Sum512(b); return sum[:] },
+}
+
+const (
+	defaultKeyHashAlgorithm = "sha256"
+	defaultKeyHashLength    = 8
+	// minKeyHashLength keeps the hashes of API keys, which identify accounts
+	// as well, from colliding.
+	minKeyHashLength = 8
+)
+
+// keyHashAlgorithm and keyHashLength are the hash function and the number of
+// hex digits of the hashes returned by keyHash.
+var (
+	keyHashAlgorithm = defaultKeyHashAlgorithm
+	keyHashLength    = defaultKeyHashLength
+)
+
+// keyHashAlgorithmFromEnv returns the hash function set by
+// LOG_HASH_ALGORITHM, sha256 by default.
+func keyHashAlgorithmFromEnv() (string, error) {
+	algorithm := os.Getenv("LOG_HASH_ALGORITHM")
+	if algorithm == "" {
+		return defaultKeyHashAlgorithm, nil
+	}
+	if _, ok := keyHashAlgorithms[algorithm]; !ok {
+		names := make([]string, 0, len(keyHashAlgorithms))
+		for name := range keyHashAlgorithms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unsupported hash algorithm %q, supported algorithms are %v", algorithm, names)
+	}
+	return algorithm, nil
+}
+
+// keyHashLengthFromEnv returns the number of hex digits set by
+// LOG_HASH_LENGTH, 8 by default, at most the length of the digests of
+// algorithm.
+func keyHashLengthFromEnv(algorithm string) (int, error) {
+	v := os.Getenv("LOG_HASH_LENGTH")
+	if v == "" {
+		return defaultKeyHashLength, nil
+	}
+	max := 2 * len(keyHashAlgorithms[algorithm](nil))
+	length, err := strconv.Atoi(v)
+	if err != nil || length < minKeyHashLength || length > max {
+		return 0, fmt.Errorf("invalid hash length %q, must be a number of hex digits from %d to %d", v, minKeyHashLength, max)
+	}
+	return length, nil
+}
+
+// keyHash returns a short stable hash of a challenge key, the first
+// keyHashLength hex digits of its digest by keyHashAlgorithm, prefixed by
+// the algorithm, so a challenge can be followed from Present to CleanUp in
+// the logs without revealing the key. Quotes around TXT values are ignored,
+// a key hashes the same as read back from Gandi.
+func keyHash(key string) string {
+	sum := keyHashAlgorithms[keyHashAlgorithm]([]byte(strings.Trim(key, "\"")))
+	return keyHashAlgorithm + ":" + hex.EncodeToString(sum)[:keyHashLength]
+}
+
+// keyHashAll applies keyHash to every value.
+func keyHashAll(values []string) []string {
+	hashed := make([]string, 0, len(values))
+	for _, v := range values {
+		hashed = append(hashed, keyHash(v))
+	}
+	return hashed
+}
+
+// redact returns value, or its keyHash if logs are redacted. It is only used
+// in verbose logs, those at the default verbosity always log the hash.
+func redact(value string) string {
+	if !redactLogs {
+		return value
+	}
+	return keyHash(value)
+}
+
+// redactAll applies redact to every value.
+func redactAll(values []string) []string {
+	redacted := make([]string, 0, len(values))
+	for _, v := range values {
+		redacted = append(redacted, redact(v))
+	}
+	return redacted
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"k8s.io/klog/v2"
+)
+
+func TestRedact(t *testing.T) {
+	defer func(old bool) { redactLogs = old }(redactLogs)
+
+	redactLogs = true
+	key := "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	got := redact(key)
+	if strings.Contains(got, key[:8]) {
+		t.Errorf("redacted value %q leaks the key", got)
+	}
+	if got != redact(key) {
+		t.Error("redacted value is not stable")
+	}
+	if got == redact("other") {
+		t.Error("different values redact to the same hash")
+	}
+	if len(got) != len("sha256:")+8 {
+		t.Errorf("redacted value %q is not a short hash", got)
+	}
+
+	all := redactAll([]string{key, "other"})
+	if len(all) != 2 || all[0] != got {
+		t.Errorf("redactAll = %v", all)
+	}
+
+	redactLogs = false
+	if redact(key) != key {
+		t.Error("value is redacted although redaction is disabled")
+	}
+}
+
+func TestKeyHash(t *testing.T) {
+	key := "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	if keyHash(key) != keyHash(`"`+key+`"`) {
+		t.Error("quoted value does not hash like the key")
+	}
+	if keyHash(key) != keyHash(key) || keyHash(key) == keyHash("other") {
+		t.Error("hash is not stable and distinct")
+	}
+
+	defer func(old bool) { redactLogs = old }(redactLogs)
+	redactLogs = true
+	if redact(key) != keyHash(key) {
+		t.Error("redacted value is not the key hash")
+	}
+}
+
+func TestKeyHashAlgorithm(t *testing.T) {
+	defer func(algorithm string, length int) {
+		keyHashAlgorithm, keyHashLength = algorithm, length
+	}(keyHashAlgorithm, keyHashLength)
+
+	key := "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	for _, tt := range []struct {
+		algorithm string
+		length    string
+		want      string
+		wantErr   bool
+	}{
+		{want: "sha256:08d09345"},
+		{algorithm: "sha256", length: "16", want: "sha256:08d093450e23c1c9"},
+		{algorithm: "sha512", want: "sha512:7c26aa63"},
+		{algorithm: "sha512", length: "128", want: "sha512:"},
+		{algorithm: "md5", wantErr: true},
+		{algorithm: "sha256", length: "65", wantErr: true},
+		{algorithm: "sha256", length: "4", wantErr: true},
+		{algorithm: "sha256", length: "eight", wantErr: true},
+	} {
+		t.Setenv("LOG_HASH_ALGORITHM", tt.algorithm)
+		t.Setenv("LOG_HASH_LENGTH", tt.length)
+		algorithm, err := keyHashAlgorithmFromEnv()
+		var length int
+		if err == nil {
+			length, err = keyHashLengthFromEnv(algorithm)
+		}
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s/%s: expected an error", tt.algorithm, tt.length)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s/%s: unexpected error: %v", tt.algorithm, tt.length, err)
+		}
+		keyHashAlgorithm, keyHashLength = algorithm, length
+		got := keyHash(key)
+		if !strings.HasPrefix(got, tt.want) || len(got) != len(algorithm)+1+length {
+			t.Errorf("%s/%s: keyHash() = %q, want %q with %d hex digits", tt.algorithm, tt.length, got, tt.want, length)
+		}
+		if strings.Contains(got, key[:8]) {
+			t.Errorf("%s/%s: keyHash() = %q leaks the key", tt.algorithm, tt.length, got)
+		}
+	}
+}
+
+func TestKeyNotLoggedByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	_ = flags.Set("logtostderr", "false")
+	klog.SetOutput(&buf)
+	defer func() {
+		_ = flags.Set("logtostderr", "true")
+		klog.SetOutput(os.Stderr)
+	}()
+	defer func(old bool) { redactLogs = old }(redactLogs)
+	redactLogs = false
+
+	key := "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	for _, config := range []string{`, "auditOnly": true`, ""} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", key, config)
+		if err := solver.Present(ch); err != nil {
+			t.Fatal(err)
+		}
+		if err := solver.CleanUp(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not tracked, logs a warning.
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", key, "")
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	klog.Flush()
+
+	if strings.Contains(buf.String(), key) {
+		t.Errorf("key logged at the default verbosity:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), keyHash(key)) {
+		t.Errorf("key hash not logged at the default verbosity:\n%s", buf.String())
+	}
+}
+
+func TestGandiDebugLog(t *testing.T) {
+	defer func(flags int) {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}(log.Flags())
+
+	path := filepath.Join(t.TempDir(), "gandi.log")
+	if err := setupGandiDebugLog(path); err != nil {
+		t.Fatal(err)
+	}
+	log.Print("GET /v5/livedns/domains")
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "GET /v5/livedns/domains") {
+		t.Errorf("debug log = %q, %v, want the request dump", data, err)
+	}
+
+	var buf bytes.Buffer
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	_ = flags.Set("logtostderr", "false")
+	klog.SetOutput(&buf)
+	defer func() {
+		_ = flags.Set("logtostderr", "true")
+		_ = flags.Set("v", "0")
+		klog.SetOutput(os.Stderr)
+	}()
+	if err := setupGandiDebugLog(""); err != nil {
+		t.Fatal(err)
+	}
+	log.Print("hidden")
+	_ = flags.Set("v", strconv.Itoa(gandiDebugVerbosity))
+	log.Print("request\nheader")
+	klog.Flush()
+	if strings.Contains(buf.String(), "hidden") {
+		t.Errorf("request dump logged below verbosity %d:\n%s", gandiDebugVerbosity, buf.String())
+	}
+	if !strings.Contains(buf.String(), "request") || !strings.Contains(buf.String(), "header") {
+		t.Errorf("request dump not logged at verbosity %d:\n%s", gandiDebugVerbosity, buf.String())
+	}
+}
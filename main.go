@@ -1,25 +1,29 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd"
 	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
-	"github.com/go-gandi/go-gandi"
 	"github.com/go-gandi/go-gandi/config"
+	"golang.org/x/sync/singleflight"
 	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
+	"net"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 const (
-	GandiMinTtl = 300 // Gandi reports an error for values < this value
+	GandiMinTtl = 300     // Gandi reports an error for values < this value
+	GandiMaxTtl = 2592000 // Gandi reports an error for values > this value
 )
 
 var GroupName = os.Getenv("GROUP_NAME")
@@ -29,15 +33,85 @@ func main() {
 	if GroupName == "" {
 		panic("GROUP_NAME must be specified")
 	}
+	if err := setupGandiDebugLog(os.Getenv("GANDI_DEBUG_LOG_FILE")); err != nil {
+		panic(fmt.Sprintf("GANDI_DEBUG_LOG_FILE: %v", err))
+	}
+	algorithm, err := keyHashAlgorithmFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("LOG_HASH_ALGORITHM: %v", err))
+	}
+	length, err := keyHashLengthFromEnv(algorithm)
+	if err != nil {
+		panic(fmt.Sprintf("LOG_HASH_LENGTH: %v", err))
+	}
+	keyHashAlgorithm, keyHashLength = algorithm, length
+	if err := validateAPIVersion(apiVersionFromEnv()); err != nil {
+		panic(fmt.Sprintf("GANDI_API_VERSION: %v", err))
+	}
+	if err := validateRetryJitter(retryJitterFromEnv()); err != nil {
+		panic(fmt.Sprintf("RETRY_JITTER: %v", err))
+	}
+	overrides, err := errorOverridesFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("ERROR_CLASS_OVERRIDES: %v", err))
+	}
+	errorOverrides = overrides
+	dialTimeout, err := durationFromEnv("GANDI_DIAL_TIMEOUT", defaultDialTimeout)
+	if err != nil {
+		panic(fmt.Sprintf("GANDI_DIAL_TIMEOUT: %v", err))
+	}
+	keepAlive, err := durationFromEnv("GANDI_KEEP_ALIVE", defaultKeepAlive)
+	if err != nil {
+		panic(fmt.Sprintf("GANDI_KEEP_ALIVE: %v", err))
+	}
+	maxResponseSize, err := maxResponseSizeFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("GANDI_MAX_RESPONSE_SIZE: %v", err))
+	}
+	transport, err := newGandiTransport(os.Getenv("GANDI_PROXY_URL"), dialTimeout, keepAlive, maxResponseSize, realClock{})
+	if err != nil {
+		panic(fmt.Sprintf("GANDI_PROXY_URL: %v", err))
+	}
+	// go-gandi sends its requests through the default transport: only those
+	// to the Gandi API go through the Gandi transport, other requests keep
+	// the standard one.
+	gandiRouting = newGandiHostTransport(transport, http.DefaultTransport)
+	http.DefaultTransport = gandiRouting
+	solvers, err := solversFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("SOLVERS: %v", err))
+	}
+	workers, err := workerPoolSizeFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("WORKER_POOL_SIZE: %v", err))
+	}
+	if workers > 0 {
+		pool := newRecordPool(workers, realClock{})
+		for _, s := range solvers {
+			s.(*gandiDNSProviderSolver).pool = pool
+		}
+	}
+	callback, err := lifecycleCallbackFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("CALLBACK_URL: %v", err))
+	}
+	for _, s := range solvers {
+		s.(*gandiDNSProviderSolver).callback = callback
+	}
+	statsd, err := statsdExporterFromEnv(legacyregistry.DefaultGatherer)
+	if err != nil {
+		panic(fmt.Sprintf("STATSD_ADDRESS: %v", err))
+	}
+	if statsd != nil {
+		go statsd.run()
+	}
 
 	// This will register our gandi DNS provider with the webhook serving
 	// library, making it available as an API under the provided GroupName.
 	// You can register multiple DNS provider implementations with a single
 	// webhook, where the Name() method will be used to disambiguate between
 	// the different implementations.
-	cmd.RunWebhookServer(GroupName,
-		&gandiDNSProviderSolver{},
-	)
+	cmd.RunWebhookServer(GroupName, solvers...)
 }
 
 // gandiDNSProviderSolver implements the provider-specific logic needed to
@@ -45,7 +119,54 @@ func main() {
 // To do so, it must implement the `github.com/cert-manager/cert-manager/pkg/acme/webhook.Solver`
 // interface.
 type gandiDNSProviderSolver struct {
-	client *kubernetes.Clientset
+	name       string
+	defaults   json.RawMessage
+	client     kubernetes.Interface
+	newClient  func(config.Config) liveDNSClient
+	clock      clock
+	tracker    valueTracker
+	challenges *challengeRegistry
+	// pool writes the challenge values if set, synchronously otherwise.
+	pool       *recordPool
+	writes     *writeDebouncer
+	zones      *zoneCache
+	serveAdmin bool
+
+	// sharedDefaults holds the defaults shared by all solvers, which
+	// defaults override, if set.
+	sharedDefaults *defaultsConfigMap
+	// secondaryWriters are called after Gandi, see recordWriter.
+	secondaryWriters []recordWriter
+	// nameservers caches the nameservers of zones for
+	// propagationAuthoritative.
+	nameservers *nameserverCache
+	// zoneLocks serializes writes per zone for serializeZoneWrites.
+	zoneLocks *zoneLocks
+	// callback is notified of the outcome of every Present and CleanUp, if
+	// set.
+	callback *lifecycleCallback
+	// presents deduplicates concurrent Present calls for deduplicatePresent.
+	presents singleflight.Group
+}
+
+// newGandiDNSProviderSolver returns the gandi solver talking to the Gandi API,
+// using the real clock and tracking presented values in memory.
+func newGandiDNSProviderSolver() *gandiDNSProviderSolver {
+	return &gandiDNSProviderSolver{
+		name:       defaultSolverName,
+		newClient:  newLiveDNSClient,
+		clock:      realClock{},
+		tracker:    newMemoryTracker(),
+		writes:     newWriteDebouncer(),
+		zones:      newZoneCache(),
+		challenges: newChallengeRegistry(realClock{}),
+		serveAdmin: true,
+
+		// Registered by init functions, before any solver is created.
+		secondaryWriters: secondaryWriters,
+		nameservers:      newNameserverCache(),
+		zoneLocks:        newZoneLocks(),
+	}
 }
 
 // gandiDNSProviderConfig is a structure that is used to decode into when
@@ -66,6 +187,196 @@ type gandiDNSProviderConfig struct {
 	// These fields will be set by users in the
 	// `issuer.spec.acme.dns01.providers.webhook.config` field.
 	APIKeySecretRef cmmeta.SecretKeySelector `json:"apiKeySecretRef"`
+
+	// APIKeyMapSecretRef references a secret value holding a JSON object
+	// mapping domain suffixes to API keys. It takes precedence over
+	// APIKeySecretRef, allowing one secret to serve several zones.
+	APIKeyMapSecretRef *cmmeta.SecretKeySelector `json:"apiKeyMapSecretRef,omitempty"`
+
+	// APIKeyTagMapSecretRef references a secret value holding a JSON object
+	// mapping tags to API keys, for setups partitioned by issuer rather than
+	// by domain. The API key of APIKeyTag is used, or of the namespace of the
+	// challenge if not set. It takes precedence over APIKeyMapSecretRef.
+	APIKeyTagMapSecretRef *cmmeta.SecretKeySelector `json:"apiKeyTagMapSecretRef,omitempty"`
+	APIKeyTag             string                    `json:"apiKeyTag,omitempty"`
+
+	// CredentialScope selects the namespace the secrets are read from: the
+	// namespace of the challenge with "issuer" (the default), which is
+	// cert-manager's cluster resource namespace for a ClusterIssuer, or
+	// CredentialNamespace with "cluster". Only the solver defaults may set
+	// "cluster" and CredentialNamespace.
+	CredentialScope     string `json:"credentialScope,omitempty"`
+	CredentialNamespace string `json:"credentialNamespace,omitempty"`
+
+	// SecretNotFoundTimeout keeps reading a secret that does not exist for
+	// up to this long, for secrets applied along with their issuer.
+	SecretNotFoundTimeout *metav1.Duration `json:"secretNotFoundTimeout,omitempty"`
+
+	// WaitForPropagation makes Present block until the TXT record is served
+	// by PropagationNameservers, or the system resolver if none are set.
+	WaitForPropagation      bool             `json:"waitForPropagation"`
+	PropagationNameservers  []string         `json:"propagationNameservers"`
+	PropagationPollInterval *metav1.Duration `json:"propagationPollInterval"`
+	PropagationTimeout      *metav1.Duration `json:"propagationTimeout"`
+
+	// PropagationDoHURL is a DNS-over-HTTPS server queried while waiting for
+	// propagation instead of PropagationNameservers, for networks blocking
+	// DNS traffic on port 53.
+	PropagationDoHURL string `json:"propagationDoHURL,omitempty"`
+
+	// PropagationPollJitter is the fraction of PropagationPollInterval by
+	// which polls are moved at random either way, spreading the queries of
+	// challenges waiting together.
+	PropagationPollJitter *float64 `json:"propagationPollJitter,omitempty"`
+
+	// PropagationAuthoritative waits for the record on the nameservers of
+	// the zone, looked up in its NS records with the lookup resolver and
+	// cached for NameserverCacheTTL, instead of PropagationNameservers.
+	PropagationAuthoritative bool             `json:"propagationAuthoritative"`
+	NameserverCacheTTL       *metav1.Duration `json:"nameserverCacheTTL"`
+
+	// FailOnDNSSECErrors gives up waiting for propagation once resolvers
+	// answer SERVFAIL several polls in a row, as DNSSEC validation failing
+	// for the zone does not fix itself before the timeout.
+	FailOnDNSSECErrors bool `json:"failOnDNSSECErrors"`
+
+	// SerializeZoneWrites serializes the writes of all challenges to the same
+	// zone within the process, so concurrent read-modify-write cycles never
+	// drop each other's values, at the cost of throughput.
+	SerializeZoneWrites bool `json:"serializeZoneWrites"`
+
+	// DeduplicatePresent collapses concurrent Present calls for the same
+	// value and name into one, all callers sharing its outcome.
+	DeduplicatePresent bool `json:"deduplicatePresent"`
+
+	// VerifyChallengeName rejects challenge requests whose resolvedFQDN is
+	// not the _acme-challenge record of their dnsName, guarding against
+	// misdirected challenges when tenants share the webhook.
+	VerifyChallengeName bool `json:"verifyChallengeName"`
+
+	// ResolverAddress is the nameserver (host:port) used for the webhook's
+	// own DNS lookups instead of the pod's, which may be a cluster DNS unable
+	// to resolve external names.
+	ResolverAddress string `json:"resolverAddress,omitempty"`
+
+	// MaintenanceRetryTimeout enables retrying calls failing because of a
+	// Gandi maintenance every MaintenanceRetryInterval for this long, instead
+	// of giving up after the normal retries.
+	MaintenanceRetryInterval *metav1.Duration `json:"maintenanceRetryInterval"`
+	MaintenanceRetryTimeout  *metav1.Duration `json:"maintenanceRetryTimeout"`
+
+	// SecondaryAccounts are further Gandi accounts serving the same domain.
+	// Challenge records are written to the primary and secondary accounts,
+	// and must succeed for WriteQuorum of them, all by default.
+	SecondaryAccounts []secondaryAccount `json:"secondaryAccounts,omitempty"`
+	WriteQuorum       int                `json:"writeQuorum,omitempty"`
+
+	// APIURL is the base URL of the Gandi API. APIVersion defaults to
+	// GANDI_API_VERSION or the current stable version, and must be the one
+	// go-gandi sends requests to.
+	// APIURLs replaces APIURL with a list of endpoints failed over in turn
+	// while unreachable.
+	APIURL     string   `json:"apiURL,omitempty"`
+	APIURLs    []string `json:"apiURLs,omitempty"`
+	APIVersion string   `json:"apiVersion,omitempty"`
+
+	// WriteStrategy is how the values of an existing RRset are replaced:
+	// "update" (the default) or "recreate" to delete and create it again.
+	WriteStrategy string `json:"writeStrategy,omitempty"`
+
+	// ConfirmWrites makes Present read the TXT record back from Gandi until
+	// it contains the challenge value, whether it was created or updated.
+	ConfirmWrites bool `json:"confirmWrites,omitempty"`
+
+	// SnapshotBeforeWrite takes a snapshot of the zone of the primary
+	// account before each Present and CleanUp, logging its ID.
+	SnapshotBeforeWrite bool `json:"snapshotBeforeWrite,omitempty"`
+
+	// CoTenant makes the solver safe to use on RRsets other solvers write
+	// to: RRsets are never recreated and only challenge keys are removed.
+	CoTenant bool `json:"coTenant,omitempty"`
+
+	// PreserveExisting guarantees values of the TXT RRset that are not
+	// challenge keys, such as a user's own records, are never dropped.
+	PreserveExisting bool `json:"preserveExisting,omitempty"`
+
+	// OperationTimeout caps the time Present and CleanUp spend on a
+	// challenge, retries and propagation wait included.
+	OperationTimeout *metav1.Duration `json:"operationTimeout"`
+
+	// MinWriteInterval skips presenting or cleaning up a value again if
+	// that was done less than this interval ago.
+	MinWriteInterval *metav1.Duration `json:"minWriteInterval,omitempty"`
+
+	// TTL is the TTL of the challenge records, GandiMinTtl by default.
+	// PreserveTTL keeps the TTL of existing RRsets if it differs.
+	TTL         int  `json:"ttl,omitempty"`
+	PreserveTTL bool `json:"preserveTTL,omitempty"`
+
+	// SkipExistingCheck makes Present create the TXT record without reading
+	// it first, falling back to merging the value if it already exists.
+	SkipExistingCheck bool `json:"skipExistingCheck,omitempty"`
+
+	// ForceWrite makes Present write the TXT record even if it already
+	// holds the value, to reset its age when debugging propagation.
+	ForceWrite bool `json:"forceWrite,omitempty"`
+
+	// CleanUpRequireTTLMatch makes CleanUp leave TXT records alone unless
+	// their TTL is the one the webhook writes, as other tools wrote them.
+	CleanUpRequireTTLMatch bool `json:"cleanUpRequireTTLMatch,omitempty"`
+
+	// NormalizeLegacyValues makes Present quote the challenge values stored
+	// unquoted by older versions of the webhook. They compare equal to
+	// quoted ones either way.
+	NormalizeLegacyValues bool `json:"normalizeLegacyValues,omitempty"`
+
+	// ExistingCheckTimeout caps the read of the TXT record by Present before
+	// writing it, for zones large enough to make it slow. On timeout, Present
+	// fails unless ExistingCheckFallback is set, in which case it goes on as
+	// with SkipExistingCheck.
+	ExistingCheckTimeout  *metav1.Duration `json:"existingCheckTimeout,omitempty"`
+	ExistingCheckFallback bool             `json:"existingCheckFallback,omitempty"`
+
+	// AdjustTTL brings a TTL outside of the range Gandi accepts within it
+	// instead of rejecting the configuration. Gandi does not expose the range
+	// per zone, its documented bounds are used.
+	AdjustTTL bool `json:"adjustTTL,omitempty"`
+
+	// MaxTTL caps the TTL of the challenge records, TTL and the TTL kept by
+	// PreserveTTL included, for resolvers or policies limiting TTLs.
+	MaxTTL int `json:"maxTTL,omitempty"`
+
+	// ZoneName is the zone managed at Gandi holding the challenge record. By
+	// default it is the last two labels of the domain, or its registrable
+	// domain according to the Public Suffix List with StrictDomainParsing,
+	// which fails rather than guess for unknown public suffixes.
+	ZoneName            string `json:"zoneName,omitempty"`
+	StrictDomainParsing bool   `json:"strictDomainParsing,omitempty"`
+
+	// DiscoverZone looks the zone up among the zones LiveDNS manages for
+	// the account instead, the most specific one the challenge record
+	// belongs to, so sub-zones delegated within the account are written to.
+	DiscoverZone bool `json:"discoverZone,omitempty"`
+
+	// SuspiciousReadPolicy is what CleanUp does when the TXT record it reads
+	// lacks values of other challenges the webhook presented, as if the read
+	// was incomplete: "retry" (the default) to read it again and fail if
+	// they are still missing, or "ignore" to write back what was read.
+	SuspiciousReadPolicy string `json:"suspiciousReadPolicy,omitempty"`
+
+	// UnexpectedRecordTypePolicy is what Present and CleanUp do when Gandi
+	// returns a record of another type than TXT reading the TXT record:
+	// "fail" (the default) or "ignore" to go on as if there was none.
+	UnexpectedRecordTypePolicy string `json:"unexpectedRecordTypePolicy,omitempty"`
+
+	// AuditOnly makes Present and CleanUp read the TXT record and log the
+	// changes they would make without making them, then report success.
+	AuditOnly bool `json:"auditOnly,omitempty"`
+
+	// FailOnCleanupError makes CleanUp fail the challenge when the value
+	// cannot be removed, the default. Otherwise the error is only logged and
+	// counted, possibly leaving the value behind.
+	FailOnCleanupError *bool `json:"failOnCleanupError,omitempty"`
 }
 
 // Name is used as the name for this DNS solver when referencing it on the ACME
@@ -75,14 +386,7 @@ type gandiDNSProviderConfig struct {
 // within a single webhook deployment**.
 // For example, `cloudflare` may be used as the name of a solver.
 func (c *gandiDNSProviderSolver) Name() string {
-	return "gandi"
-}
-
-func extractRootAndSubDomain(fqdn string, entry string) (string, string, error) {
-	parts := strings.Split(strings.Trim(fqdn, "."), ".")
-	domain := parts[len(parts)-2] + "." + parts[len(parts)-1]
-
-	return domain, strings.Join(append([]string{strings.Trim(entry, ".")}, parts[0:len(parts)-2]...), "."), nil
+	return c.name
 }
 
 // Present is responsible for actually presenting the DNS record with the
@@ -90,102 +394,230 @@ func extractRootAndSubDomain(fqdn string, entry string) (string, string, error)
 // This method should tolerate being called multiple times with the same value.
 // cert-manager itself will later perform a self check to ensure that the
 // solver has correctly configured the DNS provider.
-func (c *gandiDNSProviderSolver) Present(ch *v1alpha1.ChallengeRequest) error {
-	klog.V(6).Infof("call function Present: namespace=%s, zone=%s, fqdn=%s",
-		ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN)
+func (c *gandiDNSProviderSolver) Present(ch *v1alpha1.ChallengeRequest) (err error) {
+	defer func() { c.callback.notify(c.name, "present", ch, err, c.clock.Now()) }()
+	if err := validateChallengeRequest(ch); err != nil {
+		return fmt.Errorf("invalid challenge request: %v", err)
+	}
+	klog.V(6).Infof("call function Present: namespace=%s, zone=%s, fqdn=%s, key=%s",
+		ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN, keyHash(ch.Key))
 
-	cfg, err := loadConfig(ch.Config)
+	cfg, err := c.solverConfig(ch.Config)
 	if err != nil {
 		return fmt.Errorf("unable to load config: %v", err)
 	}
+	if cfg.VerifyChallengeName {
+		if err := verifyChallengeName(ch); err != nil {
+			return fmt.Errorf("rejected challenge request: %v", err)
+		}
+	}
 
 	klog.V(6).Infof("decoded configuration %v", cfg)
 
-	apiKey, err := c.getApiKey(&cfg, ch.ResourceNamespace)
-	if err != nil {
-		return fmt.Errorf("unable to get API key: %v", err)
+	if !cfg.DeduplicatePresent {
+		return c.present(ch, &cfg)
 	}
-
-	clientcfg := &config.Config{
-		APIKey: *apiKey,
-		Debug:  false,
-		DryRun: false,
+	// Concurrent calls for the same value share the outcome of the first.
+	_, err, shared := c.presents.Do(strings.ToLower(ch.ResolvedFQDN)+"\x00"+ch.Key, func() (interface{}, error) {
+		return nil, c.present(ch, &cfg)
+	})
+	if shared {
+		klog.V(6).Infof("shared the outcome of concurrent Present calls for TXT value %s of %s", keyHash(ch.Key), ch.ResolvedFQDN)
 	}
-	gandiClient := gandi.NewLiveDNSClient(*clientcfg)
+	return err
+}
+
+// present presents the challenge value with cfg.
+func (c *gandiDNSProviderSolver) present(ch *v1alpha1.ChallengeRequest, cfg *gandiDNSProviderConfig) error {
+	budget := newOperationBudget(c.clock, cfg.operationTimeout())
 
 	entry, domain := c.getDomainAndEntry(ch)
 	klog.V(6).Infof("present for entry=%s, domain=%s", entry, domain)
 
-	root, subdomain, err := extractRootAndSubDomain(domain, entry)
+	root, subdomain, err := cfg.rootAndSubDomain(domain, entry)
 	if err != nil {
 		return fmt.Errorf("unable to mange provided domain : %v", err)
 	}
+	c.challenges.attempt(recordName(root, subdomain), ch.Key, challengePresenting)
 
-	record, err := gandiClient.GetDomainRecordByNameAndType(root, subdomain, "TXT")
+	clientcfg, targets, err := c.getAccountTargets(cfg, ch.ResourceNamespace, budget, root, subdomain)
 	if err != nil {
-		klog.V(6).Infof("There is no entry of TXT matching, creating a new one for %s with value \"%s\"", subdomain+root, ch.Key)
-		_, err := gandiClient.CreateDomainRecord(root, subdomain, "TXT", GandiMinTtl, []string{ch.Key})
-		if err != nil {
-			return fmt.Errorf("unable to create TXT record: %v", err)
-		}
-	} else {
-		if strings.Join(record.RrsetValues, "") != "\""+ch.Key+"\"" {
-			klog.V(6).Infof("Current record exists for %s value is %s, new value will be \"%s\"", subdomain+root, strings.Join(record.RrsetValues, ""), ch.Key)
-			_, err := gandiClient.UpdateDomainRecordByNameAndType(root, subdomain, "TXT", GandiMinTtl, []string{ch.Key})
-			if err != nil {
-				return fmt.Errorf("unable to update TXT record: %v", err)
-			}
-		}
+		return err
+	}
+	if cfg.AuditOnly {
+		targets = auditTargets(targets, ch)
+	} else if cfg.SnapshotBeforeWrite {
+		snapshotZone(c.newClient(*clientcfg), targets[0].root, "presenting "+recordName(root, subdomain))
+	}
+	if err := c.writeRecord(cfg, targets, recordName(root, subdomain), ch.Key, true); err != nil {
+		return err
+	}
+	if cfg.AuditOnly {
+		c.challenges.presented(recordName(root, subdomain), ch, c)
+		return nil
 	}
+	if err := c.tracker.Add(recordName(root, subdomain), ch.Key); err != nil {
+		return recordErrorf(root, subdomain, "track presented value of", err)
+	}
+	klog.V(2).Info(withContext(fmt.Sprintf("presented TXT value %s for %s", keyHash(ch.Key), recordName(root, subdomain)), challengeContext(ch)))
+	if err := c.waitForPropagation(cfg, ch, targets[0].root, budget); err != nil {
+		return recordErrorf(root, subdomain, "propagate", err)
+	}
+	c.challenges.presented(recordName(root, subdomain), ch, c)
 	return nil
 }
 
+// waitForPropagation blocks until the challenge record is visible to the
+// configured nameservers, or those of zone root, if the issuer asked for it.
+func (c *gandiDNSProviderSolver) waitForPropagation(cfg *gandiDNSProviderConfig, ch *v1alpha1.ChallengeRequest, root string, budget *operationBudget) error {
+	if !cfg.WaitForPropagation {
+		return nil
+	}
+	interval := defaultPropagationPollInterval
+	if cfg.PropagationPollInterval != nil {
+		interval = cfg.PropagationPollInterval.Duration
+	}
+	timeout := defaultPropagationTimeout
+	if cfg.PropagationTimeout != nil {
+		timeout = cfg.PropagationTimeout.Duration
+	}
+	// Wait no longer than the operation budget allows.
+	capped := budget != nil && !budget.allows(c.clock, timeout)
+	if capped {
+		timeout = budget.deadline.Sub(c.clock.Now())
+	}
+	fqdn := trimWildcard(ch.ResolvedFQDN) + "."
+	klog.V(6).Infof("waiting up to %s for %s to propagate", timeout, fqdn)
+	nameservers := cfg.PropagationNameservers
+	if cfg.PropagationAuthoritative {
+		nameservers = c.authoritativeNameservers(cfg, root)
+	}
+	servfailPolls := 0
+	if cfg.FailOnDNSSECErrors {
+		servfailPolls = dnssecFailurePolls
+	}
+	start := c.clock.Now()
+	poll := pollSchedule{interval: interval, jitter: cfg.propagationPollJitter()}
+	resolvers := newPropagationResolvers(nameservers, cfg.ResolverAddress)
+	if cfg.PropagationDoHURL != "" {
+		resolvers = []namedResolver{{name: cfg.PropagationDoHURL, resolver: newDoHResolver(cfg.PropagationDoHURL)}}
+	}
+	err := waitForPropagation(c.clock, resolvers, fqdn, ch.Key, poll, timeout, servfailPolls)
+	if err == nil {
+		propagationDuration.WithLabelValues(root).Observe(c.clock.Now().Sub(start).Seconds())
+	}
+	if err != nil && cfg.PropagationAuthoritative {
+		// The nameservers of the zone may have changed since they were cached.
+		c.nameservers.refresh(root)
+	}
+	if err != nil && capped {
+		return budget.exhausted(err)
+	}
+	return err
+}
+
+// authoritativeNameservers returns the nameservers of zone root, or none,
+// falling back to the lookup resolver, if they cannot be looked up.
+func (c *gandiDNSProviderSolver) authoritativeNameservers(cfg *gandiDNSProviderConfig, root string) []string {
+	resolver, ok := newLookupResolver(cfg.ResolverAddress).resolver.(nsResolver)
+	if !ok {
+		return nil
+	}
+	nameservers, err := c.nameservers.nameservers(c.clock, root, cfg.nameserverCacheTTL(), resolver)
+	if err != nil {
+		klog.Warningf("Waiting for propagation with the lookup resolver: %v", err)
+		return nil
+	}
+	return nameservers
+}
+
 // CleanUp should delete the relevant TXT record from the DNS provider console.
 // If multiple TXT records exist with the same record name (e.g.
 // _acme-challenge.example.com) then **only** the record with the same `key`
 // value provided on the ChallengeRequest should be cleaned up.
 // This is in order to facilitate multiple DNS validations for the same domain
 // concurrently.
-func (c *gandiDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
-	klog.V(6).Infof("call function CleanUp: namespace=%s, zone=%s, fqdn=%s",
-		ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN)
+func (c *gandiDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) (err error) {
+	defer func() { c.callback.notify(c.name, "cleanUp", ch, err, c.clock.Now()) }()
+	if err := validateChallengeRequest(ch); err != nil {
+		return fmt.Errorf("invalid challenge request: %v", err)
+	}
+	klog.V(6).Infof("call function CleanUp: namespace=%s, zone=%s, fqdn=%s, key=%s",
+		ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN, keyHash(ch.Key))
 
-	cfg, err := loadConfig(ch.Config)
+	cfg, err := c.solverConfig(ch.Config)
 	if err != nil {
 		return fmt.Errorf("unable to load config: %v", err)
 	}
-
-	klog.V(6).Infof("decoded configuration %v", cfg)
-
-	apiKey, err := c.getApiKey(&cfg, ch.ResourceNamespace)
-	if err != nil {
-		return fmt.Errorf("unable to get API key: %v", err)
+	if cfg.VerifyChallengeName {
+		if err := verifyChallengeName(ch); err != nil {
+			return fmt.Errorf("rejected challenge request: %v", err)
+		}
 	}
+	budget := newOperationBudget(c.clock, cfg.operationTimeout())
 
-	clientcfg := &config.Config{
-		APIKey: *apiKey,
-		Debug:  true,
-		DryRun: false,
-	}
-	gandiClient := gandi.NewLiveDNSClient(*clientcfg)
+	klog.V(6).Infof("decoded configuration %v", cfg)
 
 	entry, domain := c.getDomainAndEntry(ch)
 
-	root, subdomain, err := extractRootAndSubDomain(domain, entry)
+	root, subdomain, err := cfg.rootAndSubDomain(domain, entry)
 	if err != nil {
 		return fmt.Errorf("unable to mange provided domain : %v", err)
 	}
+	c.challenges.attempt(recordName(root, subdomain), ch.Key, challengeCleaning)
 
-	_, err = gandiClient.GetDomainRecordByNameAndType(root, subdomain, "TXT")
-	if err != nil {
-		klog.V(6).Infof("There is no entry of TXT matching, do nothing", subdomain+root, ch.Key)
-	} else {
-		err := gandiClient.DeleteDomainRecord(root, subdomain, "TXT")
+	err = c.cleanUp(ch, &cfg, budget, root, subdomain)
+	if err == nil || cfg.failOnCleanupError() {
+		return err
+	}
+	// The certificate does not depend on the clean up: report the error
+	// without failing the challenge, leaving the value presented.
+	klog.Errorf("Ignoring failed clean up of TXT value %s for %s: %v", keyHash(ch.Key), recordName(root, subdomain), err)
+	cleanUpErrors.WithLabelValues(c.name).Inc()
+	c.challenges.done(recordName(root, subdomain), ch.Key)
+	return nil
+}
+
+// cleanUp removes the challenge value from the TXT record subdomain of zone
+// root of all accounts.
+func (c *gandiDNSProviderSolver) cleanUp(ch *v1alpha1.ChallengeRequest, cfg *gandiDNSProviderConfig, budget *operationBudget, root, subdomain string) error {
+	// Values are not tracked in audit mode, evaluate every clean up.
+	if !cfg.AuditOnly {
+		ok, err := c.tracker.Has(recordName(root, subdomain), ch.Key)
 		if err != nil {
-			return fmt.Errorf("unable to delete TXT record: %v", err)
+			return recordErrorf(root, subdomain, "look up presented value of", err)
+		}
+		if !ok {
+			klog.Warningf("TXT value %s for %s was not presented by this webhook, leaving it in place", keyHash(ch.Key), recordName(root, subdomain))
+			c.challenges.done(recordName(root, subdomain), ch.Key)
+			return nil
 		}
 	}
 
+	clientcfg, targets, err := c.getAccountTargets(cfg, ch.ResourceNamespace, budget, root, subdomain)
+	if err != nil {
+		return err
+	}
+	if cfg.AuditOnly {
+		targets = auditTargets(targets, ch)
+	} else if cfg.SnapshotBeforeWrite {
+		snapshotZone(c.newClient(*clientcfg), targets[0].root, "cleaning up "+recordName(root, subdomain))
+	}
+	if !cfg.AuditOnly && cfg.suspiciousReadPolicy() == suspiciousReadRetry {
+		targets = expectValues(targets, c.clock, c.challenges.presentedKeys(recordName(root, subdomain), ch.Key))
+	}
+	if err := c.writeRecord(cfg, targets, recordName(root, subdomain), ch.Key, false); err != nil {
+		return err
+	}
+	if cfg.AuditOnly {
+		c.challenges.done(recordName(root, subdomain), ch.Key)
+		return nil
+	}
+	if err := c.tracker.Remove(recordName(root, subdomain), ch.Key); err != nil {
+		return recordErrorf(root, subdomain, "untrack presented value of", err)
+	}
+	klog.V(2).Info(withContext(fmt.Sprintf("cleaned up TXT value %s for %s", keyHash(ch.Key), recordName(root, subdomain)), challengeContext(ch)))
+	c.challenges.done(recordName(root, subdomain), ch.Key)
 	return nil
 }
 
@@ -198,56 +630,402 @@ func (c *gandiDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
 // provider accounts.
 // The stopCh can be used to handle early termination of the webhook, in cases
 // where a SIGTERM or similar signal is sent to the webhook process.
-func (c *gandiDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, _ <-chan struct{}) error {
+func (c *gandiDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
 	klog.V(6).Infof("call function Initialize")
 	cl, err := kubernetes.NewForConfig(kubeClientConfig)
 	if err != nil {
 		return fmt.Errorf("unable to get k8s client: %v", err)
 	}
 	c.client = cl
+	rbacCheckOnce.Do(func() { warnMissingSecretAccess(cl) })
+	if name := os.Getenv("DEFAULTS_CONFIGMAP"); name != "" {
+		namespace := os.Getenv("DEFAULTS_CONFIGMAP_NAMESPACE")
+		if namespace == "" {
+			return fmt.Errorf("DEFAULTS_CONFIGMAP_NAMESPACE must be specified with DEFAULTS_CONFIGMAP")
+		}
+		c.sharedDefaults = newDefaultsConfigMap(cl, namespace, name)
+	}
+	c.logEffectiveConfig()
+	if verifyCredentialsFromEnv() {
+		c.verifyCredentials()
+	}
+
+	if name := os.Getenv("TRACKING_CONFIGMAP"); name != "" {
+		namespace := os.Getenv("TRACKING_CONFIGMAP_NAMESPACE")
+		if namespace == "" {
+			return fmt.Errorf("TRACKING_CONFIGMAP_NAMESPACE must be specified with TRACKING_CONFIGMAP")
+		}
+		klog.V(2).Infof("tracking presented values in configmap %s/%s", namespace, name)
+		c.tracker = newConfigMapTracker(cl, namespace, name)
+	}
+
+	if address := os.Getenv("ADMIN_ADDRESS"); address != "" && c.serveAdmin {
+		if err := startAdminServer(address, os.Getenv("ADMIN_TOKEN"), c.tracker, c.challenges, func() repairReport {
+			return c.repairDrift(defaultRepairTimeout)
+		}, stopCh); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // loadConfig is a small helper function that decodes JSON configuration into
 // the typed config struct.
 func loadConfig(cfgJSON *extapi.JSON) (gandiDNSProviderConfig, error) {
+	return loadConfigWithDefaults(nil, cfgJSON)
+}
+
+// loadConfigWithDefaults decodes the default config of a solver, then the
+// config of the issuer over it.
+func loadConfigWithDefaults(defaults json.RawMessage, cfgJSON *extapi.JSON) (gandiDNSProviderConfig, error) {
+	return decodeConfig(gandiDNSProviderConfig{}, defaults, cfgJSON)
+}
+
+// solverConfig decodes the config of an issuer over the defaults of the
+// solver, themselves decoded over those of the defaults ConfigMap if any.
+func (c *gandiDNSProviderSolver) solverConfig(cfgJSON *extapi.JSON) (gandiDNSProviderConfig, error) {
 	cfg := gandiDNSProviderConfig{}
+	if c.sharedDefaults != nil {
+		// The ConfigMap is validated when read.
+		_ = json.Unmarshal(c.sharedDefaults.get(), &cfg)
+	}
+	return decodeConfig(cfg, c.defaults, cfgJSON)
+}
+
+// decodeConfig decodes defaults, then the config of the issuer over cfg.
+func decodeConfig(cfg gandiDNSProviderConfig, defaults json.RawMessage, cfgJSON *extapi.JSON) (gandiDNSProviderConfig, error) {
+	if len(defaults) > 0 {
+		if err := json.Unmarshal(defaults, &cfg); err != nil {
+			return cfg, fmt.Errorf("error decoding default solver config: %v", err)
+		}
+	}
 	// handle the 'base case' where no configuration has been provided
-	if cfgJSON == nil {
-		return cfg, nil
+	if cfgJSON != nil {
+		scope, namespace := cfg.CredentialScope, cfg.CredentialNamespace
+		if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
+			return cfg, fmt.Errorf("error decoding solver config: %v", err)
+		}
+		// Reading secrets from another namespace than the one of the
+		// challenge is up to the webhook, not to the tenants creating
+		// issuers.
+		if cfg.CredentialNamespace != namespace || (cfg.CredentialScope == credentialScopeCluster && scope != credentialScopeCluster) {
+			return cfg, fmt.Errorf("invalid solver config: credentialScope %q and credentialNamespace can only be set in the solver defaults", credentialScopeCluster)
+		}
 	}
-	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
-		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid solver config: %v", err)
 	}
 
 	return cfg, nil
 }
 
-func (c *gandiDNSProviderSolver) getDomainAndEntry(ch *v1alpha1.ChallengeRequest) (string, string) {
-	// Both ch.ResolvedZone and ch.ResolvedFQDN end with a dot: '.'
-	entry := strings.TrimSuffix(ch.ResolvedFQDN, ch.ResolvedZone)
-	entry = strings.TrimSuffix(entry, ".")
-	domain := strings.TrimSuffix(ch.ResolvedZone, ".")
-	return entry, domain
+// validate checks the values decoded from the solver config.
+func (cfg *gandiDNSProviderConfig) validate() error {
+	if cfg.PropagationPollInterval != nil && cfg.PropagationPollInterval.Duration <= 0 {
+		return fmt.Errorf("propagationPollInterval must be positive")
+	}
+	if j := cfg.PropagationPollJitter; j != nil && (*j < 0 || *j >= 1) {
+		return fmt.Errorf("propagationPollJitter must be at least 0 and below 1")
+	}
+	if cfg.PropagationTimeout != nil && cfg.PropagationTimeout.Duration <= 0 {
+		return fmt.Errorf("propagationTimeout must be positive")
+	}
+	if cfg.PropagationAuthoritative && len(cfg.PropagationNameservers) > 0 {
+		return fmt.Errorf("propagationAuthoritative and propagationNameservers cannot be set together")
+	}
+	if cfg.PropagationDoHURL != "" {
+		if err := validateDoHURL(cfg.PropagationDoHURL); err != nil {
+			return fmt.Errorf("propagationDoHURL: %v", err)
+		}
+		if cfg.PropagationAuthoritative || len(cfg.PropagationNameservers) > 0 {
+			return fmt.Errorf("propagationDoHURL cannot be set along with propagationNameservers or propagationAuthoritative")
+		}
+	}
+	if cfg.NameserverCacheTTL != nil && cfg.NameserverCacheTTL.Duration <= 0 {
+		return fmt.Errorf("nameserverCacheTTL must be positive")
+	}
+	if cfg.MaintenanceRetryInterval != nil && cfg.MaintenanceRetryInterval.Duration <= 0 {
+		return fmt.Errorf("maintenanceRetryInterval must be positive")
+	}
+	if cfg.MaintenanceRetryTimeout != nil && cfg.MaintenanceRetryTimeout.Duration <= 0 {
+		return fmt.Errorf("maintenanceRetryTimeout must be positive")
+	}
+	if cfg.APIKeyTag != "" && cfg.APIKeyTagMapSecretRef == nil {
+		return fmt.Errorf("apiKeyTag requires apiKeyTagMapSecretRef")
+	}
+	switch cfg.CredentialScope {
+	case "", credentialScopeIssuer:
+		if cfg.CredentialNamespace != "" {
+			return fmt.Errorf("credentialNamespace requires credentialScope %q", credentialScopeCluster)
+		}
+	case credentialScopeCluster:
+		if cfg.CredentialNamespace == "" {
+			return fmt.Errorf("credentialScope %q requires credentialNamespace", credentialScopeCluster)
+		}
+	default:
+		return fmt.Errorf("unknown credentialScope %q, must be %q or %q", cfg.CredentialScope, credentialScopeIssuer, credentialScopeCluster)
+	}
+	if cfg.ResolverAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.ResolverAddress); err != nil {
+			return fmt.Errorf("resolverAddress must be host:port: %v", err)
+		}
+	}
+	if cfg.TTL < 0 {
+		return fmt.Errorf("ttl must be positive")
+	}
+	if cfg.MaxTTL != 0 && (cfg.MaxTTL < GandiMinTtl || cfg.MaxTTL > GandiMaxTtl) {
+		return fmt.Errorf("maxTTL must be at least the minimum TTL %d and at most %d", GandiMinTtl, GandiMaxTtl)
+	}
+	// A TTL above maxTTL is brought down to it.
+	tooHigh := cfg.TTL > GandiMaxTtl && cfg.MaxTTL == 0
+	if cfg.TTL != 0 && (cfg.TTL < GandiMinTtl || tooHigh) && !cfg.AdjustTTL {
+		return fmt.Errorf("ttl must be at least %d and at most %d, or set adjustTTL", GandiMinTtl, GandiMaxTtl)
+	}
+	if cfg.OperationTimeout != nil && cfg.OperationTimeout.Duration <= 0 {
+		return fmt.Errorf("operationTimeout must be positive")
+	}
+	if cfg.DiscoverZone && cfg.ZoneName != "" {
+		return fmt.Errorf("discoverZone cannot be set along with zoneName")
+	}
+	if cfg.ExistingCheckTimeout != nil && cfg.ExistingCheckTimeout.Duration <= 0 {
+		return fmt.Errorf("existingCheckTimeout must be positive")
+	}
+	if cfg.MinWriteInterval != nil && (cfg.MinWriteInterval.Duration <= 0 || cfg.MinWriteInterval.Duration > maxMinWriteInterval) {
+		return fmt.Errorf("minWriteInterval must be positive and at most %s", maxMinWriteInterval)
+	}
+	if cfg.SecretNotFoundTimeout != nil && (cfg.SecretNotFoundTimeout.Duration <= 0 || cfg.SecretNotFoundTimeout.Duration > maxSecretNotFoundTimeout) {
+		return fmt.Errorf("secretNotFoundTimeout must be positive and at most %s", maxSecretNotFoundTimeout)
+	}
+	if cfg.APIURL != "" {
+		if err := validateAPIURL(cfg.APIURL); err != nil {
+			return err
+		}
+	}
+	if cfg.APIURL != "" && len(cfg.APIURLs) > 0 {
+		return fmt.Errorf("apiURL and apiURLs are mutually exclusive")
+	}
+	for _, apiURL := range cfg.APIURLs {
+		if err := validateAPIURL(apiURL); err != nil {
+			return fmt.Errorf("apiURLs: %v", err)
+		}
+	}
+	if err := validateAPIVersion(cfg.apiVersion()); err != nil {
+		return err
+	}
+	switch cfg.WriteStrategy {
+	case "", writeStrategyUpdate, writeStrategyRecreate:
+	default:
+		return fmt.Errorf("writeStrategy must be %q or %q", writeStrategyUpdate, writeStrategyRecreate)
+	}
+	switch cfg.SuspiciousReadPolicy {
+	case "", suspiciousReadRetry, suspiciousReadIgnore:
+	default:
+		return fmt.Errorf("suspiciousReadPolicy must be %q or %q", suspiciousReadRetry, suspiciousReadIgnore)
+	}
+	switch cfg.UnexpectedRecordTypePolicy {
+	case "", unexpectedRecordTypeFail, unexpectedRecordTypeIgnore:
+	default:
+		return fmt.Errorf("unexpectedRecordTypePolicy must be %q or %q", unexpectedRecordTypeFail, unexpectedRecordTypeIgnore)
+	}
+	if cfg.CoTenant && cfg.WriteStrategy == writeStrategyRecreate {
+		return fmt.Errorf("writeStrategy %q drops the values of other solvers and cannot be used with coTenant", writeStrategyRecreate)
+	}
+	if cfg.PreserveExisting && cfg.WriteStrategy == writeStrategyRecreate {
+		return fmt.Errorf("writeStrategy %q briefly drops existing values and cannot be used with preserveExisting", writeStrategyRecreate)
+	}
+	if cfg.WriteQuorum < 0 || cfg.WriteQuorum > 1+len(cfg.SecondaryAccounts) {
+		return fmt.Errorf("writeQuorum must be between 1 and the number of accounts (%d)", 1+len(cfg.SecondaryAccounts))
+	}
+	return nil
 }
 
-// Get Gandi API key from Kubernetes secret.
-func (c *gandiDNSProviderSolver) getApiKey(cfg *gandiDNSProviderConfig, namespace string) (*string, error) {
-	secretName := cfg.APIKeySecretRef.LocalObjectReference.Name
+// apiVersion returns the Gandi API version to use.
+func (cfg *gandiDNSProviderConfig) apiVersion() string {
+	if cfg.APIVersion != "" {
+		return cfg.APIVersion
+	}
+	return apiVersionFromEnv()
+}
 
-	klog.V(6).Infof("try to load secret `%s` with key `%s`", secretName, cfg.APIKeySecretRef.Key)
+// apiEndpoint returns the Gandi API endpoint to use, the first one if there
+// are several.
+func (cfg *gandiDNSProviderConfig) apiEndpoint() string {
+	return cfg.apiEndpoints()[0]
+}
 
-	sec, err := c.client.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("unable to get secret `%s`; %v", secretName, err)
+// apiEndpoints returns the Gandi API endpoints to use, in order.
+func (cfg *gandiDNSProviderConfig) apiEndpoints() []string {
+	apiURLs := cfg.APIURLs
+	if len(apiURLs) == 0 && cfg.APIURL != "" {
+		apiURLs = []string{cfg.APIURL}
+	}
+	if len(apiURLs) == 0 {
+		apiURLs = []string{defaultAPIURL}
+	}
+	endpoints := make([]string, 0, len(apiURLs))
+	for _, apiURL := range apiURLs {
+		endpoints = append(endpoints, apiEndpoint(apiURL))
 	}
+	return endpoints
+}
 
-	secBytes, ok := sec.Data[cfg.APIKeySecretRef.Key]
-	if !ok {
-		return nil, fmt.Errorf("key %q not found in secret \"%s/%s\"", cfg.APIKeySecretRef.Key,
-			cfg.APIKeySecretRef.LocalObjectReference.Name, namespace)
+// recordOptions returns how challenge records are written.
+func (cfg *gandiDNSProviderConfig) recordOptions() *recordOptions {
+	opts := &recordOptions{
+		writeStrategy:     cfg.WriteStrategy,
+		coTenant:          cfg.CoTenant,
+		preserveExisting:  cfg.PreserveExisting,
+		ttl:               cfg.ttl(),
+		preserveTTL:       cfg.PreserveTTL,
+		maxTTL:            cfg.MaxTTL,
+		skipExistingCheck: cfg.SkipExistingCheck,
+
+		existingCheckFallback: cfg.ExistingCheckFallback,
+		forceWrite:            cfg.ForceWrite,
+		normalizeLegacyValues: cfg.NormalizeLegacyValues,
+
+		cleanUpRequireTTLMatch: cfg.CleanUpRequireTTLMatch,
+		recordTypePolicy:       cfg.unexpectedRecordTypePolicy(),
+	}
+	if cfg.ExistingCheckTimeout != nil {
+		opts.existingCheckTimeout = cfg.ExistingCheckTimeout.Duration
+	}
+	if opts.writeStrategy == "" {
+		opts.writeStrategy = writeStrategyUpdate
+	}
+	return opts
+}
+
+// ttl returns the TTL of the challenge records, zero for the default,
+// brought within the range Gandi accepts if AdjustTTL is set and down to
+// MaxTTL.
+func (cfg *gandiDNSProviderConfig) ttl() int {
+	ttl := cfg.TTL
+	switch {
+	case ttl == 0 || !cfg.AdjustTTL:
+	case ttl < GandiMinTtl:
+		ttl = GandiMinTtl
+	case ttl > GandiMaxTtl:
+		ttl = GandiMaxTtl
+	}
+	if ttl != cfg.TTL {
+		klog.Warningf("ttl %d is outside of the range Gandi accepts, using %d", cfg.TTL, ttl)
+	}
+	if cfg.MaxTTL != 0 && ttl > cfg.MaxTTL {
+		klog.Warningf("ttl %d exceeds maxTTL, using %d", ttl, cfg.MaxTTL)
+		ttl = cfg.MaxTTL
 	}
+	return ttl
+}
+
+// suspiciousReadPolicy returns SuspiciousReadPolicy, suspiciousReadRetry by
+// default.
+func (cfg *gandiDNSProviderConfig) suspiciousReadPolicy() string {
+	if cfg.SuspiciousReadPolicy == "" {
+		return suspiciousReadRetry
+	}
+	return cfg.SuspiciousReadPolicy
+}
+
+// unexpectedRecordTypePolicy returns UnexpectedRecordTypePolicy,
+// unexpectedRecordTypeFail by default.
+func (cfg *gandiDNSProviderConfig) unexpectedRecordTypePolicy() string {
+	if cfg.UnexpectedRecordTypePolicy == "" {
+		return unexpectedRecordTypeFail
+	}
+	return cfg.UnexpectedRecordTypePolicy
+}
 
-	apiKey := string(secBytes)
-	return &apiKey, nil
+// operationTimeout returns the time budget of a Present or CleanUp, zero
+// if there is none.
+func (cfg *gandiDNSProviderConfig) operationTimeout() time.Duration {
+	if cfg.OperationTimeout == nil {
+		return 0
+	}
+	return cfg.OperationTimeout.Duration
+}
+
+// nameserverCacheTTL returns how long the nameservers of a zone are cached.
+func (cfg *gandiDNSProviderConfig) nameserverCacheTTL() time.Duration {
+	if cfg.NameserverCacheTTL == nil {
+		return defaultNameserverCacheTTL
+	}
+	return cfg.NameserverCacheTTL.Duration
+}
+
+// propagationPollJitter returns the fraction of the poll interval by which
+// propagation polls are spread.
+func (cfg *gandiDNSProviderConfig) propagationPollJitter() float64 {
+	if cfg.PropagationPollJitter == nil {
+		return defaultPropagationPollJitter
+	}
+	return *cfg.PropagationPollJitter
+}
+
+// secretNotFoundTimeout returns how long a secret that does not exist is
+// read again, 0 if it is not.
+func (cfg *gandiDNSProviderConfig) secretNotFoundTimeout() time.Duration {
+	if cfg.SecretNotFoundTimeout == nil {
+		return 0
+	}
+	return cfg.SecretNotFoundTimeout.Duration
+}
+
+// apiKeyTag returns the tag selecting the API key in the tag map, the
+// namespace of the challenge by default.
+func (cfg *gandiDNSProviderConfig) apiKeyTag(namespace string) string {
+	if cfg.APIKeyTag != "" {
+		return cfg.APIKeyTag
+	}
+	return namespace
+}
+
+// minWriteInterval returns the interval within which writes are not
+// repeated, zero if they always are.
+func (cfg *gandiDNSProviderConfig) minWriteInterval() time.Duration {
+	if cfg.MinWriteInterval == nil {
+		return 0
+	}
+	return cfg.MinWriteInterval.Duration
+}
+
+// credentialNamespace returns the namespace secrets are read from for a
+// challenge in namespace.
+func (cfg *gandiDNSProviderConfig) credentialNamespace(namespace string) string {
+	if cfg.CredentialScope == credentialScopeCluster {
+		return cfg.CredentialNamespace
+	}
+	return namespace
+}
+
+// failOnCleanupError reports whether CleanUp errors fail the challenge.
+func (cfg *gandiDNSProviderConfig) failOnCleanupError() bool {
+	return cfg.FailOnCleanupError == nil || *cfg.FailOnCleanupError
+}
+
+// writeQuorum returns the number of accounts a challenge record must be
+// written to for Present and CleanUp to succeed.
+func (cfg *gandiDNSProviderConfig) writeQuorum() int {
+	if cfg.WriteQuorum == 0 {
+		return 1 + len(cfg.SecondaryAccounts)
+	}
+	return cfg.WriteQuorum
+}
+
+// maintenanceRetryPolicy returns the retry policy for Gandi maintenance
+// errors, or nil if they follow the default policy.
+func (cfg *gandiDNSProviderConfig) maintenanceRetryPolicy() *retryPolicy {
+	if cfg.MaintenanceRetryTimeout == nil {
+		return nil
+	}
+	interval := defaultMaintenanceRetryInterval
+	if cfg.MaintenanceRetryInterval != nil {
+		interval = cfg.MaintenanceRetryInterval.Duration
+	}
+	return &retryPolicy{
+		initialDelay: interval,
+		maxDelay:     interval,
+		budget:       cfg.MaintenanceRetryTimeout.Duration,
+		jitter:       retryJitterFromEnv(),
+	}
 }
@@ -1,10 +1,22 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
 	"github.com/cert-manager/cert-manager/test/acme/dns"
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/types"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 var (
@@ -16,7 +28,7 @@ func TestRunsSuite(t *testing.T) {
 	// snippet of valid configuration that should be included on the
 	// ChallengeRequest passed as part of the test cases.
 
-	solver := &gandiDNSProviderSolver{}
+	solver := newGandiDNSProviderSolver()
 	fixture := dns.NewFixture(solver,
 		dns.SetResolvedZone(zone),
 		dns.SetAllowAmbientCredentials(false),
@@ -25,3 +37,436 @@ func TestRunsSuite(t *testing.T) {
 
 	fixture.RunConformance(t)
 }
+
+// newTestSolver returns a solver backed by a fake clock, a fake Kubernetes
+// clientset holding the gandi-credentials secret and the given fake LiveDNS
+// client.
+func newTestSolver(gandiClient *fakelivedns.Client) *gandiDNSProviderSolver {
+	solver := newGandiDNSProviderSolver()
+	solver.clock = newFakeClock()
+	solver.client = fake.NewSimpleClientset(newSecret("default", "gandi-credentials", map[string]string{"api-token": "secret"}))
+	solver.newClient = func(config.Config) liveDNSClient {
+		return gandiClient
+	}
+	return solver
+}
+
+// newTestChallengeRequest returns a challenge for fqdn in zone using the
+// gandi-credentials secret, with optional extra solver config fields.
+func newTestChallengeRequest(fqdn, zone, key string, extraConfig string) *v1alpha1.ChallengeRequest {
+	cfg := `{"apiKeySecretRef": {"name": "gandi-credentials", "key": "api-token"}` + extraConfig + `}`
+	return &v1alpha1.ChallengeRequest{
+		Key:               key,
+		ResourceNamespace: "default",
+		ResolvedFQDN:      fqdn,
+		ResolvedZone:      zone,
+		Config:            &extapi.JSON{Raw: []byte(cfg)},
+	}
+}
+
+func BenchmarkPresent(b *testing.B) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := solver.Present(ch); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(gandiClient.TotalCalls())/float64(b.N), "calls/op")
+}
+
+func BenchmarkPresentCleanUp(b *testing.B) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := solver.Present(ch); err != nil {
+			b.Fatal(err)
+		}
+		if err := solver.CleanUp(ch); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(gandiClient.TotalCalls())/float64(b.N), "calls/op")
+}
+
+func BenchmarkPresentConcurrentValue(b *testing.B) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	apex := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "apex", "")
+	wildcard := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "wildcard", "")
+	if err := solver.Present(apex); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := solver.Present(wildcard); err != nil {
+			b.Fatal(err)
+		}
+		if err := solver.CleanUp(wildcard); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(gandiClient.TotalCalls()-2)/float64(b.N), "calls/op")
+}
+
+func TestErrorsIncludeRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(*gandiDNSProviderSolver, *fakelivedns.Client)
+		cleanUp bool
+		want    string
+	}{
+		{
+			name:  "create",
+			setup: func(_ *gandiDNSProviderSolver, f *fakelivedns.Client) { f.Err = errors.New("403: Forbidden") },
+			want:  "unable to create TXT record _acme-challenge.sub in zone example.com: 403: Forbidden",
+		},
+		{
+			name: "update",
+			setup: func(_ *gandiDNSProviderSolver, f *fakelivedns.Client) {
+				_, _ = f.CreateDomainRecord("example.com", "_acme-challenge.sub", "TXT", GandiMinTtl, []string{"other"})
+				f.UpdateErr = errors.New("400: Bad Request")
+			},
+			want: "unable to update TXT record _acme-challenge.sub in zone example.com: 400: Bad Request",
+		},
+		{
+			name:  "API key",
+			setup: func(s *gandiDNSProviderSolver, _ *fakelivedns.Client) { s.client = fake.NewSimpleClientset() },
+			want:  "unable to get API key for TXT record _acme-challenge.sub in zone example.com: ",
+		},
+		{
+			name: "delete",
+			setup: func(s *gandiDNSProviderSolver, f *fakelivedns.Client) {
+				_, _ = f.CreateDomainRecord("example.com", "_acme-challenge.sub", "TXT", GandiMinTtl, []string{"key"})
+				_ = s.tracker.Add("_acme-challenge.sub.example.com", "key")
+				f.DeleteErr = errors.New("500: Internal Server Error")
+			},
+			cleanUp: true,
+			want:    "unable to delete TXT record _acme-challenge.sub in zone example.com: 500: Internal Server Error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			solver := newTestSolver(gandiClient)
+			tt.setup(solver, gandiClient)
+
+			ch := newTestChallengeRequest("_acme-challenge.sub.example.com.", "example.com.", "key", "")
+			var err error
+			if tt.cleanUp {
+				err = solver.CleanUp(ch)
+			} else {
+				err = solver.Present(ch)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailOnCleanupError(t *testing.T) {
+	for _, tt := range []struct {
+		config  string
+		wantErr bool
+	}{
+		{config: "", wantErr: true},
+		{config: `, "failOnCleanupError": true`, wantErr: true},
+		{config: `, "failOnCleanupError": false`, wantErr: false},
+	} {
+		gandiClient := fakelivedns.New()
+		solver := newTestSolver(gandiClient)
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", tt.config)
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("%s: present: %v", tt.config, err)
+		}
+
+		gandiClient.DeleteErr = errors.New("500: Internal Server Error")
+		err := solver.CleanUp(ch)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.config, err, tt.wantErr)
+		}
+		// The value is left behind either way and remains tracked.
+		if ok, _ := solver.tracker.Has("_acme-challenge.example.com", "key"); !ok {
+			t.Errorf("%s: value no longer tracked", tt.config)
+		}
+		if !tt.wantErr && len(solver.challenges.List()) != 0 {
+			t.Errorf("%s: challenge still in flight after an ignored error", tt.config)
+		}
+	}
+}
+
+func TestCleanUpReadError(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+
+	// The value may still be there: the clean up fails rather than
+	// forgetting it.
+	gandiClient.Fail("GetDomainRecordsByName", 500, 100)
+	err := solver.CleanUp(ch)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("error = %v, want the read error", err)
+	}
+	if ok, _ := solver.tracker.Has("_acme-challenge.example.com", "key"); !ok {
+		t.Error("value no longer tracked")
+	}
+	if len(solver.challenges.List()) != 1 {
+		t.Error("challenge no longer in flight")
+	}
+	if got, want := gandiClient.Values("example.com", "_acme-challenge", "TXT"), []string{`"key"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+}
+
+func TestPresentSuspendedDomain(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{name: "forbidden", err: fakelivedns.StatusError(403, "The domain example.com is suspended")},
+		{name: "unavailable", err: fakelivedns.StatusError(503, "Domain is on hold (clientHold)")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			gandiClient.Err = tt.err
+			solver := newTestSolver(gandiClient)
+			ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+
+			err := solver.Present(ch)
+			if err == nil || !strings.HasPrefix(err.Error(), "domain example.com is suspended at Gandi; challenge cannot proceed") {
+				t.Errorf("Present() error = %v, want a suspended domain error", err)
+			}
+			for method, n := range gandiClient.CallCounts() {
+				if n != 1 {
+					t.Errorf("%d calls to %s, want no retry", n, method)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorClassIgnoresNames(t *testing.T) {
+	for _, tt := range []struct {
+		err                  error
+		suspended, retryable bool
+		connection           bool
+	}{
+		{err: errors.New("403: The domain example.com is suspended"), suspended: true},
+		{err: &types.RequestError{StatusCode: 423, Err: errors.New("423: Domain is on hold")}, suspended: true},
+		{err: errors.New("unable to get TXT record _acme-challenge in zone suspended-shop.com: 500: Internal Server Error"), retryable: true},
+		{err: errors.New("404: Can't find the DNS record _acme-challenge.on-hold/TXT in LiveDNS")},
+		{err: errors.New("unable to get TXT record _acme-challenge in zone timeout-eof.com: 400: Bad request")},
+		{err: &netError{errors.New("dial tcp: connect: connection refused")}, retryable: true, connection: true},
+		{err: fmt.Errorf("Fail to read the body (error '%w')", io.ErrUnexpectedEOF), retryable: true, connection: true},
+	} {
+		if got := isSuspendedError(tt.err); got != tt.suspended {
+			t.Errorf("isSuspendedError(%v) = %t, want %t", tt.err, got, tt.suspended)
+		}
+		if got := isRetryableError(tt.err); got != tt.retryable {
+			t.Errorf("isRetryableError(%v) = %t, want %t", tt.err, got, tt.retryable)
+		}
+		if got := isConnectionError(tt.err); got != tt.connection {
+			t.Errorf("isConnectionError(%v) = %t, want %t", tt.err, got, tt.connection)
+		}
+	}
+}
+
+func TestPresentMissingScope(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "missing scope", err: fakelivedns.StatusError(403, "The access token does not have the required scopes"),
+			want: `the API key lacks the permission to manage the DNS records of domain example.com; use the API key of a Gandi user with technical rights on the domain`},
+		{name: "forbidden", err: fakelivedns.StatusError(403, "Access was denied to this resource."),
+			want: "unable to create TXT record _acme-challenge in zone example.com: 403: Access was denied to this resource."},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			gandiClient.Err = tt.err
+			solver := newTestSolver(gandiClient)
+			ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+
+			if err := solver.Present(ch); err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("Present() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameKeyInSeveralZones(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	com := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+	org := newTestChallengeRequest("_acme-challenge.example.org.", "example.org.", "key", "")
+
+	for _, ch := range []*v1alpha1.ChallengeRequest{com, org} {
+		if err := solver.Present(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, zone := range []string{"example.com", "example.org"} {
+		if got := gandiClient.Values(zone, "_acme-challenge", "TXT"); len(got) != 1 || got[0] != `"key"` {
+			t.Errorf("%s values = %v, want the key", zone, got)
+		}
+	}
+
+	if err := solver.CleanUp(com); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("example.com values after its clean up = %v, want none", got)
+	}
+	if got := gandiClient.Values("example.org", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("example.org values after the clean up of example.com = %v, want the key", got)
+	}
+	if ok, _ := solver.tracker.Has("_acme-challenge.example.org", "key"); !ok {
+		t.Error("value of example.org no longer tracked after the clean up of example.com")
+	}
+
+	if err := solver.CleanUp(org); err != nil {
+		t.Fatal(err)
+	}
+	if got := gandiClient.Values("example.org", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("example.org values after its clean up = %v, want none", got)
+	}
+	if list := solver.challenges.List(); len(list) != 0 {
+		t.Errorf("in-flight challenges = %+v, want none", list)
+	}
+}
+
+func TestPresentDomainNotOnLiveDNS(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	// Gandi does not find the domain itself, rather than its record.
+	gandiClient.Err = fakelivedns.StatusError(404, "Domain example.com not found")
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+
+	err := solver.Present(ch)
+	if err == nil || !strings.HasPrefix(err.Error(), "domain example.com is not managed by Gandi LiveDNS; migrate it") {
+		t.Errorf("Present() error = %v, want an error asking to migrate to LiveDNS", err)
+	}
+}
+
+func TestIsNotOnLiveDNSError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{err: errors.New("404: Domain not found"), want: true},
+		{err: errors.New("unable to create TXT record _acme-challenge in zone example.com: 404: The domain does not exist"), want: true},
+		{err: errors.New("404: Can't find the DNS record _acme-challenge/TXT in LiveDNS"), want: false},
+		{err: errors.New("403: Domain access denied"), want: false},
+	} {
+		if got := isNotOnLiveDNSError(tt.err); got != tt.want {
+			t.Errorf("isNotOnLiveDNSError(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestErrorStatusCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{err: errors.New("404: Can't find the DNS record _acme-challenge/TXT in LiveDNS"), want: 404},
+		{err: errors.New("unable to get TXT record _acme-challenge in zone 500px.com: 404: Can't find the DNS record _acme-challenge.429/TXT in LiveDNS"), want: 404},
+		{err: errors.New("unable to get TXT record _acme-challenge.404 in zone 500px.com: 500: Internal Server Error"), want: 500},
+		{err: errors.New("unable to get TXT record _acme-challenge in zone 500px.com: dial tcp: connection refused"), want: 0},
+		{err: errors.New("unable to get TXT record _acme-challenge in zone 429.example: 403"), want: 403},
+		{err: &types.RequestError{StatusCode: 429, Err: errors.New("429: Too many requests for 500px.com")}, want: 429},
+		{err: fmt.Errorf("wrapped: %w", &types.RequestError{StatusCode: 409, Err: errors.New("name: already exists in 500px.com")}), want: 409},
+		{err: fmt.Errorf("wrapped: %v", &types.RequestError{StatusCode: 503, Err: errors.New("503")}), want: 503},
+	} {
+		if got := errorStatusCode(tt.err); got != tt.want {
+			t.Errorf("errorStatusCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+
+	// A 404 is not retried and a 5xx is not read as a missing record,
+	// whatever numbers the names in them hold.
+	if err := errors.New("404: Can't find the DNS record _acme-challenge.429/TXT in LiveDNS"); isRetryableError(err) {
+		t.Errorf("isRetryableError(%v) = true, want false", err)
+	}
+	if err := errors.New("unable to get TXT record _acme-challenge in zone 404.example.com: 500: Internal Server Error"); isNotFoundError(err) || !isRetryableError(err) {
+		t.Errorf("%v: read as not found or not retryable", err)
+	}
+}
+
+func TestErrorOverrides(t *testing.T) {
+	t.Setenv("ERROR_CLASS_OVERRIDES", `[{"pattern": "(?i)quota exceeded", "class": "retryable"}, {"pattern": "^503", "class": "permanent"}, {"pattern": "503", "class": "retryable"}]`)
+	overrides, err := errorOverridesFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved []errorOverride) { errorOverrides = saved }(errorOverrides)
+	errorOverrides = overrides
+
+	if err := errors.New("403: Quota exceeded"); !isRetryableError(err) {
+		t.Errorf("isRetryableError(%v) = false, want the override to make it retryable", err)
+	}
+	if err := errors.New("503: Service unavailable"); isRetryableError(err) {
+		t.Errorf("isRetryableError(%v) = true, want the first override matching to make it permanent", err)
+	}
+	if err := errors.New("502: Bad gateway"); !isRetryableError(err) {
+		t.Errorf("isRetryableError(%v) = false, want the built-in classification without a matching override", err)
+	}
+
+	for _, env := range []string{
+		`[{"pattern": "x", "class": "fatal"}]`,
+		`[{"pattern": "(", "class": "retryable"}]`,
+		`[{"class": "retryable"}]`,
+		`{}`,
+	} {
+		t.Setenv("ERROR_CLASS_OVERRIDES", env)
+		if _, err := errorOverridesFromEnv(); err == nil {
+			t.Errorf("errorOverridesFromEnv() with %s succeeded, want an error", env)
+		}
+	}
+}
+
+func TestDeduplicatePresent(t *testing.T) {
+	gandiClient := &blockingClient{Client: fakelivedns.New(), started: make(chan struct{}), release: make(chan struct{})}
+	solver := newTestSolver(nil)
+	solver.newClient = func(config.Config) liveDNSClient {
+		return gandiClient
+	}
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "deduplicatePresent": true`)
+
+	const n = 5
+	errs := make(chan error, n)
+	go func() { errs <- solver.Present(ch) }()
+	<-gandiClient.started
+	for i := 1; i < n; i++ {
+		go func() { errs <- solver.Present(ch) }()
+	}
+	// Let the duplicate calls join the one in flight.
+	time.Sleep(100 * time.Millisecond)
+	close(gandiClient.release)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]int{"GetDomainRecordsByName": 1, "CreateDomainRecord": 1}
+	if got := gandiClient.CallCounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v for all %d Present calls", got, want, n)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultNameserverCacheTTL is how long the nameservers of a zone are cached
+// unless nameserverCacheTTL is set.
+const defaultNameserverCacheTTL = 5 * time.Minute
+
+// nsResolver looks up the NS records of a name. It is satisfied by
+// *net.Resolver.
+type nsResolver interface {
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+}
+
+// nameserverCache caches the nameservers of zones, so a burst of challenges
+// for the same domain looks them up once.
+type nameserverCache struct {
+	mu      sync.Mutex
+	entries map[string]nameserverCacheEntry
+}
+
+type nameserverCacheEntry struct {
+	addresses []string
+	expires   time.Time
+}
+
+func newNameserverCache() *nameserverCache {
+	return &nameserverCache{entries: map[string]nameserverCacheEntry{}}
+}
+
+// nameservers returns the addresses (host:port) of the nameservers of zone,
+// looked up with resolver unless they were less than ttl ago.
+func (nc *nameserverCache) nameservers(clk clock, zone string, ttl time.Duration, resolver nsResolver) ([]string, error) {
+	zone = strings.ToLower(strings.Trim(zone, "."))
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if entry, ok := nc.entries[zone]; ok && clk.Now().Before(entry.expires) {
+		return entry.addresses, nil
+	}
+	records, err := resolver.LookupNS(context.Background(), zone+".")
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up the nameservers of zone %s: %v", zone, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("zone %s has no nameservers", zone)
+	}
+	addresses := make([]string, 0, len(records))
+	for _, ns := range records {
+		addresses = append(addresses, net.JoinHostPort(strings.TrimSuffix(ns.Host, "."), "53"))
+	}
+	nc.entries[zone] = nameserverCacheEntry{addresses: addresses, expires: clk.Now().Add(ttl)}
+	return addresses, nil
+}
+
+// refresh drops the nameservers cached for zone, so they are looked up again
+// on the next call to nameservers.
+func (nc *nameserverCache) refresh(zone string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	delete(nc.entries, strings.ToLower(strings.Trim(zone, ".")))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeNSResolver returns its nameservers, counting the lookups.
+type fakeNSResolver struct {
+	hosts   []string
+	lookups int
+}
+
+func (r *fakeNSResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	r.lookups++
+	records := make([]*net.NS, 0, len(r.hosts))
+	for _, host := range r.hosts {
+		records = append(records, &net.NS{Host: host})
+	}
+	return records, nil
+}
+
+func TestNameserverCache(t *testing.T) {
+	clk := newFakeClock()
+	resolver := &fakeNSResolver{hosts: []string{"ns1.gandi.net.", "ns2.gandi.net."}}
+	cache := newNameserverCache()
+	want := []string{"ns1.gandi.net:53", "ns2.gandi.net:53"}
+
+	for _, zone := range []string{"example.com", "Example.com."} {
+		got, err := cache.nameservers(clk, zone, time.Minute, resolver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("nameservers of %s = %v, want %v", zone, got, want)
+		}
+	}
+	if resolver.lookups != 1 {
+		t.Errorf("%d lookups, want the second served from cache", resolver.lookups)
+	}
+
+	clk.Sleep(time.Minute)
+	if _, err := cache.nameservers(clk, "example.com", time.Minute, resolver); err != nil {
+		t.Fatal(err)
+	}
+	cache.refresh("example.com")
+	if _, err := cache.nameservers(clk, "example.com", time.Minute, resolver); err != nil {
+		t.Fatal(err)
+	}
+	if resolver.lookups != 3 {
+		t.Errorf("%d lookups, want the nameservers looked up again once expired and refreshed", resolver.lookups)
+	}
+}
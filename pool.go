@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// workerPoolSizeFromEnv returns the number of workers set by
+// WORKER_POOL_SIZE, zero to write records synchronously.
+func workerPoolSizeFromEnv() (int, error) {
+	v := os.Getenv("WORKER_POOL_SIZE")
+	if v == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid number of workers %q, must be a non-negative integer", v)
+	}
+	return size, nil
+}
+
+// recordOp is a challenge value to present or clean up, waiting in a
+// recordPool.
+type recordOp struct {
+	target  accountTarget
+	key     string
+	present bool
+	opts    *recordOptions
+	done    chan error
+}
+
+// batches reports whether op can be applied in the same write as other:
+// both are made with the same credentials and options.
+func (op *recordOp) batches(other *recordOp) bool {
+	return op.target.credential == other.target.credential && *op.opts == *other.opts
+}
+
+// recordPool writes challenge values with a bounded number of workers.
+// Operations on the same RRset are serialized, and those queued while the
+// RRset is being written are coalesced into a single read and write.
+type recordPool struct {
+	// clock paces the reads of RRsets looking incomplete.
+	clock   clock
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[string][]*recordOp
+	// ready lists the RRsets with pending operations that no worker is
+	// writing.
+	ready []string
+	busy  map[string]bool
+}
+
+// newRecordPool returns a pool of size workers.
+func newRecordPool(size int, clk clock) *recordPool {
+	p := &recordPool{clock: clk, pending: map[string][]*recordOp{}, busy: map[string]bool{}}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// submit queues the presentation or clean up of key in the RRset of target
+// and waits for it to be written.
+func (p *recordPool) submit(target accountTarget, key string, present bool, opts *recordOptions) error {
+	op := &recordOp{target: target, key: key, present: present, opts: opts, done: make(chan error, 1)}
+	rrset := recordName(target.root, target.subdomain)
+	p.mu.Lock()
+	p.pending[rrset] = append(p.pending[rrset], op)
+	if !p.busy[rrset] && len(p.pending[rrset]) == 1 {
+		p.ready = append(p.ready, rrset)
+		p.cond.Signal()
+	}
+	p.mu.Unlock()
+	return <-op.done
+}
+
+func (p *recordPool) work() {
+	for {
+		p.mu.Lock()
+		for len(p.ready) == 0 {
+			p.cond.Wait()
+		}
+		rrset := p.ready[0]
+		p.ready = p.ready[1:]
+		ops := p.pending[rrset]
+		delete(p.pending, rrset)
+		p.busy[rrset] = true
+		p.mu.Unlock()
+
+		for len(ops) > 0 {
+			n := 1
+			for n < len(ops) && ops[n].batches(ops[0]) {
+				n++
+			}
+			err := p.applyOps(ops[:n])
+			for _, op := range ops[:n] {
+				op.done <- err
+			}
+			ops = ops[n:]
+		}
+
+		p.mu.Lock()
+		delete(p.busy, rrset)
+		if len(p.pending[rrset]) > 0 {
+			p.ready = append(p.ready, rrset)
+			p.cond.Signal()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// applyOps applies operations on the same RRset made with the same
+// credentials and options, several ones with a single read and write.
+func (p *recordPool) applyOps(ops []*recordOp) error {
+	t := ops[0].target
+	client := t.client
+	if len(ops) > 1 {
+		klog.V(6).Infof("coalescing %d operations on TXT record %s", len(ops), recordName(t.root, t.subdomain))
+		client = p.batchClient(ops)
+	}
+	changes := make([]valueChange, len(ops))
+	for i, op := range ops {
+		changes[i] = valueChange{key: op.key, present: op.present}
+	}
+	return applyChanges(client, t.root, t.subdomain, changes, ops[0].opts)
+}
+
+// batchClient returns the client applying several operations together: the
+// client of their account bound by the operation budget of none of their
+// challenges, expecting to read the values of the other challenges of all
+// of them.
+func (p *recordPool) batchClient(ops []*recordOp) liveDNSClient {
+	var expected []string
+	for _, op := range ops {
+		for _, key := range op.target.expected {
+			if !containsString(expected, key) {
+				expected = append(expected, key)
+			}
+		}
+	}
+	client := ops[0].target.batchClient
+	if len(expected) == 0 {
+		return client
+	}
+	return &expectingClient{liveDNSClient: client, clock: p.clock, expected: expected}
+}
+
+// pooled reports whether values are written to target through the worker
+// pool. Audited targets are never written to, so they are not queued.
+func (c *gandiDNSProviderSolver) pooled(t accountTarget) bool {
+	return c.pool != nil && !t.audited()
+}
+
+// presentValue presents key in the RRset of target, through the worker pool
+// if there is one.
+func (c *gandiDNSProviderSolver) presentValue(t accountTarget, key string, opts *recordOptions) error {
+	if !c.pooled(t) {
+		return presentValue(t.client, t.root, t.subdomain, key, opts)
+	}
+	return c.pool.submit(t, key, true, opts)
+}
+
+// cleanUpValue cleans key up from the RRset of target, through the worker
+// pool if there is one.
+func (c *gandiDNSProviderSolver) cleanUpValue(t accountTarget, key string, opts *recordOptions) error {
+	if !c.pooled(t) {
+		return cleanUpValue(t.client, t.root, t.subdomain, key, opts)
+	}
+	return c.pool.submit(t, key, false, opts)
+}
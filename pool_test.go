@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+// blockingClient is a fake LiveDNS client whose first listing of RRsets
+// blocks until release is closed.
+type blockingClient struct {
+	*fakelivedns.Client
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingClient) GetDomainRecordsByName(fqdn, name string) ([]livedns.DomainRecord, error) {
+	b.once.Do(func() {
+		close(b.started)
+		<-b.release
+	})
+	return b.Client.GetDomainRecordsByName(fqdn, name)
+}
+
+func TestRecordPoolCoalesces(t *testing.T) {
+	gandiClient := &blockingClient{Client: fakelivedns.New(), started: make(chan struct{}), release: make(chan struct{})}
+	pool := newRecordPool(2, newFakeClock())
+	target := accountTarget{name: "primary", client: gandiClient, batchClient: gandiClient, root: "example.com", subdomain: "_acme-challenge"}
+	opts := &recordOptions{}
+
+	errs := make(chan error, 4)
+	go func() { errs <- pool.submit(target, "first", true, opts) }()
+	<-gandiClient.started
+	// Queued while the first value is being written: applied in one write.
+	var wg sync.WaitGroup
+	for _, key := range []string{"second", "third", "first"} {
+		present := key != "first"
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			errs <- pool.submit(target, key, present, opts)
+		}(key)
+	}
+	waitForPending(t, pool, "_acme-challenge.example.com", 3)
+	close(gandiClient.release)
+	wg.Wait()
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := gandiClient.Values("example.com", "_acme-challenge", "TXT")
+	sort.Strings(got)
+	if want := []string{`"second"`, `"third"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	if n := gandiClient.Calls("GetDomainRecordsByName"); n != 2 {
+		t.Errorf("%d reads, want 2", n)
+	}
+	if n := gandiClient.Calls("CreateDomainRecord") + gandiClient.Calls("UpdateDomainRecordByNameAndType"); n != 2 {
+		t.Errorf("%d writes, want 2", n)
+	}
+}
+
+func TestRecordPoolCoalescedCleanUpRequireTTLMatch(t *testing.T) {
+	gandiClient := &blockingClient{Client: fakelivedns.New(), started: make(chan struct{}), release: make(chan struct{})}
+	// Written by another tool with its own TTL.
+	if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", 3600, []string{"first", "second", "third"}); err != nil {
+		t.Fatal(err)
+	}
+	pool := newRecordPool(2, newFakeClock())
+	target := accountTarget{name: "primary", client: gandiClient, batchClient: gandiClient, root: "example.com", subdomain: "_acme-challenge"}
+	opts := &recordOptions{cleanUpRequireTTLMatch: true}
+
+	errs := make(chan error, 3)
+	go func() { errs <- pool.submit(target, "first", false, opts) }()
+	<-gandiClient.started
+	// Queued while the first value is being cleaned up: applied in one write.
+	for _, key := range []string{"second", "third"} {
+		go func(key string) { errs <- pool.submit(target, key, false, opts) }(key)
+	}
+	waitForPending(t, pool, "_acme-challenge.example.com", 2)
+	close(gandiClient.release)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := gandiClient.Values("example.com", "_acme-challenge", "TXT"), []string{`"first"`, `"second"`, `"third"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	if n := gandiClient.Calls("GetDomainRecordsByName"); n != 2 {
+		t.Errorf("%d reads, want 2", n)
+	}
+	if n := gandiClient.Calls("UpdateDomainRecordByNameAndType") + gandiClient.Calls("DeleteDomainRecord"); n != 0 {
+		t.Errorf("%d writes, want none", n)
+	}
+}
+
+func TestApplyOpsHonorsRecordOptions(t *testing.T) {
+	legacy := strings.Repeat("a", 43)
+	for _, tt := range []struct {
+		name   string
+		opts   recordOptions
+		values []string
+		reads  int
+		writes int
+	}{
+		// The value is already there and the other one absent: nothing to do.
+		{name: "default", values: []string{legacy}, reads: 1},
+		{name: "normalizeLegacyValues", opts: recordOptions{normalizeLegacyValues: true}, values: []string{legacy}, reads: 1, writes: 1},
+		{name: "forceWrite", opts: recordOptions{forceWrite: true}, values: []string{`"` + legacy + `"`}, reads: 1, writes: 1},
+		{name: "skipExistingCheck", opts: recordOptions{skipExistingCheck: true}, reads: 0, writes: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			if tt.values != nil {
+				gandiClient.Set("example.com", livedns.DomainRecord{RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetName: "_acme-challenge", RrsetValues: tt.values})
+			}
+			target := accountTarget{name: "primary", client: gandiClient, batchClient: gandiClient, root: "example.com", subdomain: "_acme-challenge"}
+			ops := []*recordOp{
+				{target: target, key: legacy, present: true, opts: &tt.opts},
+				{target: target, key: "gone", present: false, opts: &tt.opts},
+			}
+			if err := newRecordPool(0, newFakeClock()).applyOps(ops); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n := gandiClient.Calls("GetDomainRecordsByName"); n != tt.reads {
+				t.Errorf("%d reads, want %d", n, tt.reads)
+			}
+			writes := gandiClient.Calls("CreateDomainRecord") + gandiClient.Calls("UpdateDomainRecordByNameAndType")
+			if writes != tt.writes {
+				t.Errorf("%d writes, want %d", writes, tt.writes)
+			}
+			if got, want := gandiClient.Values("example.com", "_acme-challenge", "TXT"), []string{`"` + legacy + `"`}; tt.writes > 0 && !reflect.DeepEqual(got, want) {
+				t.Errorf("values = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyOpsBatchClient(t *testing.T) {
+	// The clients of the challenges are out of budget.
+	exhausted := fakelivedns.New()
+	exhausted.Err = errors.New("operation budget exceeded")
+
+	for _, tt := range []struct {
+		name     string
+		expected [][]string
+		wantErr  string
+		values   []string
+	}{
+		{name: "budget", expected: [][]string{nil, nil}, values: []string{`"other"`}},
+		// The read lacks the value the second challenge expects.
+		{name: "expected values", expected: [][]string{{"second"}, {"missing"}}, wantErr: "without the values", values: []string{`"first"`, `"other"`, `"second"`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			gandiClient.Set("example.com", livedns.DomainRecord{RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetName: "_acme-challenge", RrsetValues: []string{`"first"`, `"other"`, `"second"`}})
+			var ops []*recordOp
+			for i, key := range []string{"first", "second"} {
+				target := accountTarget{name: "primary", client: exhausted, batchClient: gandiClient, expected: tt.expected[i], root: "example.com", subdomain: "_acme-challenge"}
+				ops = append(ops, &recordOp{target: target, key: key, opts: &recordOptions{}})
+			}
+			err := newRecordPool(0, newFakeClock()).applyOps(ops)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, tt.values) {
+				t.Errorf("values = %v, want %v", got, tt.values)
+			}
+		})
+	}
+}
+
+// waitForPending waits until n operations are queued for rrset.
+func waitForPending(t *testing.T, pool *recordPool, rrset string, n int) {
+	t.Helper()
+	for {
+		pool.mu.Lock()
+		pending := len(pool.pending[rrset])
+		pool.mu.Unlock()
+		if pending == n {
+			return
+		}
+	}
+}
+
+func TestRecordPoolDoesNotBatchOtherCredentials(t *testing.T) {
+	primary, other := fakelivedns.New(), fakelivedns.New()
+	ops := []*recordOp{
+		{target: accountTarget{credential: "a", client: primary}, opts: &recordOptions{}},
+		{target: accountTarget{credential: "a", client: primary}, opts: &recordOptions{}},
+		{target: accountTarget{credential: "b", client: other}, opts: &recordOptions{}},
+		{target: accountTarget{credential: "a", client: primary}, opts: &recordOptions{ttl: 600}},
+	}
+	want := []bool{true, false, false}
+	for i, op := range ops[1:] {
+		if got := op.batches(ops[0]); got != want[i] {
+			t.Errorf("op %d batches = %t, want %t", i+1, got, want[i])
+		}
+	}
+}
+
+func TestPresentAndCleanUpThroughPool(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	solver.pool = newRecordPool(4, solver.clock)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- solver.Present(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", fmt.Sprintf("key-%d", i), ""))
+		}(i)
+	}
+	wg.Wait()
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); len(got) != 10 {
+		t.Errorf("values after Present = %v, want 10 values", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- solver.CleanUp(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", fmt.Sprintf("key-%d", i), ""))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("values after CleanUp = %v, want none", got)
+	}
+}
+
+func TestWorkerPoolSizeFromEnv(t *testing.T) {
+	for _, tt := range []struct {
+		env     string
+		size    int
+		wantErr bool
+	}{
+		{env: "", size: 0},
+		{env: "8", size: 8},
+		{env: "-1", wantErr: true},
+		{env: "many", wantErr: true},
+	} {
+		t.Setenv("WORKER_POOL_SIZE", tt.env)
+		size, err := workerPoolSizeFromEnv()
+		if (err != nil) != tt.wantErr || size != tt.size {
+			t.Errorf("WORKER_POOL_SIZE=%q: size = %d, error = %v", tt.env, size, err)
+		}
+	}
+}
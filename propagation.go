@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	defaultPropagationPollInterval = 5 * time.Second
+	defaultPropagationTimeout      = 60 * time.Second
+	// defaultPropagationPollJitter is the fraction of the poll interval by
+	// which polls are spread unless propagationPollJitter is set.
+	defaultPropagationPollJitter = 0.1
+
+	// dnssecFailurePolls is the number of polls in a row some resolver fails
+	// with SERVFAIL after which failOnDNSSECErrors gives up waiting.
+	dnssecFailurePolls = 3
+)
+
+// dnssecHint is appended to propagation errors when resolvers answer
+// SERVFAIL for a record Gandi accepted, which usually means they cannot
+// validate the zone with DNSSEC.
+const dnssecHint = "; resolvers answered SERVFAIL, which usually means DNSSEC validation fails for the zone: " +
+	"check that its DS records at the registry match its DNSKEY records"
+
+var propagationDuration = metrics.NewHistogramVec(
+	&metrics.HistogramOpts{
+		Name:           "gandi_propagation_duration_seconds",
+		Help:           "Time challenge records took to be served by all the nameservers queried while waiting for propagation, by zone.",
+		Buckets:        []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600},
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"zone"},
+)
+
+func init() {
+	legacyregistry.MustRegister(propagationDuration)
+}
+
+// txtResolver looks up the TXT records of a name. It is satisfied by
+// *net.Resolver.
+type txtResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// namedResolver is a resolver queried while waiting for propagation, along
+// with the name it is reported as in errors.
+type namedResolver struct {
+	name     string
+	resolver txtResolver
+}
+
+// newResolver returns a resolver sending its queries to the nameserver at
+// address (host:port) instead of the ones configured for the pod.
+func newResolver(address string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// newLookupResolver returns the resolver used for the webhook's own lookups:
+// the one at resolverAddress, or the system resolver if it is empty.
+func newLookupResolver(resolverAddress string) namedResolver {
+	if resolverAddress == "" {
+		return namedResolver{name: "system", resolver: net.DefaultResolver}
+	}
+	return namedResolver{name: resolverAddress, resolver: newResolver(resolverAddress)}
+}
+
+// newPropagationResolvers returns a resolver for each of the given
+// nameserver addresses (host:port), or the lookup resolver if there are none.
+func newPropagationResolvers(nameservers []string, resolverAddress string) []namedResolver {
+	if len(nameservers) == 0 {
+		return []namedResolver{newLookupResolver(resolverAddress)}
+	}
+	resolvers := make([]namedResolver, 0, len(nameservers))
+	for _, ns := range nameservers {
+		resolvers = append(resolvers, namedResolver{name: ns, resolver: newResolver(ns)})
+	}
+	return resolvers
+}
+
+// isServfailError reports whether err is a resolver answering SERVFAIL,
+// which the Go resolver reports as the server misbehaving.
+func isServfailError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.Err == "server misbehaving" {
+		return true
+	}
+	return strings.Contains(strings.ToUpper(err.Error()), "SERVFAIL")
+}
+
+// pollSchedule is the interval between propagation polls, moved by a random
+// amount of up to jitter times the interval either way, so challenges
+// waiting for propagation together do not query resolvers in bursts.
+type pollSchedule struct {
+	interval time.Duration
+	jitter   float64
+	// random draws a number in [0, n), rand.Int63n if nil.
+	random func(n int64) int64
+}
+
+// next returns the delay before the next poll.
+func (p pollSchedule) next() time.Duration {
+	spread := int64(float64(p.interval) * p.jitter)
+	if spread <= 0 {
+		return p.interval
+	}
+	random := p.random
+	if random == nil {
+		random = rand.Int63n
+	}
+	return p.interval - time.Duration(spread) + time.Duration(random(2*spread+1))
+}
+
+// waitForPropagation polls all resolvers following poll until each of them
+// returns value among the TXT records of fqdn. It gives up once timeout has
+// elapsed, reporting what every resolver returned on its last query, or
+// once some resolver answered SERVFAIL to servfailPolls polls in a row if
+// it is not 0. Errors of resolvers answering SERVFAIL hint at DNSSEC.
+func waitForPropagation(clk clock, resolvers []namedResolver, fqdn, value string, poll pollSchedule, timeout time.Duration, servfailPolls int) error {
+	deadline := clk.Now().Add(timeout)
+	results := make(map[string]string, len(resolvers))
+	servfails := 0
+	for {
+		pending, servfail := false, false
+		for _, r := range resolvers {
+			values, err := r.resolver.LookupTXT(context.Background(), fqdn)
+			switch {
+			case err != nil:
+				results[r.name] = fmt.Sprintf("error: %v", err)
+				pending = true
+				servfail = servfail || isServfailError(err)
+			case !containsString(values, value):
+				results[r.name] = fmt.Sprintf("%q", values)
+				pending = true
+			default:
+				results[r.name] = "ok"
+			}
+		}
+		if !pending {
+			return nil
+		}
+		hint := ""
+		if servfail {
+			servfails++
+			hint = dnssecHint
+		} else {
+			servfails = 0
+		}
+		if servfailPolls > 0 && servfails >= servfailPolls {
+			return fmt.Errorf("TXT record %s failed to resolve %d times in a row: %s%s", fqdn, servfails, formatResults(results), hint)
+		}
+		if !clk.Now().Before(deadline) {
+			return fmt.Errorf("TXT record %s did not propagate within %s: %s%s", fqdn, timeout, formatResults(results), hint)
+		}
+		<-clk.After(poll.next())
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// formatResults renders resolver results sorted by resolver name.
+func formatResults(results map[string]string) string {
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+" returned "+results[name])
+	}
+	return strings.Join(parts, ", ")
+}
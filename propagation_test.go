@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubResolver struct {
+	values []string
+	err    error
+	calls  int
+}
+
+func (r *stubResolver) LookupTXT(_ context.Context, _ string) ([]string, error) {
+	r.calls++
+	return r.values, r.err
+}
+
+func TestWaitForPropagation(t *testing.T) {
+	resolver := &stubResolver{values: []string{"other", "key"}}
+	resolvers := []namedResolver{{name: "stub", resolver: resolver}}
+
+	err := waitForPropagation(newFakeClock(), resolvers, "_acme-challenge.example.com.", "key", pollSchedule{interval: time.Second}, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resolver.calls != 1 {
+		t.Errorf("resolver was queried %d times, want 1", resolver.calls)
+	}
+}
+
+func TestWaitForPropagationTimeout(t *testing.T) {
+	stale := &stubResolver{values: []string{"stale"}}
+	fresh := &stubResolver{values: []string{"key"}}
+	resolvers := []namedResolver{
+		{name: "ns2.example.net:53", resolver: stale},
+		{name: "ns1.example.net:53", resolver: fresh},
+	}
+
+	clk := newFakeClock()
+	err := waitForPropagation(clk, resolvers, "_acme-challenge.example.com.", "key", pollSchedule{interval: 5 * time.Second}, 30*time.Second, 0)
+	if err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	want := `did not propagate within 30s: ns1.example.net:53 returned ok, ns2.example.net:53 returned ["stale"]`
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not contain %q", err, want)
+	}
+	if stale.calls != 7 {
+		t.Errorf("resolver was queried %d times, want 7", stale.calls)
+	}
+	if len(clk.Sleeps()) != 6 {
+		t.Errorf("waited %d times, want 6", len(clk.Sleeps()))
+	}
+}
+
+func TestWaitForPropagationDNSSEC(t *testing.T) {
+	servfail := &stubResolver{err: &net.DNSError{Err: "server misbehaving", Name: "_acme-challenge.example.com.", Server: "1.1.1.1:53"}}
+	resolvers := []namedResolver{{name: "1.1.1.1:53", resolver: servfail}}
+
+	err := waitForPropagation(newFakeClock(), resolvers, "_acme-challenge.example.com.", "key", pollSchedule{interval: 5 * time.Second}, 30*time.Second, 0)
+	if err == nil || !strings.Contains(err.Error(), "did not propagate") || !strings.Contains(err.Error(), "DNSSEC validation fails") {
+		t.Errorf("error = %v, want a timeout hinting at DNSSEC", err)
+	}
+
+	servfail.calls = 0
+	err = waitForPropagation(newFakeClock(), resolvers, "_acme-challenge.example.com.", "key", pollSchedule{interval: 5 * time.Second}, 30*time.Second, dnssecFailurePolls)
+	if err == nil || !strings.Contains(err.Error(), "failed to resolve 3 times in a row") || !strings.Contains(err.Error(), "DNSSEC validation fails") {
+		t.Errorf("error = %v, want giving up early hinting at DNSSEC", err)
+	}
+	if servfail.calls != dnssecFailurePolls {
+		t.Errorf("resolver was queried %d times, want %d", servfail.calls, dnssecFailurePolls)
+	}
+
+	stale := &stubResolver{values: []string{"stale"}}
+	err = waitForPropagation(newFakeClock(), []namedResolver{{name: "stub", resolver: stale}}, "_acme-challenge.example.com.", "key", pollSchedule{interval: 5 * time.Second}, 30*time.Second, dnssecFailurePolls)
+	if err == nil || strings.Contains(err.Error(), "DNSSEC") {
+		t.Errorf("error = %v, want a timeout without DNSSEC hint", err)
+	}
+}
+
+func TestNewPropagationResolvers(t *testing.T) {
+	tests := []struct {
+		nameservers     []string
+		resolverAddress string
+		want            []string
+	}{
+		{want: []string{"system"}},
+		{resolverAddress: "1.1.1.1:53", want: []string{"1.1.1.1:53"}},
+		{nameservers: []string{"ns1.gandi.net:53", "ns2.gandi.net:53"}, resolverAddress: "1.1.1.1:53", want: []string{"ns1.gandi.net:53", "ns2.gandi.net:53"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, r := range newPropagationResolvers(tt.nameservers, tt.resolverAddress) {
+			got = append(got, r.name)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("newPropagationResolvers(%v, %q) = %v, want %v", tt.nameservers, tt.resolverAddress, got, tt.want)
+		}
+	}
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "resolverAddress": "1.1.1.1"`)
+	if _, err := loadConfig(ch.Config); err == nil {
+		t.Error("expected an error for a resolver address without port")
+	}
+}
+
+func TestWaitForPropagationJitter(t *testing.T) {
+	stale := &stubResolver{values: []string{"stale"}}
+	resolvers := []namedResolver{{name: "stub", resolver: stale}}
+
+	clk := newFakeClock()
+	poll := pollSchedule{interval: 5 * time.Second, jitter: 0.2, random: rand.New(rand.NewSource(1)).Int63n}
+	if err := waitForPropagation(clk, resolvers, "_acme-challenge.example.com.", "key", poll, time.Minute, 0); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	sleeps := clk.Sleeps()
+	if len(sleeps) < 2 {
+		t.Fatalf("waited %d times, want several", len(sleeps))
+	}
+	varied := false
+	for _, d := range sleeps {
+		if d < 4*time.Second || d > 6*time.Second {
+			t.Errorf("waited %s, want between 4s and 6s", d)
+		}
+		varied = varied || d != sleeps[0]
+	}
+	if !varied {
+		t.Errorf("all polls waited %s", sleeps[0])
+	}
+
+	for _, tt := range []struct {
+		random func(n int64) int64
+		want   time.Duration
+	}{
+		{random: func(int64) int64 { return 0 }, want: 4 * time.Second},
+		{random: func(n int64) int64 { return n - 1 }, want: 6 * time.Second},
+	} {
+		if got := (pollSchedule{interval: 5 * time.Second, jitter: 0.2, random: tt.random}).next(); got != tt.want {
+			t.Errorf("next() = %s, want %s", got, tt.want)
+		}
+	}
+	if got := (pollSchedule{interval: 5 * time.Second}).next(); got != 5*time.Second {
+		t.Errorf("next() without jitter = %s, want 5s", got)
+	}
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "propagationPollJitter": 1`)
+	if _, err := loadConfig(ch.Config); err == nil {
+		t.Error("expected an error for a jitter of the whole interval")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// rateLimitSlowdownThreshold is the number of remaining requests below
+	// which requests are spread over the time left until the limit resets.
+	rateLimitSlowdownThreshold = 10
+	// rateLimitMaxDelay caps the delay of a single request, in case of a
+	// bogus reset time.
+	rateLimitMaxDelay = time.Minute
+)
+
+var rateLimitRemaining = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Name:           "gandi_api_rate_limit_remaining",
+		Help:           "Number of requests left before the Gandi API rate limit resets, as last reported by Gandi.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"host"},
+)
+
+func init() {
+	legacyregistry.MustRegister(rateLimitRemaining)
+}
+
+// rateLimit is the state of the rate limit of a host, as reported by the
+// headers of its last response.
+type rateLimit struct {
+	remaining int
+	reset     time.Time
+}
+
+// rateLimitTransport is an http.RoundTripper following the rate limit Gandi
+// reports in response headers. Once few requests remain, it spreads them over
+// the time left until the limit resets, rather than running into 429 errors.
+// Hosts not reporting a rate limit are not throttled.
+type rateLimitTransport struct {
+	next   http.RoundTripper
+	clock  clock
+	mu     sync.Mutex
+	limits map[string]rateLimit
+}
+
+func newRateLimitTransport(next http.RoundTripper, clk clock) *rateLimitTransport {
+	return &rateLimitTransport{next: next, clock: clk, limits: map[string]rateLimit{}}
+}
+
+// delay returns how long to wait before sending a request to host.
+func (t *rateLimitTransport) delay(host string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	limit, ok := t.limits[host]
+	if !ok || limit.remaining >= rateLimitSlowdownThreshold {
+		return 0
+	}
+	left := limit.reset.Sub(t.clock.Now())
+	if left <= 0 {
+		return 0
+	}
+	d := left / time.Duration(limit.remaining+1)
+	if d > rateLimitMaxDelay {
+		d = rateLimitMaxDelay
+	}
+	return d
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if d := t.delay(host); d > 0 {
+		klog.V(4).Infof("Gandi API rate limit of %s almost exhausted, delaying request by %s", host, d)
+		t.clock.Sleep(d)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if limit, ok := parseRateLimit(resp.Header, t.clock.Now()); ok {
+		t.mu.Lock()
+		t.limits[host] = limit
+		t.mu.Unlock()
+		rateLimitRemaining.WithLabelValues(host).Set(float64(limit.remaining))
+	}
+	return resp, nil
+}
+
+// parseRateLimit reads the X-RateLimit-Remaining and X-RateLimit-Reset
+// headers. The reset is either a number of seconds from now or, if it is too
+// large for that, a Unix timestamp.
+func parseRateLimit(header http.Header, now time.Time) (rateLimit, bool) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil || remaining < 0 {
+		return rateLimit{}, false
+	}
+	limit := rateLimit{remaining: remaining, reset: now}
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil && reset > 0 {
+		if reset > 1e9 {
+			limit.reset = time.Unix(reset, 0)
+		} else {
+			limit.reset = now.Add(time.Duration(reset) * time.Second)
+		}
+	}
+	return limit, true
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		remaining string
+		reset     string
+		want      rateLimit
+		ok        bool
+	}{
+		{remaining: "", ok: false},
+		{remaining: "many", ok: false},
+		{remaining: "42", reset: "30", want: rateLimit{remaining: 42, reset: now.Add(30 * time.Second)}, ok: true},
+		{remaining: "0", reset: "1700000060", want: rateLimit{remaining: 0, reset: now.Add(time.Minute)}, ok: true},
+		{remaining: "5", want: rateLimit{remaining: 5, reset: now}, ok: true},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set("X-RateLimit-Remaining", tt.remaining)
+		header.Set("X-RateLimit-Reset", tt.reset)
+		got, ok := parseRateLimit(header, now)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRateLimit(%q, %q) = %v, %v, want %v, %v", tt.remaining, tt.reset, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	remaining := 100
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", "60")
+	}))
+	defer server.Close()
+
+	clk := newFakeClock()
+	client := &http.Client{Transport: newRateLimitTransport(http.DefaultTransport, clk)}
+	get := func() {
+		t.Helper()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// Plenty of requests left: no delay.
+	get()
+	get()
+	if len(clk.Sleeps()) != 0 {
+		t.Fatalf("sleeps = %v, want none", clk.Sleeps())
+	}
+
+	// Few requests left: they are spread until the reset.
+	remaining = 2
+	get()
+	get()
+	want := []time.Duration{20 * time.Second}
+	if !reflect.DeepEqual(clk.Sleeps(), want) {
+		t.Errorf("sleeps = %v, want %v", clk.Sleeps(), want)
+	}
+}
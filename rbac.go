@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// rbacCheckOnce runs the RBAC check once, although every solver is
+// initialized.
+var rbacCheckOnce sync.Once
+
+// rbacNamespacesFromEnv returns the namespaces set by RBAC_CHECK_NAMESPACES,
+// a comma separated list.
+func rbacNamespacesFromEnv() []string {
+	var namespaces []string
+	for _, ns := range strings.Split(os.Getenv("RBAC_CHECK_NAMESPACES"), ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// checkSecretAccess asks the API server whether the webhook may get Secrets
+// in each of namespaces, returning the namespaces it may not. Missing RBAC
+// otherwise only shows when a challenge fails to read its API key.
+func checkSecretAccess(client kubernetes.Interface, namespaces []string) ([]string, error) {
+	var denied []string
+	for _, ns := range namespaces {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: ns,
+					Verb:      "get",
+					Resource:  "secrets",
+				},
+			},
+		}
+		result, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(context.Background(), review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to review access to secrets in namespace %s: %v", ns, err)
+		}
+		if !result.Status.Allowed {
+			denied = append(denied, ns)
+		}
+	}
+	return denied, nil
+}
+
+// warnMissingSecretAccess logs a warning for every namespace of
+// RBAC_CHECK_NAMESPACES the webhook may not read Secrets in.
+func warnMissingSecretAccess(client kubernetes.Interface) {
+	namespaces := rbacNamespacesFromEnv()
+	if len(namespaces) == 0 {
+		return
+	}
+	denied, err := checkSecretAccess(client, namespaces)
+	if err != nil {
+		klog.Warningf("RBAC check failed: %v", err)
+		return
+	}
+	for _, ns := range denied {
+		klog.Warningf("The webhook is not allowed to get secrets in namespace %s, challenges of issuers reading their API key there will fail", ns)
+	}
+	if len(denied) == 0 {
+		klog.V(2).Infof("The webhook may get secrets in namespaces %v", namespaces)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckSecretAccess(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = attrs.Namespace == "cert-manager" && attrs.Verb == "get" && attrs.Resource == "secrets"
+		return true, review, nil
+	})
+
+	denied, err := checkSecretAccess(client, []string{"cert-manager", "team-a"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"team-a"}; !reflect.DeepEqual(denied, want) {
+		t.Errorf("denied = %v, want %v", denied, want)
+	}
+}
+
+func TestRBACNamespacesFromEnv(t *testing.T) {
+	t.Setenv("RBAC_CHECK_NAMESPACES", " cert-manager, ,team-a")
+	if got, want := rbacNamespacesFromEnv(), []string{"cert-manager", "team-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("namespaces = %v, want %v", got, want)
+	}
+}
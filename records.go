@@ -0,0 +1,554 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"k8s.io/klog/v2"
+)
+
+// quoteTXTValue returns key as a TXT value the way Gandi returns it, enclosed
+// in double quotes, so values we write compare equal to values we read. The
+// quotes of a key passed already quoted are stripped first, so the value is
+// quoted exactly once.
+func quoteTXTValue(key string) string {
+	return "\"" + strings.Trim(key, "\"") + "\""
+}
+
+// unquoteTXTValue returns v without the double quotes enclosing it, if any.
+// Values longer than 255 bytes are split into several character strings,
+// which Gandi returns quoted one by one, e.g. "abc" "def": their content is
+// reassembled.
+func unquoteTXTValue(v string) string {
+	if parts, ok := splitTXTStrings(v); ok {
+		return strings.Join(parts, "")
+	}
+	if len(v) >= 2 && strings.HasPrefix(v, "\"") && strings.HasSuffix(v, "\"") {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// splitTXTStrings returns the content of the quoted character strings v is
+// made of, separated by spaces. Escaped characters are kept as is. It fails
+// if v is not only made of quoted strings.
+func splitTXTStrings(v string) ([]string, bool) {
+	var parts []string
+	i := 0
+	for {
+		for i < len(v) && v[i] == ' ' {
+			i++
+		}
+		if i == len(v) {
+			return parts, len(parts) > 0
+		}
+		if v[i] != '"' {
+			return nil, false
+		}
+		start := i + 1
+		for i = start; i < len(v) && v[i] != '"'; i++ {
+			if v[i] == '\\' {
+				i++
+			}
+		}
+		if i >= len(v) {
+			return nil, false
+		}
+		parts = append(parts, v[start:i])
+		i++
+	}
+}
+
+// normalizeTXTValue returns the content of the TXT value v without the
+// insignificant characters some tools store along with it: the whitespace
+// around it and trailing dots.
+func normalizeTXTValue(v string) string {
+	v = unquoteTXTValue(strings.TrimSpace(v))
+	return strings.TrimRight(strings.TrimSpace(v), ". \t")
+}
+
+// isTXTValue reports whether the RRset value v holds the challenge key.
+// Gandi returns TXT values enclosed in double quotes, but values written
+// without them by other tools may be returned as is, so the comparison
+// ignores the quotes of both, as well as what normalizeTXTValue strips.
+// Challenge keys are base64url digests, which never contain whitespace nor
+// dots: only copies of the same key compare equal.
+func isTXTValue(v, key string) bool {
+	return normalizeTXTValue(v) == normalizeTXTValue(key)
+}
+
+// hasTXTValue reports whether the RRset values returned by Gandi contain the
+// given challenge key.
+func hasTXTValue(values []string, key string) bool {
+	for _, v := range values {
+		if isTXTValue(v, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeTXTValue returns the RRset values without the given challenge key,
+// keeping the others as they were returned.
+func removeTXTValue(values []string, key string) []string {
+	var kept []string
+	for _, v := range values {
+		if !isTXTValue(v, key) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+const (
+	// writeStrategyUpdate replaces the values of an RRset in place.
+	writeStrategyUpdate = "update"
+	// writeStrategyRecreate deletes an RRset and creates it again with the
+	// new values, working around RRsets Gandi fails to update.
+	writeStrategyRecreate = "recreate"
+)
+
+// recordOptions controls how challenge records are written.
+type recordOptions struct {
+	writeStrategy string
+	// coTenant restricts writes to what is safe when other solvers write
+	// to the same RRsets: values are only merged and removed one by one.
+	coTenant bool
+	// preserveExisting refuses any write that would drop a value of the
+	// RRset that is not a challenge key, such as a user's own TXT record.
+	preserveExisting bool
+	// ttl is the TTL of the records written, GandiMinTtl if zero.
+	// preserveTTL keeps the TTL of existing RRsets instead.
+	ttl         int
+	preserveTTL bool
+	// maxTTL caps the TTL kept by preserveTTL, if not zero.
+	maxTTL int
+	// skipExistingCheck creates the RRset without reading it first.
+	skipExistingCheck bool
+	// existingCheckTimeout caps the read before presenting a value, if not
+	// zero. On timeout, presentValue fails unless existingCheckFallback is
+	// set, in which case it goes on as with skipExistingCheck.
+	existingCheckTimeout  time.Duration
+	existingCheckFallback bool
+	// forceWrite writes the RRset when presenting a value it already holds,
+	// instead of leaving it untouched.
+	forceWrite bool
+	// normalizeLegacyValues quotes the challenge keys stored unquoted by
+	// older versions of the webhook when presenting a value.
+	normalizeLegacyValues bool
+	// cleanUpRequireTTLMatch leaves RRsets whose TTL differs from the
+	// configured one alone when cleaning up, as another tool wrote them.
+	cleanUpRequireTTLMatch bool
+	// recordTypePolicy is the unexpectedRecordTypePolicy applied when a
+	// challenge name holds records of other types but no TXT record.
+	recordTypePolicy string
+}
+
+// recordTTL returns the TTL of a new RRset.
+func (opts *recordOptions) recordTTL() int {
+	if opts.ttl == 0 {
+		return GandiMinTtl
+	}
+	return opts.ttl
+}
+
+// updateTTL returns the TTL to write to an existing RRset, warning if it was
+// changed from the configured one, e.g. by a user or another tool.
+func (opts *recordOptions) updateTTL(root, subdomain string, record livedns.DomainRecord) int {
+	ttl := opts.recordTTL()
+	if record.RrsetTTL == ttl {
+		return ttl
+	}
+	if opts.preserveTTL && opts.maxTTL != 0 && record.RrsetTTL > opts.maxTTL {
+		klog.Warningf("TXT record %s in zone %s has a TTL of %d above maxTTL, lowering it to %d", subdomain, root, record.RrsetTTL, opts.maxTTL)
+		return opts.maxTTL
+	}
+	if opts.preserveTTL {
+		klog.Warningf("TXT record %s in zone %s has a TTL of %d instead of %d, keeping it", subdomain, root, record.RrsetTTL, ttl)
+		return record.RrsetTTL
+	}
+	klog.Warningf("TXT record %s in zone %s has a TTL of %d instead of %d, resetting it", subdomain, root, record.RrsetTTL, ttl)
+	return ttl
+}
+
+// challengeKeyPattern matches an ACME DNS-01 challenge value, the unpadded
+// base64url encoding of a SHA-256 digest (RFC 8555 section 8.4).
+var challengeKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// isChallengeKey reports whether key has the format of a challenge value.
+func isChallengeKey(key string) bool {
+	return challengeKeyPattern.MatchString(key)
+}
+
+// recordErrorf returns an error about an action on the TXT RRset subdomain of
+// zone root, so failures can be traced to the record from the Challenge.
+func recordErrorf(root, subdomain, action string, err error) error {
+	return fmt.Errorf("unable to %s TXT record %s in zone %s: %v", action, subdomain, root, err)
+}
+
+// droppedUserValues returns the values of current that are not challenge
+// keys and are missing from values.
+func droppedUserValues(current, values []string) []string {
+	var dropped []string
+	for _, v := range current {
+		if !isChallengeKey(unquoteTXTValue(v)) && !containsString(values, v) {
+			dropped = append(dropped, v)
+		}
+	}
+	return dropped
+}
+
+// checkPreserved fails if replacing current by values would drop a user
+// value while opts asks to preserve them.
+func checkPreserved(current, values []string, opts *recordOptions) error {
+	if !opts.preserveExisting {
+		return nil
+	}
+	if dropped := droppedUserValues(current, values); len(dropped) > 0 {
+		return fmt.Errorf("refusing to drop %d existing values that are not challenge keys", len(dropped))
+	}
+	return nil
+}
+
+// sortedValues returns a sorted copy of values. RRsets are always written
+// with sorted values, so writing the same values in another order, e.g.
+// merged from concurrent challenges, does not change them.
+func sortedValues(values []string) []string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// replaceValues sets the values of the existing TXT RRset subdomain of zone
+// root, currently record, following the write strategy.
+func replaceValues(gandiClient liveDNSClient, root, subdomain string, record livedns.DomainRecord, values []string, opts *recordOptions) error {
+	values = sortedValues(values)
+	if err := checkPreserved(record.RrsetValues, values, opts); err != nil {
+		return recordErrorf(root, subdomain, "update", err)
+	}
+	ttl := opts.updateTTL(root, subdomain, record)
+	if opts.writeStrategy == writeStrategyRecreate {
+		err := gandiClient.DeleteDomainRecord(root, subdomain, "TXT")
+		if err != nil && !isNotFoundError(err) {
+			return recordErrorf(root, subdomain, "delete", err)
+		}
+		_, err = gandiClient.CreateDomainRecord(root, subdomain, "TXT", ttl, values)
+		if err != nil {
+			return recordErrorf(root, subdomain, "create", err)
+		}
+		return nil
+	}
+
+	_, err := gandiClient.UpdateDomainRecordByNameAndType(root, subdomain, "TXT", ttl, values)
+	if err != nil {
+		return recordErrorf(root, subdomain, "update", err)
+	}
+	return nil
+}
+
+// challengeLabel is the first label of the names challenge records are
+// written to.
+const challengeLabel = "_acme-challenge"
+
+// getTXTRecord returns the TXT RRset subdomain of zone root. Gandi should
+// never hold more than one RRset per name and type, but duplicates left by an
+// inconsistent state would make the solver act on an arbitrary one of them.
+// For challenge names, they are consolidated into a single RRset holding the
+// values of all of them. Other names are never touched. A challenge name
+// holding records of other types only, such as a CNAME, is handled following
+// opts.recordTypePolicy.
+func getTXTRecord(gandiClient liveDNSClient, root, subdomain string, opts *recordOptions) (livedns.DomainRecord, error) {
+	if labels := splitLabels(subdomain); len(labels) == 0 || labels[0] != challengeLabel {
+		return gandiClient.GetDomainRecordByNameAndType(root, subdomain, "TXT")
+	}
+
+	records, err := gandiClient.GetDomainRecordsByName(root, subdomain)
+	if err != nil {
+		return livedns.DomainRecord{}, err
+	}
+	var txt []livedns.DomainRecord
+	for _, r := range records {
+		if r.RrsetType == "TXT" {
+			txt = append(txt, r)
+		}
+	}
+	switch len(txt) {
+	case 0:
+		if len(records) > 0 {
+			return livedns.DomainRecord{}, unexpectedRecordType(opts.recordTypePolicy, root, subdomain, "TXT", records[0].RrsetType)
+		}
+		return livedns.DomainRecord{}, fmt.Errorf("404: Can't find the DNS record %s/TXT in LiveDNS", subdomain)
+	case 1:
+		return txt[0], nil
+	}
+
+	merged := txt[0]
+	merged.RrsetValues = append([]string(nil), merged.RrsetValues...)
+	for _, r := range txt[1:] {
+		for _, v := range r.RrsetValues {
+			if !containsString(merged.RrsetValues, v) {
+				merged.RrsetValues = append(merged.RrsetValues, v)
+			}
+		}
+	}
+	merged.RrsetValues = sortedValues(merged.RrsetValues)
+	klog.Warningf("Found %d TXT RRsets for %s in zone %s, consolidating them into one", len(txt), subdomain, root)
+	_, err = gandiClient.UpdateDomainRecordByNameAndType(root, subdomain, "TXT", merged.RrsetTTL, merged.RrsetValues)
+	if err != nil {
+		return livedns.DomainRecord{}, recordErrorf(root, subdomain, "consolidate", err)
+	}
+	return merged, nil
+}
+
+// errExistingCheckTimeout is returned by readExisting when the read takes
+// longer than allowed.
+var errExistingCheckTimeout = errors.New("existence check timed out")
+
+// readExisting reads the TXT RRset subdomain of zone root before presenting a
+// value, giving up after opts.existingCheckTimeout if set. go-gandi cannot
+// cancel a call: a read given up on completes in the background and its
+// result is discarded.
+func readExisting(gandiClient liveDNSClient, root, subdomain string, opts *recordOptions) (livedns.DomainRecord, error) {
+	if opts.existingCheckTimeout == 0 {
+		return getTXTRecord(gandiClient, root, subdomain, opts)
+	}
+	type result struct {
+		record livedns.DomainRecord
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		record, err := getTXTRecord(gandiClient, root, subdomain, opts)
+		done <- result{record, err}
+	}()
+	timer := time.NewTimer(opts.existingCheckTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.record, r.err
+	case <-timer.C:
+		return livedns.DomainRecord{}, errExistingCheckTimeout
+	}
+}
+
+// valueChange is a challenge value to add to or remove from a TXT RRset.
+type valueChange struct {
+	key     string
+	present bool
+}
+
+// presentValue adds key to the TXT RRset subdomain of zone root, creating the
+// RRset if needed and keeping the values of concurrent challenges.
+func presentValue(gandiClient liveDNSClient, root, subdomain, key string, opts *recordOptions) error {
+	return applyChanges(gandiClient, root, subdomain, []valueChange{{key: key, present: true}}, opts)
+}
+
+// cleanUpValue removes key from the TXT RRset subdomain of zone root, deleting
+// the RRset once no other value is left.
+func cleanUpValue(gandiClient liveDNSClient, root, subdomain, key string, opts *recordOptions) error {
+	return applyChanges(gandiClient, root, subdomain, []valueChange{{key: key}}, opts)
+}
+
+// applyChanges applies changes to the TXT RRset subdomain of zone root with a
+// single read and write, creating the RRset if needed and deleting it once no
+// value is left. It applies a single change as well as those coalesced by the
+// worker pool, so both honor the same options.
+func applyChanges(gandiClient liveDNSClient, root, subdomain string, changes []valueChange, opts *recordOptions) error {
+	name := recordName(root, subdomain)
+	var applicable []valueChange
+	presenting := false
+	for _, change := range changes {
+		if !change.present && opts.coTenant && !isChallengeKey(change.key) {
+			klog.Warningf("Not removing value %s from TXT record for %s: it is not a challenge key", keyHash(change.key), name)
+			continue
+		}
+		applicable = append(applicable, change)
+		presenting = presenting || change.present
+	}
+	if len(applicable) == 0 {
+		return nil
+	}
+
+	record, exists, err := readRecord(gandiClient, root, subdomain, presenting, opts)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		values, _ := mergeValues(name, livedns.DomainRecord{}, applicable, opts)
+		if len(values) == 0 {
+			klog.V(6).Infof("There is no entry of TXT matching %s, do nothing", name)
+			return nil
+		}
+		klog.V(6).Infof("There is no entry of TXT matching, creating a new one for %s with values %v", name, redactAll(values))
+		_, err := gandiClient.CreateDomainRecord(root, subdomain, "TXT", opts.recordTTL(), sortedValues(values))
+		if err == nil {
+			return nil
+		}
+		if !isAlreadyExistsError(err) {
+			return recordErrorf(root, subdomain, "create", err)
+		}
+		// Gandi LiveDNS does not version RRsets, so there is no way to make the
+		// write conditional. A concurrent challenge for the same name (e.g. the
+		// apex and the wildcard of a domain) created the RRset between our read
+		// and our write, or it was not read: re-read it and merge our values
+		// into it instead.
+		klog.V(6).Infof("TXT record for %s already exists, merging values %v", name, redactAll(values))
+		record, err = getTXTRecord(gandiClient, root, subdomain, opts)
+		if err != nil {
+			return recordErrorf(root, subdomain, "get", err)
+		}
+	}
+
+	values, write := mergeValues(name, record, applicable, opts)
+	if !write {
+		return nil
+	}
+	// Other challenges for the same name may still be in flight, only drop the
+	// whole RRset once our values were the last ones.
+	if len(values) == 0 {
+		if err := checkPreserved(record.RrsetValues, nil, opts); err != nil {
+			return recordErrorf(root, subdomain, "delete", err)
+		}
+		if err := gandiClient.DeleteDomainRecord(root, subdomain, "TXT"); err != nil {
+			return recordErrorf(root, subdomain, "delete", err)
+		}
+		return nil
+	}
+	klog.V(6).Infof("Current record exists for %s value is %v, new value will be %v", name, redactAll(record.RrsetValues), redactAll(values))
+	return replaceValues(gandiClient, root, subdomain, record, values, opts)
+}
+
+// readRecord reads the TXT RRset subdomain of zone root before applying
+// changes, and reports whether it exists.
+//
+// When presenting with skipExistingCheck, the RRset is not read: it is
+// created right away, and only read if it turns out to exist already. A read
+// timing out after existingCheckTimeout is handled the same way with
+// existingCheckFallback, and fails otherwise. When cleaning up, only a
+// missing RRset is reported as not existing: other read errors fail.
+func readRecord(gandiClient liveDNSClient, root, subdomain string, presenting bool, opts *recordOptions) (livedns.DomainRecord, bool, error) {
+	if presenting && opts.skipExistingCheck {
+		return livedns.DomainRecord{}, false, nil
+	}
+	var record livedns.DomainRecord
+	var err error
+	if presenting {
+		record, err = readExisting(gandiClient, root, subdomain, opts)
+	} else {
+		record, err = getTXTRecord(gandiClient, root, subdomain, opts)
+	}
+	var suspicious *suspiciousReadError
+	var mistyped *unexpectedRecordTypeError
+	switch {
+	case err == nil:
+		return record, true, nil
+	case errors.As(err, &suspicious) || errors.As(err, &mistyped):
+		return livedns.DomainRecord{}, false, recordErrorf(root, subdomain, "get", err)
+	case err == errExistingCheckTimeout && !opts.existingCheckFallback:
+		return livedns.DomainRecord{}, false, recordErrorf(root, subdomain, "get", fmt.Errorf("%v after %s", err, opts.existingCheckTimeout))
+	case err == errExistingCheckTimeout:
+		klog.Warningf("Reading TXT record %s timed out after %s, creating it without checking", recordName(root, subdomain), opts.existingCheckTimeout)
+	case !presenting && !isNotFoundError(err):
+		// Cleaning up after a failed read would leave the value behind.
+		return livedns.DomainRecord{}, false, recordErrorf(root, subdomain, "get", err)
+	}
+	return livedns.DomainRecord{}, false, nil
+}
+
+// mergeValues returns the values of the TXT RRset name, currently record, once
+// changes are applied, and whether they must be written: because they
+// changed, legacy values were quoted with normalizeLegacyValues, or a value
+// already there is presented with forceWrite. Values are not removed from a
+// record with a foreign TTL with cleanUpRequireTTLMatch.
+func mergeValues(name string, record livedns.DomainRecord, changes []valueChange, opts *recordOptions) ([]string, bool) {
+	values := append([]string(nil), record.RrsetValues...)
+	write := false
+	if opts.normalizeLegacyValues && presents(changes) {
+		values, write = quoteLegacyValues(values)
+		if write {
+			klog.V(2).Infof("quoting the challenge values stored unquoted by an older webhook version in TXT record %s", name)
+		}
+	}
+	for _, change := range changes {
+		switch {
+		case change.present && !hasTXTValue(values, change.key):
+			values = append(values, quoteTXTValue(change.key))
+			write = true
+		case change.present && opts.forceWrite:
+			klog.V(6).Infof("TXT record for %s already holds value \"%s\", writing it anyway", name, redact(change.key))
+			write = true
+		case !change.present:
+			kept := removeTXTValue(values, change.key)
+			if len(kept) == len(values) {
+				klog.V(6).Infof("TXT record for %s does not contain value \"%s\", do nothing", name, redact(change.key))
+				continue
+			}
+			if opts.cleanUpRequireTTLMatch && record.RrsetTTL != opts.recordTTL() {
+				klog.Warningf("Not removing value %s from TXT record for %s: its TTL %d differs from %d", keyHash(change.key), name, record.RrsetTTL, opts.recordTTL())
+				continue
+			}
+			values, write = kept, true
+		}
+	}
+	return values, write
+}
+
+// presents reports whether any of changes presents a value.
+func presents(changes []valueChange) bool {
+	for _, change := range changes {
+		if change.present {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteLegacyValues returns values with the challenge keys older versions of
+// the webhook stored without quotes quoted, and whether there were any.
+// Other values are left as they are.
+func quoteLegacyValues(values []string) ([]string, bool) {
+	quoted := make([]string, len(values))
+	legacy := false
+	for i, v := range values {
+		quoted[i] = v
+		if isChallengeKey(v) {
+			quoted[i] = quoteTXTValue(v)
+			legacy = true
+		}
+	}
+	return quoted, legacy
+}
+
+const (
+	// confirmAttempts and confirmInterval bound how long confirmValue waits
+	// for a written value to be readable back from Gandi.
+	confirmAttempts = 5
+	confirmInterval = 2 * time.Second
+)
+
+// confirmValue reads the TXT RRset subdomain of zone root back from Gandi
+// until it contains key, so a freshly created or updated RRset is visible
+// before cert-manager starts its self check.
+func confirmValue(gandiClient liveDNSClient, clk clock, root, subdomain, key string) error {
+	var err error
+	for attempt := 1; attempt <= confirmAttempts; attempt++ {
+		var record livedns.DomainRecord
+		record, err = gandiClient.GetDomainRecordByNameAndType(root, subdomain, "TXT")
+		if err == nil && hasTXTValue(record.RrsetValues, key) {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("value \"%s\" not found", redact(key))
+		}
+		klog.V(6).Infof("TXT record for %s not confirmed after attempt %d: %v", subdomain+root, attempt, err)
+		if attempt < confirmAttempts {
+			clk.Sleep(confirmInterval)
+		}
+	}
+	return recordErrorf(root, subdomain, "confirm", err)
+}
@@ -0,0 +1,714 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+func TestPresentValueWriteStrategy(t *testing.T) {
+	tests := []struct {
+		strategy string
+		calls    map[string]int
+	}{
+		{
+			strategy: writeStrategyUpdate,
+			calls:    map[string]int{"GetDomainRecordsByName": 1, "UpdateDomainRecordByNameAndType": 1},
+		},
+		{
+			strategy: writeStrategyRecreate,
+			calls:    map[string]int{"GetDomainRecordsByName": 1, "DeleteDomainRecord": 1, "CreateDomainRecord": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"apex"}); err != nil {
+				t.Fatal(err)
+			}
+			gandiClient.ResetCalls()
+
+			opts := &recordOptions{writeStrategy: tt.strategy}
+			if err := presentValue(gandiClient, "example.com", "_acme-challenge", "wildcard", opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(gandiClient.CallCounts(), tt.calls) {
+				t.Errorf("calls = %v, want %v", gandiClient.CallCounts(), tt.calls)
+			}
+			want := []string{`"apex"`, `"wildcard"`}
+			if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+				t.Errorf("values = %v, want %v", got, want)
+			}
+
+			if err := cleanUpValue(gandiClient, "example.com", "_acme-challenge", "apex", opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want = []string{`"wildcard"`}
+			if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+				t.Errorf("values after cleanup = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigWriteStrategy(t *testing.T) {
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "writeStrategy": "replace"`)
+	if _, err := loadConfig(ch.Config); err == nil {
+		t.Error("expected an error for an unknown write strategy")
+	}
+	ch = newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.recordOptions().writeStrategy != writeStrategyUpdate {
+		t.Errorf("default write strategy = %q", cfg.recordOptions().writeStrategy)
+	}
+}
+
+func TestPresentConfirmWrites(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   bool
+		staleReads int
+		wantErr    bool
+	}{
+		{name: "created", staleReads: 2},
+		{name: "updated", existing: true, staleReads: 2},
+		{name: "never visible", staleReads: confirmAttempts + 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			if tt.existing {
+				if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"apex"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			gandiClient.StaleReads = tt.staleReads
+			solver := newTestSolver(gandiClient)
+			clk := solver.clock.(*fakeClock)
+
+			ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "wildcard", `, "confirmWrites": true`)
+			err := solver.Present(ch)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "unable to confirm TXT record _acme-challenge in zone example.com") {
+					t.Errorf("error = %v, want a confirm error", err)
+				}
+				if got := len(clk.Sleeps()); got != confirmAttempts-1 {
+					t.Errorf("slept %d times, want %d", got, confirmAttempts-1)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := len(clk.Sleeps()); got != tt.staleReads {
+				t.Errorf("slept %d times, want %d", got, tt.staleReads)
+			}
+		})
+	}
+}
+
+func TestPresentAgainIsNoop(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+	}{
+		{name: "new RRset"},
+		{name: "existing RRset", existing: []string{`"apex"`}},
+		// Values written without quotes by other tools.
+		{name: "unquoted values", existing: []string{"apex", "wildcard"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			if tt.existing != nil {
+				gandiClient.Set("example.com", livedns.DomainRecord{
+					RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetName: "_acme-challenge", RrsetValues: tt.existing,
+				})
+			}
+			opts := &recordOptions{writeStrategy: writeStrategyUpdate}
+
+			if err := presentValue(gandiClient, "example.com", "_acme-challenge", "wildcard", opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, v := range gandiClient.Written() {
+				if !strings.HasPrefix(v, `"`) || !strings.HasSuffix(v, `"`) {
+					t.Errorf("wrote unquoted value %s", v)
+				}
+			}
+			if !hasTXTValue(gandiClient.Values("example.com", "_acme-challenge", "TXT"), "wildcard") {
+				t.Fatalf("values = %v, want wildcard", gandiClient.Values("example.com", "_acme-challenge", "TXT"))
+			}
+
+			gandiClient.ResetCalls()
+			if err := presentValue(gandiClient, "example.com", "_acme-challenge", "wildcard", opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := map[string]int{"GetDomainRecordsByName": 1}
+			if !reflect.DeepEqual(gandiClient.CallCounts(), want) {
+				t.Errorf("second present made calls %v, want %v", gandiClient.CallCounts(), want)
+			}
+		})
+	}
+}
+
+func TestPresentForceWrite(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	gandiClient.Set("example.com", livedns.DomainRecord{
+		RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetName: "_acme-challenge", RrsetValues: []string{`"wildcard"`, `"apex"`},
+	})
+	opts := &recordOptions{writeStrategy: writeStrategyUpdate, forceWrite: true}
+
+	if err := presentValue(gandiClient, "example.com", "_acme-challenge", "apex", opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]int{"GetDomainRecordsByName": 1, "UpdateDomainRecordByNameAndType": 1}
+	if !reflect.DeepEqual(gandiClient.CallCounts(), want) {
+		t.Errorf("calls = %v, want %v", gandiClient.CallCounts(), want)
+	}
+	if got, want := gandiClient.Values("example.com", "_acme-challenge", "TXT"), []string{`"apex"`, `"wildcard"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+}
+
+func TestPresentQuotedKey(t *testing.T) {
+	gandiClient := fakelivedns.New()
+
+	if err := presentValue(gandiClient, "example.com", "_acme-challenge", `"key"`, &recordOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := presentValue(gandiClient, "example.com", "_acme-challenge", `"other"`, &recordOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := gandiClient.Values("example.com", "_acme-challenge", "TXT"), []string{`"key"`, `"other"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+}
+
+func TestPresentSkipExistingCheck(t *testing.T) {
+	opts := &recordOptions{writeStrategy: writeStrategyUpdate, skipExistingCheck: true}
+
+	gandiClient := fakelivedns.New()
+	if err := presentValue(gandiClient, "example.com", "_acme-challenge", "apex", opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]int{"CreateDomainRecord": 1}; !reflect.DeepEqual(gandiClient.CallCounts(), want) {
+		t.Errorf("new RRset: calls = %v, want %v", gandiClient.CallCounts(), want)
+	}
+
+	gandiClient.ResetCalls()
+	if err := presentValue(gandiClient, "example.com", "_acme-challenge", "wildcard", opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]int{"CreateDomainRecord": 1, "GetDomainRecordsByName": 1, "UpdateDomainRecordByNameAndType": 1}
+	if !reflect.DeepEqual(gandiClient.CallCounts(), want) {
+		t.Errorf("existing RRset: calls = %v, want %v", gandiClient.CallCounts(), want)
+	}
+	if got, want := gandiClient.Values("example.com", "_acme-challenge", "TXT"), []string{`"apex"`, `"wildcard"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+}
+
+// slowReadClient is a fakelivedns.Client whose reads by name block until
+// release is closed.
+type slowReadClient struct {
+	*fakelivedns.Client
+	release chan struct{}
+}
+
+func (c *slowReadClient) GetDomainRecordsByName(fqdn, name string) ([]livedns.DomainRecord, error) {
+	<-c.release
+	return c.Client.GetDomainRecordsByName(fqdn, name)
+}
+
+func TestPresentExistingCheckTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		fallback bool
+		wantErr  string
+		want     []string
+	}{
+		{name: "fail fast", wantErr: "unable to get TXT record _acme-challenge in zone example.com: existence check timed out after 10ms"},
+		{name: "fallback", fallback: true, want: []string{`"apex"`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := &slowReadClient{Client: fakelivedns.New(), release: make(chan struct{})}
+			defer close(gandiClient.release)
+			opts := &recordOptions{writeStrategy: writeStrategyUpdate, existingCheckTimeout: 10 * time.Millisecond, existingCheckFallback: tt.fallback}
+
+			err := presentValue(gandiClient, "example.com", "_acme-challenge", "apex", opts)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnquoteTXTValue(t *testing.T) {
+	long := strings.Repeat("a", 255)
+	for _, tt := range []struct {
+		value string
+		want  string
+	}{
+		{value: `"key"`, want: "key"},
+		{value: "key", want: "key"},
+		{value: `""`, want: ""},
+		{value: `"` + long + `" "bc"`, want: long + "bc"},
+		{value: `"ab"  "cd" "ef"`, want: "abcdef"},
+		{value: `"a\"b" "c"`, want: `a\"bc`},
+		{value: `"ab"cd"`, want: `ab"cd`},
+		{value: `"ab" cd`, want: `"ab" cd`},
+	} {
+		if got := unquoteTXTValue(tt.value); got != tt.want {
+			t.Errorf("unquoteTXTValue(%s) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestIsTXTValue(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  bool
+	}{
+		{value: `"key"`, want: true},
+		{value: `key`, want: true},
+		{value: `"key."`, want: true},
+		{value: `key.`, want: true},
+		{value: `"key" `, want: true},
+		{value: ` "key"`, want: true},
+		{value: `" key "`, want: true},
+		{value: "\"key.\t\"", want: true},
+		{value: `"ke" "y."`, want: true},
+		{value: `"k.ey"`, want: false},
+		{value: `"key2"`, want: false},
+		{value: `".key"`, want: false},
+	} {
+		if got := isTXTValue(tt.value, "key"); got != tt.want {
+			t.Errorf("isTXTValue(%q, key) = %t, want %t", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPresentNormalizedValueIsNoop(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	gandiClient.Set("example.com", livedns.DomainRecord{
+		RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetName: "_acme-challenge",
+		RrsetValues: []string{`"key." `},
+	})
+
+	if err := presentValue(gandiClient, "example.com", "_acme-challenge", "key", &recordOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]int{"GetDomainRecordsByName": 1}; !reflect.DeepEqual(gandiClient.CallCounts(), want) {
+		t.Errorf("calls = %v, want %v", gandiClient.CallCounts(), want)
+	}
+	if err := cleanUpValue(gandiClient, "example.com", "_acme-challenge", "key", &recordOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); got != nil {
+		t.Errorf("values = %v, want the RRset deleted", got)
+	}
+}
+
+func TestPresentLegacyUnquotedValues(t *testing.T) {
+	apex, wildcard := strings.Repeat("a", 43), strings.Repeat("w", 43)
+	for _, tt := range []struct {
+		name      string
+		normalize bool
+		want      []string
+		writes    int
+	}{
+		{name: "compared", want: []string{`"` + wildcard + `"`, apex, "v=spf1 -all"}},
+		{name: "normalized", normalize: true, want: []string{`"` + apex + `"`, `"v=spf1 -all"`, `"` + wildcard + `"`}, writes: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			gandiClient.Set("example.com", livedns.DomainRecord{
+				RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetName: "_acme-challenge",
+				RrsetValues: []string{apex, `"` + wildcard + `"`, "v=spf1 -all"},
+			})
+			opts := &recordOptions{normalizeLegacyValues: tt.normalize}
+
+			// Presenting the value stored unquoted by an older version of the
+			// webhook again.
+			if err := presentValue(gandiClient, "example.com", "_acme-challenge", apex, opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := gandiClient.Values("example.com", "_acme-challenge", "TXT")
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+			if n := gandiClient.Calls("UpdateDomainRecordByNameAndType"); n != tt.writes {
+				t.Errorf("%d writes, want %d", n, tt.writes)
+			}
+		})
+	}
+}
+
+func TestPresentValueSplitIntoStrings(t *testing.T) {
+	key := strings.Repeat("k", 300)
+	gandiClient := fakelivedns.New()
+	gandiClient.Set("example.com", livedns.DomainRecord{
+		RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetName: "_acme-challenge",
+		RrsetValues: []string{`"` + key[:255] + `" "` + key[255:] + `"`},
+	})
+
+	// The value Gandi returns as two strings is the key: nothing is written.
+	if err := presentValue(gandiClient, "example.com", "_acme-challenge", key, &recordOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := gandiClient.Calls("CreateDomainRecord") + gandiClient.Calls("UpdateDomainRecordByNameAndType"); n != 0 {
+		t.Errorf("%d writes, want none", n)
+	}
+}
+
+func TestCleanUpMiddleValue(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		stored []string
+		key    string
+		want   []string
+	}{
+		{name: "quoted", stored: []string{`"apex"`, `"wildcard"`, `"www"`}, key: "wildcard", want: []string{`"apex"`, `"www"`}},
+		{name: "unquoted", stored: []string{"apex", "wildcard", "www"}, key: "wildcard", want: []string{`"apex"`, `"www"`}},
+		{name: "quoted key", stored: []string{`"apex"`, "wildcard", `"www"`}, key: `"wildcard"`, want: []string{`"apex"`, `"www"`}},
+		{name: "multiple strings", stored: []string{`"apex"`, `"wild" "card"`, `"www"`}, key: "wildcard", want: []string{`"apex"`, `"www"`}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			gandiClient.Set("example.com", livedns.DomainRecord{
+				RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetName: "_acme-challenge", RrsetValues: tt.stored,
+			})
+			opts := &recordOptions{writeStrategy: writeStrategyUpdate}
+
+			if err := cleanUpValue(gandiClient, "example.com", "_acme-challenge", tt.key, opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+
+			// The set no longer changes: nothing is written.
+			gandiClient.ResetCalls()
+			if err := cleanUpValue(gandiClient, "example.com", "_acme-challenge", tt.key, opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := gandiClient.Calls("UpdateDomainRecordByNameAndType") + gandiClient.Calls("DeleteDomainRecord"); got != 0 {
+				t.Errorf("second clean up made %d writes, want none", got)
+			}
+		})
+	}
+}
+
+func TestCoTenantKeepsForeignValues(t *testing.T) {
+	const key = "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	gandiClient := fakelivedns.New()
+	if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"foreign"}); err != nil {
+		t.Fatal(err)
+	}
+	solver := newTestSolver(gandiClient)
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", key, `, "coTenant": true`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	want := []string{`"` + key + `"`, `"foreign"`}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("clean up: %v", err)
+	}
+	want = []string{`"foreign"`}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("values after cleanup = %v, want %v", got, want)
+	}
+
+	// A value not formatted like a challenge key is never removed, even if
+	// the webhook believes it presented it.
+	if err := solver.tracker.Add("_acme-challenge.example.com", "foreign"); err != nil {
+		t.Fatal(err)
+	}
+	ch = newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "foreign", `, "coTenant": true`)
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("clean up foreign value: %v", err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("values after foreign cleanup = %v, want %v", got, want)
+	}
+
+	ch = newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", key, `, "coTenant": true, "writeStrategy": "recreate"`)
+	if _, err := loadConfig(ch.Config); err == nil {
+		t.Error("expected an error for coTenant with the recreate write strategy")
+	}
+}
+
+func TestPreserveExistingUserValue(t *testing.T) {
+	keys := []string{"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0", "gfj9Xq-JjJy8eo1eY4nnRS3TOBKw0dYmhXHSpBqdoR4"}
+	gandiClient := fakelivedns.New()
+	if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"site-verification=abc"}); err != nil {
+		t.Fatal(err)
+	}
+	solver := newTestSolver(gandiClient)
+
+	for _, key := range keys {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", key, `, "preserveExisting": true`)
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("present: %v", err)
+		}
+	}
+	want := []string{`"` + keys[0] + `"`, `"` + keys[1] + `"`, `"site-verification=abc"`}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	for _, key := range keys {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", key, `, "preserveExisting": true`)
+		if err := solver.CleanUp(ch); err != nil {
+			t.Fatalf("clean up: %v", err)
+		}
+	}
+	want = []string{`"site-verification=abc"`}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("values after cleanup = %v, want %v", got, want)
+	}
+
+	// Writes dropping a user value are refused.
+	opts := &recordOptions{writeStrategy: writeStrategyUpdate, preserveExisting: true}
+	record := livedns.DomainRecord{RrsetTTL: GandiMinTtl, RrsetValues: want}
+	err := replaceValues(gandiClient, "example.com", "_acme-challenge", record, []string{`"` + keys[0] + `"`}, opts)
+	if err == nil || !strings.Contains(err.Error(), "refusing to drop 1 existing values") {
+		t.Errorf("error = %v, want a refusal", err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("values after refused write = %v, want %v", got, want)
+	}
+}
+
+func TestPresentTTLMismatch(t *testing.T) {
+	for _, tt := range []struct {
+		preserveTTL bool
+		want        int
+	}{
+		{preserveTTL: false, want: GandiMinTtl},
+		{preserveTTL: true, want: 3600},
+	} {
+		gandiClient := fakelivedns.New()
+		if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", 3600, []string{"apex"}); err != nil {
+			t.Fatal(err)
+		}
+		opts := &recordOptions{writeStrategy: writeStrategyUpdate, preserveTTL: tt.preserveTTL}
+		if err := presentValue(gandiClient, "example.com", "_acme-challenge", "wildcard", opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		record, _ := gandiClient.GetDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT")
+		if record.RrsetTTL != tt.want {
+			t.Errorf("preserveTTL=%v: TTL = %d, want %d", tt.preserveTTL, record.RrsetTTL, tt.want)
+		}
+	}
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "ttl": 60`)
+	if _, err := loadConfig(ch.Config); err == nil {
+		t.Error("expected an error for a TTL below the Gandi minimum")
+	}
+	ch = newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "ttl": 3000000`)
+	if _, err := loadConfig(ch.Config); err == nil {
+		t.Error("expected an error for a TTL above the Gandi maximum")
+	}
+}
+
+func TestCleanUpRequireTTLMatch(t *testing.T) {
+	for _, tt := range []struct {
+		requireTTLMatch bool
+		want            []string
+	}{
+		{requireTTLMatch: false, want: nil},
+		{requireTTLMatch: true, want: []string{`"key"`}},
+	} {
+		gandiClient := fakelivedns.New()
+		// The webhook's own record, and one another tool wrote with its TTL.
+		if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"key", "other"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge.www", "TXT", 3600, []string{"key"}); err != nil {
+			t.Fatal(err)
+		}
+		opts := &recordOptions{cleanUpRequireTTLMatch: tt.requireTTLMatch}
+		for _, subdomain := range []string{"_acme-challenge", "_acme-challenge.www"} {
+			if err := cleanUpValue(gandiClient, "example.com", subdomain, "key", opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if got, want := gandiClient.Values("example.com", "_acme-challenge", "TXT"), []string{`"other"`}; !reflect.DeepEqual(got, want) {
+			t.Errorf("requireTTLMatch=%v: matching TTL values = %v, want %v", tt.requireTTLMatch, got, want)
+		}
+		if got := gandiClient.Values("example.com", "_acme-challenge.www", "TXT"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("requireTTLMatch=%v: other TTL values = %v, want %v", tt.requireTTLMatch, got, tt.want)
+		}
+	}
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "cleanUpRequireTTLMatch": true`)
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.recordOptions().cleanUpRequireTTLMatch {
+		t.Error("cleanUpRequireTTLMatch not passed to the record options")
+	}
+}
+
+func TestAdjustTTL(t *testing.T) {
+	for _, tt := range []struct {
+		ttl  int
+		want int
+	}{
+		{ttl: 0, want: 0},
+		{ttl: 60, want: GandiMinTtl},
+		{ttl: 3600, want: 3600},
+		{ttl: 3000000, want: GandiMaxTtl},
+	} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", fmt.Sprintf(`, "ttl": %d, "adjustTTL": true`, tt.ttl))
+		cfg, err := loadConfig(ch.Config)
+		if err != nil {
+			t.Errorf("ttl %d: unexpected error: %v", tt.ttl, err)
+			continue
+		}
+		if got := cfg.recordOptions().ttl; got != tt.want {
+			t.Errorf("ttl %d: adjusted to %d, want %d", tt.ttl, got, tt.want)
+		}
+	}
+}
+
+func TestMaxTTL(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		want   int
+	}{
+		{config: `"ttl": 7200, "maxTTL": 3600`, want: 3600},
+		{config: `"ttl": 1800, "maxTTL": 3600`, want: 1800},
+		{config: `"ttl": 3000000, "maxTTL": 3600`, want: 3600},
+		{config: `"ttl": 60, "maxTTL": 3600, "adjustTTL": true`, want: GandiMinTtl},
+	} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", ", "+tt.config)
+		cfg, err := loadConfig(ch.Config)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.config, err)
+			continue
+		}
+		if got := cfg.recordOptions().ttl; got != tt.want {
+			t.Errorf("%s: TTL = %d, want %d", tt.config, got, tt.want)
+		}
+	}
+
+	for _, config := range []string{`"maxTTL": 60`, `"maxTTL": 3000000`, `"ttl": 60, "maxTTL": 3600`} {
+		ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", ", "+config)
+		if _, err := loadConfig(ch.Config); err == nil {
+			t.Errorf("%s: expected an error", config)
+		}
+	}
+
+	// A preserved TTL is capped too.
+	gandiClient := fakelivedns.New()
+	if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", 86400, []string{"apex"}); err != nil {
+		t.Fatal(err)
+	}
+	opts := &recordOptions{writeStrategy: writeStrategyUpdate, preserveTTL: true, maxTTL: 3600}
+	if err := presentValue(gandiClient, "example.com", "_acme-challenge", "wildcard", opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if record, _ := gandiClient.GetDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT"); record.RrsetTTL != 3600 {
+		t.Errorf("preserved TTL = %d, want 3600", record.RrsetTTL)
+	}
+}
+
+func TestDuplicateRRsetsAreConsolidated(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	if _, err := gandiClient.CreateDomainRecord("example.com", "_acme-challenge", "TXT", GandiMinTtl, []string{"apex"}); err != nil {
+		t.Fatal(err)
+	}
+	gandiClient.AddDuplicate("example.com", livedns.DomainRecord{
+		RrsetName: "_acme-challenge", RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetValues: []string{`"apex"`, `"stale"`},
+	})
+
+	opts := &recordOptions{writeStrategy: writeStrategyUpdate}
+	if err := presentValue(gandiClient, "example.com", "_acme-challenge", "wildcard", opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{`"apex"`, `"stale"`, `"wildcard"`}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v", got, want)
+	}
+	if n := gandiClient.Duplicates(); n != 0 {
+		t.Errorf("%d duplicates left", n)
+	}
+
+	// Names other than challenge names are read as is and never rewritten.
+	if _, err := gandiClient.CreateDomainRecord("example.com", "www", "TXT", GandiMinTtl, []string{"user"}); err != nil {
+		t.Fatal(err)
+	}
+	gandiClient.AddDuplicate("example.com", livedns.DomainRecord{
+		RrsetName: "www", RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetValues: []string{`"other"`},
+	})
+	gandiClient.ResetCalls()
+	if _, err := getTXTRecord(gandiClient, "example.com", "www", &recordOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := gandiClient.Calls("UpdateDomainRecordByNameAndType") + gandiClient.Calls("GetDomainRecordsByName"); n != 0 {
+		t.Errorf("non-challenge name was listed or rewritten")
+	}
+}
+
+func TestWrittenValuesAreSorted(t *testing.T) {
+	keys := []string{"c-key", "a-key", "b-key"}
+	for _, strategy := range []string{writeStrategyUpdate, writeStrategyRecreate} {
+		t.Run(strategy, func(t *testing.T) {
+			gandiClient := fakelivedns.New()
+			opts := &recordOptions{writeStrategy: strategy}
+			for _, key := range keys {
+				if err := presentValue(gandiClient, "example.com", "_acme-challenge", key, opts); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := []string{`"a-key"`, `"b-key"`, `"c-key"`}
+			if got := gandiClient.Written(); !reflect.DeepEqual(got, want) {
+				t.Errorf("written values = %v, want %v", got, want)
+			}
+
+			// Presenting the values again in another order writes nothing.
+			gandiClient.ResetCalls()
+			for _, key := range []string{"b-key", "c-key", "a-key"} {
+				if err := presentValue(gandiClient, "example.com", "_acme-challenge", key, opts); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := gandiClient.Calls("UpdateDomainRecordByNameAndType") + gandiClient.Calls("CreateDomainRecord"); n != 0 {
+				t.Errorf("%d writes presenting the same values, want none", n)
+			}
+
+			if err := cleanUpValue(gandiClient, "example.com", "_acme-challenge", "b-key", opts); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := gandiClient.Written(), []string{`"a-key"`, `"c-key"`}; !reflect.DeepEqual(got, want) {
+				t.Errorf("written values after clean up = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// unexpectedRecordTypeFail fails the operation when Gandi returns a
+	// record of another type than the one read.
+	unexpectedRecordTypeFail = "fail"
+	// unexpectedRecordTypeIgnore goes on as if there was no record.
+	unexpectedRecordTypeIgnore = "ignore"
+)
+
+// unexpectedRecordTypeError is returned when Gandi returns a record of
+// another type than the one read, by a typeCheckingClient or by getTXTRecord
+// for a challenge name.
+type unexpectedRecordTypeError struct {
+	zone, name string
+	want, got  string
+}
+
+func (e *unexpectedRecordTypeError) Error() string {
+	return fmt.Sprintf("Gandi returned a %q record reading %s record %s in zone %s, refusing to use it", e.got, e.want, e.name, e.zone)
+}
+
+// typeCheckingClient is a liveDNSClient checking the RRset it reads by name
+// and type is of that type, so challenge values are never merged into or
+// removed from a record of another type, such as a CNAME at the same name.
+type typeCheckingClient struct {
+	liveDNSClient
+	policy string
+}
+
+func checkRecordTypes(next liveDNSClient, policy string) liveDNSClient {
+	return &typeCheckingClient{liveDNSClient: next, policy: policy}
+}
+
+func (t *typeCheckingClient) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	record, err := t.liveDNSClient.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	if err != nil || strings.EqualFold(record.RrsetType, recordtype) {
+		return record, err
+	}
+	return livedns.DomainRecord{}, unexpectedRecordType(t.policy, fqdn, name, recordtype, record.RrsetType)
+}
+
+// unexpectedRecordType returns the error of reading the record name of type
+// want in zone when Gandi returned one of type got instead: a 404, as if
+// there was none, with unexpectedRecordTypeIgnore, or else an
+// *unexpectedRecordTypeError.
+func unexpectedRecordType(policy, zone, name, want, got string) error {
+	if policy == unexpectedRecordTypeIgnore {
+		klog.Warningf("Gandi returned a %q record reading %s record %s in zone %s, going on as if there was none", got, want, name, zone)
+		return fmt.Errorf("404: Can't find the DNS record %s/%s in LiveDNS", name, want)
+	}
+	return &unexpectedRecordTypeError{zone: zone, name: name, want: want, got: got}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+// mistypedClient returns a CNAME record whatever type is read by name and
+// type.
+type mistypedClient struct {
+	*fakelivedns.Client
+}
+
+func (m mistypedClient) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	if _, err := m.Client.GetDomainRecordByNameAndType(fqdn, name, recordtype); err != nil && !isNotFoundError(err) {
+		return livedns.DomainRecord{}, err
+	}
+	return livedns.DomainRecord{RrsetType: "CNAME", RrsetTTL: GandiMinTtl, RrsetName: name, RrsetValues: []string{"acme.example.net."}}, nil
+}
+
+func TestUnexpectedRecordType(t *testing.T) {
+	for _, tt := range []struct {
+		policy string
+		want   string
+		values []string
+	}{
+		{policy: "", want: `returned a "CNAME" record reading TXT record _validation`},
+		{policy: unexpectedRecordTypeFail, want: `returned a "CNAME" record reading TXT record _validation`},
+		{policy: unexpectedRecordTypeIgnore, values: []string{`"key"`}},
+	} {
+		gandiClient := fakelivedns.New()
+		solver := newTestSolver(gandiClient)
+		solver.newClient = func(config.Config) liveDNSClient {
+			return mistypedClient{gandiClient}
+		}
+		extra := ""
+		if tt.policy != "" {
+			extra = `, "unexpectedRecordTypePolicy": "` + tt.policy + `"`
+		}
+		// Not a challenge label, so the record is read by name and type.
+		ch := newTestChallengeRequest("_validation.example.com.", "example.com.", "key", extra)
+
+		err := solver.Present(ch)
+		if tt.want == "" && err != nil {
+			t.Errorf("policy %q: unexpected error: %v", tt.policy, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("policy %q: error = %v, want %q", tt.policy, err, tt.want)
+		}
+		if got := gandiClient.Values("example.com", "_validation", "TXT"); !reflect.DeepEqual(got, tt.values) {
+			t.Errorf("policy %q: values = %v, want %v", tt.policy, got, tt.values)
+		}
+
+		// CleanUp of a presented value fails rather than going on, and
+		// leaves the TXT record alone.
+		gandiClient.Set("example.com", livedns.DomainRecord{RrsetType: "TXT", RrsetTTL: GandiMinTtl, RrsetName: "_validation", RrsetValues: []string{`"key"`}})
+		if err := solver.tracker.Add("_validation.example.com", "key"); err != nil {
+			t.Fatal(err)
+		}
+		err = solver.CleanUp(newTestChallengeRequest("_validation.example.com.", "example.com.", "key", extra+`, "failOnCleanupError": true`))
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("policy %q: clean up: error = %v, want %q", tt.policy, err, tt.want)
+		}
+		if got := gandiClient.Values("example.com", "_validation", "TXT"); tt.want != "" && got == nil {
+			t.Errorf("policy %q: clean up deleted the TXT record", tt.policy)
+		}
+	}
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "unexpectedRecordTypePolicy": "retry"`)
+	if _, err := loadConfig(ch.Config); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestUnexpectedRecordTypeAtChallengeName(t *testing.T) {
+	for _, tt := range []struct {
+		policy string
+		want   string
+		values []string
+	}{
+		{policy: "", want: `returned a "CNAME" record reading TXT record _acme-challenge`},
+		{policy: unexpectedRecordTypeIgnore, values: []string{`"key"`}},
+	} {
+		gandiClient := fakelivedns.New()
+		gandiClient.Set("example.com", livedns.DomainRecord{RrsetType: "CNAME", RrsetTTL: GandiMinTtl, RrsetName: "_acme-challenge", RrsetValues: []string{"acme.example.net."}})
+		solver := newTestSolver(gandiClient)
+		extra := ""
+		if tt.policy != "" {
+			extra = `, "unexpectedRecordTypePolicy": "` + tt.policy + `"`
+		}
+		// A challenge name is read by name, holding the CNAME only.
+		err := solver.Present(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", extra))
+		if tt.want == "" && err != nil {
+			t.Errorf("policy %q: unexpected error: %v", tt.policy, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("policy %q: error = %v, want %q", tt.policy, err, tt.want)
+		}
+		if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, tt.values) {
+			t.Errorf("policy %q: values = %v, want %v", tt.policy, got, tt.values)
+		}
+		if n := gandiClient.Calls("GetDomainRecordsByName"); n == 0 {
+			t.Errorf("policy %q: challenge name not read by name", tt.policy)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/klog/v2"
+)
+
+// defaultRepairTimeout bounds a repair of all presented challenges.
+const defaultRepairTimeout = 2 * time.Minute
+
+// repairMu serializes repairs.
+var repairMu sync.Mutex
+
+// repairReport is the outcome of a repair. Skipped counts the challenges left
+// unchecked once the repair timed out.
+type repairReport struct {
+	Checked  int      `json:"checked"`
+	Repaired int      `json:"repaired"`
+	Skipped  int      `json:"skipped"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// repairDrift re-reads the TXT record of every presented challenge from all
+// accounts and presents its value again where it went missing, e.g. because
+// another tool removed it. Every challenge is repaired by the solver that
+// presented it, with its defaults and credentials. It gives up on the
+// challenges left once timeout is exceeded. Challenges in audit mode are not
+// checked.
+func (c *gandiDNSProviderSolver) repairDrift(timeout time.Duration) repairReport {
+	repairMu.Lock()
+	defer repairMu.Unlock()
+
+	var report repairReport
+	deadline := c.clock.Now().Add(timeout)
+	for _, p := range c.challenges.presentedRequests() {
+		ch := p.request
+		remaining := deadline.Sub(c.clock.Now())
+		if remaining <= 0 {
+			report.Skipped++
+			continue
+		}
+		repaired, err := p.solver.repairChallenge(ch, remaining)
+		if err != nil {
+			klog.Errorf("Unable to repair TXT value %s for %s: %v", keyHash(ch.Key), ch.ResolvedFQDN, err)
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", ch.ResolvedFQDN, err))
+		}
+		if repaired < 0 {
+			continue
+		}
+		report.Checked++
+		report.Repaired += repaired
+	}
+	if report.Skipped > 0 {
+		klog.Warningf("Repair timed out after %s, %d challenges left unchecked", timeout, report.Skipped)
+	}
+	klog.V(2).Infof("Repair checked %d challenges, repaired %d TXT records", report.Checked, report.Repaired)
+	return report
+}
+
+// repairChallenge repairs the TXT records of ch within timeout. It returns
+// the number of records repaired, or -1 if ch was not checked.
+func (c *gandiDNSProviderSolver) repairChallenge(ch *v1alpha1.ChallengeRequest, timeout time.Duration) (int, error) {
+	cfg, err := c.solverConfig(ch.Config)
+	if err != nil {
+		return -1, fmt.Errorf("unable to load config: %v", err)
+	}
+	if cfg.AuditOnly {
+		return -1, nil
+	}
+	entry, domain := c.getDomainAndEntry(ch)
+	root, subdomain, err := cfg.rootAndSubDomain(domain, entry)
+	if err != nil {
+		return -1, fmt.Errorf("unable to mange provided domain : %v", err)
+	}
+	// The challenge may have been cleaned up since it was listed.
+	if ok, err := c.tracker.Has(recordName(root, subdomain), ch.Key); err != nil || !ok {
+		return -1, err
+	}
+
+	_, targets, err := c.getAccountTargets(&cfg, ch.ResourceNamespace, newOperationBudget(c.clock, timeout), root, subdomain)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.SerializeZoneWrites {
+		defer c.zoneLocks.lock(targets[0].root)()
+	}
+	repaired := 0
+	var lastErr error
+	for _, t := range targets {
+		ok, err := c.repairValue(t, ch.Key, &cfg)
+		if err != nil {
+			lastErr = fmt.Errorf("%s account: %v", t.name, err)
+			continue
+		}
+		if ok {
+			klog.Infof("Repaired TXT value %s for %s in %s account", keyHash(ch.Key), recordName(t.root, t.subdomain), t.name)
+			repaired++
+		}
+	}
+	return repaired, lastErr
+}
+
+// repairValue presents key again in the RRset of target if it is missing,
+// writing it like Present does. It reports whether the record was repaired.
+func (c *gandiDNSProviderSolver) repairValue(t accountTarget, key string, cfg *gandiDNSProviderConfig) (bool, error) {
+	record, err := getTXTRecord(t.client, t.root, t.subdomain, cfg.recordOptions())
+	if err != nil && !isNotFoundError(err) {
+		return false, recordErrorf(t.root, t.subdomain, "get", err)
+	}
+	if err == nil && hasTXTValue(record.RrsetValues, key) {
+		return false, nil
+	}
+	klog.Warningf("TXT value %s for %s drifted from Gandi, presenting it again", keyHash(key), recordName(t.root, t.subdomain))
+	// The value is gone, however recently it was written.
+	c.writes.forget(t, key)
+	if err := c.writeValue(t, key, true, cfg); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+func TestRepairDrift(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	www := newTestChallengeRequest("_acme-challenge.www.example.com.", "example.com.", "www-key", "")
+	api := newTestChallengeRequest("_acme-challenge.api.example.com.", "example.com.", "api-key", "")
+	for _, ch := range []*v1alpha1.ChallengeRequest{www, api} {
+		if err := solver.Present(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another tool removes the value of www and the whole RRset of api.
+	gandiClient.Set("example.com", livedns.DomainRecord{RrsetName: "_acme-challenge.www", RrsetType: "TXT", RrsetTTL: 300, RrsetValues: []string{`"other"`}})
+	if err := gandiClient.DeleteDomainRecord("example.com", "_acme-challenge.api", "TXT"); err != nil {
+		t.Fatal(err)
+	}
+
+	report := solver.repairDrift(defaultRepairTimeout)
+	if want := (repairReport{Checked: 2, Repaired: 2}); !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+	if got, want := gandiClient.Values("example.com", "_acme-challenge.www", "TXT"), []string{`"other"`, `"www-key"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("www values = %v, want %v", got, want)
+	}
+	if got, want := gandiClient.Values("example.com", "_acme-challenge.api", "TXT"), []string{`"api-key"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("api values = %v, want %v", got, want)
+	}
+
+	gandiClient.ResetCalls()
+	if report := solver.repairDrift(defaultRepairTimeout); report.Repaired != 0 {
+		t.Errorf("second repair = %+v, want nothing repaired", report)
+	}
+	if n := gandiClient.Calls("UpdateDomainRecordByNameAndType") + gandiClient.Calls("CreateDomainRecord"); n != 0 {
+		t.Errorf("%d writes without drift, want none", n)
+	}
+
+	if err := solver.CleanUp(api); err != nil {
+		t.Fatal(err)
+	}
+	if report := solver.repairDrift(defaultRepairTimeout); report.Checked != 1 {
+		t.Errorf("repair after clean up = %+v, want only www checked", report)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge.api", "TXT"); got != nil {
+		t.Errorf("api values after clean up and repair = %v, want none", got)
+	}
+}
+
+func TestRepairDriftWithPresentingSolver(t *testing.T) {
+	productionClient, stagingClient := fakelivedns.New(), fakelivedns.New()
+	production, staging := newTestSolver(productionClient), newTestSolver(stagingClient)
+	staging.defaults = json.RawMessage(`{"ttl": 600, "minWriteInterval": "5m"}`)
+	staging.challenges, staging.tracker = production.challenges, production.tracker
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+	if err := staging.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := stagingClient.DeleteDomainRecord("example.com", "_acme-challenge", "TXT"); err != nil {
+		t.Fatal(err)
+	}
+	productionClient.ResetCalls()
+
+	// The first solver serves the admin endpoint, but the challenge is
+	// repaired with the config and account of the solver that presented it,
+	// even though it was written less than minWriteInterval ago.
+	if report := production.repairDrift(defaultRepairTimeout); !reflect.DeepEqual(report, repairReport{Checked: 1, Repaired: 1}) {
+		t.Errorf("report = %+v, want the challenge repaired", report)
+	}
+	record, err := stagingClient.GetDomainRecordByNameAndType("example.com", "_acme-challenge", "TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`"key"`}; !reflect.DeepEqual(record.RrsetValues, want) || record.RrsetTTL != 600 {
+		t.Errorf("record = %+v, want %v with the TTL of the staging solver", record, want)
+	}
+	if n := productionClient.TotalCalls(); n != 0 {
+		t.Errorf("%d calls to the account of the production solver, want none", n)
+	}
+}
+
+func TestRepairDriftTimeout(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	if err := solver.Present(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	gandiClient.ResetCalls()
+
+	if report := solver.repairDrift(0); !reflect.DeepEqual(report, repairReport{Skipped: 1}) {
+		t.Errorf("report = %+v, want the challenge skipped", report)
+	}
+	if n := gandiClient.TotalCalls(); n != 0 {
+		t.Errorf("%d calls to Gandi, want none", n)
+	}
+}
+
+func TestAdminRepair(t *testing.T) {
+	calls := 0
+	handler := newAdminHandler(newMemoryTracker(), newChallengeRegistry(newFakeClock()), func() repairReport {
+		calls++
+		return repairReport{Checked: 1}
+	}, "admin-token")
+
+	for _, tt := range []struct {
+		method string
+		status int
+	}{
+		{method: http.MethodGet, status: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, status: http.StatusOK},
+	} {
+		req := httptest.NewRequest(tt.method, "/repair", nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Fatalf("%s status = %d, want %d", tt.method, rec.Code, tt.status)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("repair called %d times, want 1", calls)
+	}
+}
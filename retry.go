@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"github.com/go-gandi/go-gandi/types"
+	"k8s.io/klog/v2"
+)
+
+// retryPolicy is an exponential backoff for transient Gandi errors.
+type retryPolicy struct {
+	// initialDelay is the delay before the first retry, it doubles with every
+	// further retry up to maxDelay.
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	// budget is the total time that may be spent waiting between attempts,
+	// counted before jitter so the number of attempts does not vary.
+	budget time.Duration
+	// jitter randomizes delays so retries of challenges failing together
+	// are spread out. No jitter is applied if empty.
+	jitter string
+}
+
+const (
+	defaultMaintenanceRetryInterval = 30 * time.Second
+
+	// jitterFull waits a random delay between zero and the backoff delay.
+	jitterFull = "full"
+	// jitterEqual waits half the backoff delay plus a random delay up to
+	// the other half.
+	jitterEqual = "equal"
+	// jitterNone waits exactly the backoff delay.
+	jitterNone = "none"
+)
+
+var defaultRetryPolicy = retryPolicy{
+	initialDelay: time.Second,
+	maxDelay:     8 * time.Second,
+	budget:       15 * time.Second,
+	jitter:       retryJitterFromEnv(),
+}
+
+// retryJitterFromEnv returns the jitter strategy set by RETRY_JITTER, full
+// jitter by default.
+func retryJitterFromEnv() string {
+	if v := os.Getenv("RETRY_JITTER"); v != "" {
+		return v
+	}
+	return jitterFull
+}
+
+// validateRetryJitter ensures jitter is a known jitter strategy.
+func validateRetryJitter(jitter string) error {
+	switch jitter {
+	case jitterFull, jitterEqual, jitterNone:
+		return nil
+	}
+	return fmt.Errorf("unknown jitter strategy %q, must be %q, %q or %q", jitter, jitterFull, jitterEqual, jitterNone)
+}
+
+// delay returns the delay before the given retry, starting at 1.
+func (p retryPolicy) delay(retry int) time.Duration {
+	d := p.initialDelay
+	for i := 1; i < retry && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	if d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return d
+}
+
+// operationBudget bounds the total time a Present or CleanUp may spend
+// retrying and waiting, so it gives up before cert-manager does.
+type operationBudget struct {
+	timeout  time.Duration
+	deadline time.Time
+}
+
+// newOperationBudget returns a budget of timeout starting now, or nil for no
+// budget if timeout is zero.
+func newOperationBudget(clk clock, timeout time.Duration) *operationBudget {
+	if timeout == 0 {
+		return nil
+	}
+	return &operationBudget{timeout: timeout, deadline: clk.Now().Add(timeout)}
+}
+
+// allows reports whether waiting d from now stays within the budget. A nil
+// budget allows any wait.
+func (b *operationBudget) allows(clk clock, d time.Duration) bool {
+	return b == nil || !clk.Now().Add(d).After(b.deadline)
+}
+
+// exhausted wraps the error of the operation that ran out of budget.
+func (b *operationBudget) exhausted(err error) error {
+	return fmt.Errorf("operation budget of %s exhausted: %v", b.timeout, err)
+}
+
+// jittered applies the jitter strategy to delay d, using random to draw a
+// number in [0, n).
+func (p retryPolicy) jittered(d time.Duration, random func(n int64) int64) time.Duration {
+	switch p.jitter {
+	case jitterFull:
+		return time.Duration(random(int64(d) + 1))
+	case jitterEqual:
+		return d/2 + time.Duration(random(int64(d-d/2)+1))
+	}
+	return d
+}
+
+// retryingClient retries the calls of a liveDNSClient failing with transient
+// errors. Errors indicating a Gandi maintenance are retried following the
+// maintenance policy, if there is one, all others following the normal policy.
+type retryingClient struct {
+	next        liveDNSClient
+	clock       clock
+	policy      retryPolicy
+	maintenance *retryPolicy
+	random      func(n int64) int64
+	budget      *operationBudget
+}
+
+func newRetryingClient(next liveDNSClient, clk clock, policy retryPolicy, maintenance *retryPolicy) *retryingClient {
+	return &retryingClient{next: next, clock: clk, policy: policy, maintenance: maintenance, random: rand.Int63n}
+}
+
+// withBudget returns a copy of the client that stops retrying once budget
+// would be exceeded.
+func (r *retryingClient) withBudget(budget *operationBudget) *retryingClient {
+	budgeted := *r
+	budgeted.budget = budget
+	return &budgeted
+}
+
+func (r *retryingClient) do(op string, fn func() error) error {
+	var waited time.Duration
+	for retry := 1; ; retry++ {
+		err := fn()
+		if !isRetryableError(err) {
+			return err
+		}
+		policy := r.policy
+		if r.maintenance != nil && isMaintenanceError(err) {
+			policy = *r.maintenance
+		}
+		delay := policy.delay(retry)
+		if waited+delay > policy.budget {
+			return err
+		}
+		waited += delay
+		delay = policy.jittered(delay, r.random)
+		if !r.budget.allows(r.clock, delay) {
+			return r.budget.exhausted(err)
+		}
+		klog.V(4).Infof("%s failed, retrying in %s: %v", op, delay, err)
+		r.clock.Sleep(delay)
+	}
+}
+
+func (r *retryingClient) GetDomainRecordsByName(fqdn, name string) (records []livedns.DomainRecord, err error) {
+	err = r.do("GetDomainRecordsByName", func() error {
+		records, err = r.next.GetDomainRecordsByName(fqdn, name)
+		return err
+	})
+	return
+}
+
+func (r *retryingClient) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (record livedns.DomainRecord, err error) {
+	err = r.do("GetDomainRecordByNameAndType", func() error {
+		record, err = r.next.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+		return err
+	})
+	return
+}
+
+func (r *retryingClient) CreateDomainRecord(fqdn, name, recordtype string, ttl int, values []string) (response types.StandardResponse, err error) {
+	err = r.do("CreateDomainRecord", func() error {
+		response, err = r.next.CreateDomainRecord(fqdn, name, recordtype, ttl, values)
+		return err
+	})
+	return
+}
+
+func (r *retryingClient) UpdateDomainRecordByNameAndType(fqdn, name, recordtype string, ttl int, values []string) (response types.StandardResponse, err error) {
+	err = r.do("UpdateDomainRecordByNameAndType", func() error {
+		response, err = r.next.UpdateDomainRecordByNameAndType(fqdn, name, recordtype, ttl, values)
+		return err
+	})
+	return
+}
+
+func (r *retryingClient) DeleteDomainRecord(fqdn, name, recordtype string) error {
+	return r.do("DeleteDomainRecord", func() error {
+		return r.next.DeleteDomainRecord(fqdn, name, recordtype)
+	})
+}
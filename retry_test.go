@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+)
+
+// errorSequence returns the queued errors one after the other, then nil.
+func errorSequence(errs ...error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if len(errs) == 0 {
+			return nil
+		}
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	}, &calls
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := retryPolicy{initialDelay: time.Second, maxDelay: 5 * time.Second}
+	var got []time.Duration
+	for retry := 1; retry <= 5; retry++ {
+		got = append(got, p.delay(retry))
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delays = %v, want %v", got, want)
+	}
+}
+
+func TestRetryingClient(t *testing.T) {
+	serverError := errors.New("500: Internal Server Error")
+	maintenanceError := errors.New("503: Service Unavailable, the API is under maintenance")
+	notFound := errors.New("404: Can't find the DNS record")
+
+	maintenance := &retryPolicy{initialDelay: time.Minute, maxDelay: time.Minute, budget: 5 * time.Minute}
+
+	tests := []struct {
+		name        string
+		errs        []error
+		maintenance *retryPolicy
+		wantErr     error
+		wantSleeps  []time.Duration
+	}{
+		{
+			name:       "success",
+			wantSleeps: nil,
+		},
+		{
+			name:       "transient errors",
+			errs:       []error{serverError, serverError},
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:       "not retryable",
+			errs:       []error{notFound},
+			wantErr:    notFound,
+			wantSleeps: nil,
+		},
+		{
+			name:       "budget exhausted",
+			errs:       []error{serverError, serverError, serverError, serverError, serverError},
+			wantErr:    serverError,
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			name:       "maintenance without maintenance policy",
+			errs:       []error{maintenanceError, maintenanceError, maintenanceError, maintenanceError, maintenanceError},
+			wantErr:    maintenanceError,
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			name:        "maintenance",
+			errs:        []error{maintenanceError, maintenanceError, maintenanceError, maintenanceError, maintenanceError},
+			maintenance: maintenance,
+			wantSleeps:  []time.Duration{time.Minute, time.Minute, time.Minute, time.Minute, time.Minute},
+		},
+		{
+			name:        "maintenance budget exhausted",
+			errs:        []error{maintenanceError, maintenanceError, maintenanceError, maintenanceError, maintenanceError, maintenanceError},
+			maintenance: maintenance,
+			wantErr:     maintenanceError,
+			wantSleeps:  []time.Duration{time.Minute, time.Minute, time.Minute, time.Minute, time.Minute},
+		},
+		{
+			name:        "normal errors during maintenance policy",
+			errs:        []error{serverError, serverError},
+			maintenance: maintenance,
+			wantSleeps:  []time.Duration{time.Second, 2 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := newFakeClock()
+			policy := defaultRetryPolicy
+			policy.jitter = jitterNone
+			r := newRetryingClient(nil, clk, policy, tt.maintenance)
+			fn, calls := errorSequence(tt.errs...)
+
+			err := r.do("op", fn)
+			if err != tt.wantErr {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(clk.Sleeps(), tt.wantSleeps) {
+				t.Errorf("sleeps = %v, want %v", clk.Sleeps(), tt.wantSleeps)
+			}
+			if *calls != len(tt.wantSleeps)+1 {
+				t.Errorf("calls = %d, want %d", *calls, len(tt.wantSleeps)+1)
+			}
+		})
+	}
+}
+
+func TestRetryingClientJitter(t *testing.T) {
+	serverError := errors.New("500: Internal Server Error")
+	delays := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+
+	tests := []struct {
+		jitter string
+		min    func(d time.Duration) time.Duration
+	}{
+		{jitter: jitterFull, min: func(time.Duration) time.Duration { return 0 }},
+		{jitter: jitterEqual, min: func(d time.Duration) time.Duration { return d / 2 }},
+		{jitter: jitterNone, min: func(d time.Duration) time.Duration { return d }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.jitter, func(t *testing.T) {
+			policy := defaultRetryPolicy
+			policy.jitter = tt.jitter
+			for i := 0; i < 20; i++ {
+				clk := newFakeClock()
+				r := newRetryingClient(nil, clk, policy, nil)
+				fn, _ := errorSequence(serverError, serverError, serverError, serverError, serverError)
+				if err := r.do("op", fn); err != serverError {
+					t.Fatalf("error = %v, want %v", err, serverError)
+				}
+
+				sleeps := clk.Sleeps()
+				if len(sleeps) != len(delays) {
+					t.Fatalf("sleeps = %v, want %d of them", sleeps, len(delays))
+				}
+				for j, d := range delays {
+					if sleeps[j] < tt.min(d) || sleeps[j] > d {
+						t.Errorf("sleep %d = %s, want between %s and %s", j, sleeps[j], tt.min(d), d)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRetryJitterFromEnv(t *testing.T) {
+	t.Setenv("RETRY_JITTER", "")
+	if got := retryJitterFromEnv(); got != jitterFull {
+		t.Errorf("default jitter = %q, want %q", got, jitterFull)
+	}
+	t.Setenv("RETRY_JITTER", "equal")
+	if got := retryJitterFromEnv(); got != jitterEqual {
+		t.Errorf("jitter = %q, want %q", got, jitterEqual)
+	}
+	if err := validateRetryJitter("decorrelated"); err == nil {
+		t.Error("expected an error for an unknown jitter strategy")
+	}
+}
+
+func TestRetryingClientBudget(t *testing.T) {
+	serverError := errors.New("500: Internal Server Error")
+	clk := newFakeClock()
+	policy := defaultRetryPolicy
+	policy.jitter = jitterNone
+	r := newRetryingClient(nil, clk, policy, nil).withBudget(newOperationBudget(clk, 4*time.Second))
+	fn, _ := errorSequence(serverError, serverError, serverError, serverError)
+
+	err := r.do("op", fn)
+	if err == nil || !strings.Contains(err.Error(), "operation budget of 4s exhausted") {
+		t.Errorf("error = %v, want the budget to be exhausted", err)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second}
+	if !reflect.DeepEqual(clk.Sleeps(), want) {
+		t.Errorf("sleeps = %v, want %v", clk.Sleeps(), want)
+	}
+}
+
+func TestPresentOperationTimeout(t *testing.T) {
+	jitter := defaultRetryPolicy.jitter
+	defaultRetryPolicy.jitter = jitterNone
+	defer func() { defaultRetryPolicy.jitter = jitter }()
+
+	gandiClient := fakelivedns.New()
+	gandiClient.Err = errors.New("502: Bad Gateway")
+	solver := newTestSolver(gandiClient)
+	clk := solver.clock.(*fakeClock)
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "operationTimeout": "5s"`)
+	err := solver.Present(ch)
+	if err == nil || !strings.Contains(err.Error(), "operation budget of 5s exhausted") {
+		t.Errorf("error = %v, want the budget to be exhausted", err)
+	}
+	var waited time.Duration
+	for _, d := range clk.Sleeps() {
+		waited += d
+	}
+	if waited > 5*time.Second {
+		t.Errorf("waited %s, more than the operation budget", waited)
+	}
+}
+
+func TestPresentRecoversFromInjectedFaults(t *testing.T) {
+	jitter := defaultRetryPolicy.jitter
+	defaultRetryPolicy.jitter = jitterNone
+	defer func() { defaultRetryPolicy.jitter = jitter }()
+
+	gandiClient := fakelivedns.New()
+	gandiClient.Fail("GetDomainRecordsByName", 429, 2)
+	gandiClient.Fail("CreateDomainRecord", 500, 1)
+	solver := newTestSolver(gandiClient)
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, []string{`"key"`}) {
+		t.Errorf("values = %v, want the key", got)
+	}
+	want := map[string]int{"GetDomainRecordsByName": 3, "CreateDomainRecord": 2}
+	if got := gandiClient.CallCounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/go-gandi/go-gandi/types"
+	"k8s.io/klog/v2"
+)
+
+// snapshotter is implemented by LiveDNS clients able to snapshot a zone.
+type snapshotter interface {
+	CreateSnapshot(fqdn string) (types.StandardResponse, error)
+}
+
+// snapshotZone takes a snapshot of zone root before the webhook writes to
+// it, logging its ID so the zone can be restored should a write corrupt it.
+// Failures are only logged: snapshots are a safety net, not a requirement of
+// the challenge.
+func snapshotZone(gandiClient liveDNSClient, root, op string) {
+	s, ok := gandiClient.(snapshotter)
+	if !ok {
+		klog.V(2).Infof("Not taking a snapshot of zone %s before %s: the client does not support snapshots", root, op)
+		return
+	}
+	response, err := s.CreateSnapshot(root)
+	if err != nil {
+		klog.Warningf("Unable to take a snapshot of zone %s before %s: %v", root, op, err)
+		return
+	}
+	id := response.UUID
+	if id == "" {
+		id = response.Message
+	}
+	klog.Infof("Took snapshot %s of zone %s before %s", id, root, op)
+}
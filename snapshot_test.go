@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/types"
+)
+
+// snapshottingClient is a fake LiveDNS client supporting snapshots.
+type snapshottingClient struct {
+	*fakelivedns.Client
+	snapshots []string
+	err       error
+}
+
+func (s *snapshottingClient) CreateSnapshot(fqdn string) (types.StandardResponse, error) {
+	if s.err != nil {
+		return types.StandardResponse{}, s.err
+	}
+	s.snapshots = append(s.snapshots, fqdn)
+	return types.StandardResponse{UUID: "b1d8f5a2"}, nil
+}
+
+func TestSnapshotBeforeWrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		err       error
+		snapshots int
+	}{
+		{name: "disabled", config: ``, snapshots: 0},
+		{name: "enabled", config: `, "snapshotBeforeWrite": true`, snapshots: 2},
+		{name: "audit only", config: `, "snapshotBeforeWrite": true, "auditOnly": true`, snapshots: 0},
+		{name: "snapshot fails", config: `, "snapshotBeforeWrite": true`, err: fakelivedns.StatusError(500, "Internal Server Error")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := &snapshottingClient{Client: fakelivedns.New(), err: tt.err}
+			solver := newTestSolver(gandiClient.Client)
+			solver.newClient = func(config.Config) liveDNSClient { return gandiClient }
+			ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", tt.config)
+
+			if err := solver.Present(ch); err != nil {
+				t.Fatalf("Present() error = %v", err)
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatalf("CleanUp() error = %v", err)
+			}
+			if len(gandiClient.snapshots) != tt.snapshots {
+				t.Errorf("snapshots = %v, want %d", gandiClient.snapshots, tt.snapshots)
+			}
+			for _, zone := range gandiClient.snapshots {
+				if zone != "example.com" {
+					t.Errorf("snapshot of zone %s, want example.com", zone)
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshotNotSupported(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "snapshotBeforeWrite": true`)
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); len(got) != 1 {
+		t.Errorf("values = %v, want the challenge value", got)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
+)
+
+// defaultSolverName is the name of the solver when SOLVERS is not set.
+const defaultSolverName = "gandi"
+
+// solverDefinition is a named solver with its own default solver config,
+// which the config of the issuer overrides field by field.
+type solverDefinition struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// solversFromEnv returns the solvers defined by SOLVERS, a JSON list of
+// solver definitions, or the single gandi solver if it is not set. The
+// solvers share the values they track, in TRACKING_FILE if set, and their
+// in-flight challenges, kept up to CHALLENGE_MAX_AGE in the same state, and
+// the first one serves the admin endpoint if enabled.
+func solversFromEnv() ([]webhook.Solver, error) {
+	maxAge, err := durationFromEnv("CHALLENGE_MAX_AGE", defaultChallengeMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("CHALLENGE_MAX_AGE: %v", err)
+	}
+
+	var tracker valueTracker = newMemoryTracker()
+	fileTracker, err := fileTrackerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if fileTracker != nil {
+		tracker = fileTracker
+	}
+
+	env := os.Getenv("SOLVERS")
+	if env == "" {
+		solver := newGandiDNSProviderSolver()
+		solver.tracker = tracker
+		solver.challenges.maxAge = maxAge
+		return []webhook.Solver{solver}, nil
+	}
+
+	var definitions []solverDefinition
+	if err := json.Unmarshal([]byte(env), &definitions); err != nil {
+		return nil, fmt.Errorf("error decoding solver definitions: %v", err)
+	}
+	if len(definitions) == 0 {
+		return nil, fmt.Errorf("no solver defined")
+	}
+
+	challenges := newChallengeRegistry(realClock{})
+	challenges.maxAge = maxAge
+	locks := newZoneLocks()
+	names := map[string]bool{}
+	solvers := make([]webhook.Solver, 0, len(definitions))
+	for i, d := range definitions {
+		if d.Name == "" {
+			return nil, fmt.Errorf("solver %d has no name", i)
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("solver %q is defined twice", d.Name)
+		}
+		names[d.Name] = true
+		if _, err := loadConfigWithDefaults(d.Config, nil); err != nil {
+			return nil, fmt.Errorf("solver %q: %v", d.Name, err)
+		}
+
+		solver := newGandiDNSProviderSolver()
+		solver.name = d.Name
+		solver.defaults = d.Config
+		solver.tracker = tracker
+		solver.challenges = challenges
+		solver.zoneLocks = locks
+		solver.serveAdmin = i == 0
+		solvers = append(solvers, solver)
+	}
+	return solvers, nil
+}
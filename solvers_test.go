@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSolversFromEnv(t *testing.T) {
+	t.Setenv("SOLVERS", "")
+	solvers, err := solversFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(solvers) != 1 || solvers[0].Name() != "gandi" {
+		t.Errorf("default solvers = %v, want the gandi solver", solvers)
+	}
+
+	t.Setenv("SOLVERS", `[
+		{"name": "gandi-production"},
+		{"name": "gandi-staging", "config": {"apiURL": "https://api.sandbox.gandi.net", "ttl": 600}}
+	]`)
+	solvers, err = solversFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(solvers) != 2 || solvers[0].Name() != "gandi-production" || solvers[1].Name() != "gandi-staging" {
+		t.Fatalf("solvers = %v", solvers)
+	}
+	production, staging := solvers[0].(*gandiDNSProviderSolver), solvers[1].(*gandiDNSProviderSolver)
+	if !production.serveAdmin || staging.serveAdmin {
+		t.Error("only the first solver should serve the admin endpoint")
+	}
+	if production.tracker != staging.tracker {
+		t.Error("solvers do not share their tracker")
+	}
+
+	// The issuer config overrides the defaults of the solver.
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "ttl": 900`)
+	cfg, err := loadConfigWithDefaults(staging.defaults, ch.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIURL != "https://api.sandbox.gandi.net" || cfg.TTL != 900 || cfg.APIKeySecretRef.Name != "gandi-credentials" {
+		t.Errorf("config = %+v", cfg)
+	}
+
+	for _, tt := range []struct {
+		env     string
+		wantErr string
+	}{
+		{env: `{"name": "gandi"}`, wantErr: "error decoding"},
+		{env: `[]`, wantErr: "no solver defined"},
+		{env: `[{"config": {}}]`, wantErr: "has no name"},
+		{env: `[{"name": "gandi"}, {"name": "gandi"}]`, wantErr: "defined twice"},
+		{env: `[{"name": "gandi", "config": {"ttl": 60}}]`, wantErr: "ttl must be"},
+	} {
+		t.Setenv("SOLVERS", tt.env)
+		if _, err := solversFromEnv(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("SOLVERS=%s: error = %v, want %q", tt.env, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSolversShareTrackingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.json")
+	t.Setenv("TRACKING_FILE", path)
+	t.Setenv("SOLVERS", `[{"name": "gandi-production"}, {"name": "gandi-staging"}]`)
+	solvers, err := solversFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	production, staging := solvers[0].(*gandiDNSProviderSolver), solvers[1].(*gandiDNSProviderSolver)
+	if err := production.tracker.Add("_acme-challenge.example.com", "key-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := staging.tracker.Add("_acme-challenge.example.org", "key-b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart, the values of both solvers are still tracked.
+	restarted, err := newFileTracker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := restarted.Has("_acme-challenge.example.com", "key-a"); !ok {
+		t.Error("value of the first solver is not tracked after restart")
+	}
+	if ok, _ := restarted.Has("_acme-challenge.example.org", "key-b"); !ok {
+		t.Error("value of the second solver is not tracked after restart")
+	}
+
+	t.Setenv("TRACKING_CONFIGMAP", "gandi-values")
+	if _, err := solversFromEnv(); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("error = %v, want TRACKING_FILE and TRACKING_CONFIGMAP to be mutually exclusive", err)
+	}
+}
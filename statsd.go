@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultStatsDInterval is how often metrics are sent to StatsD unless
+	// STATSD_INTERVAL is set.
+	defaultStatsDInterval = 10 * time.Second
+	// statsdMaxPacketSize bounds the UDP packets sent to StatsD, so they are
+	// not fragmented on common networks.
+	statsdMaxPacketSize = 1432
+)
+
+// metricsGatherer gathers the current values of metrics. It is satisfied by
+// legacyregistry.DefaultGatherer.
+type metricsGatherer interface {
+	Gather() ([]*dto.MetricFamily, error)
+}
+
+// statsdExporter periodically sends the metrics of the webhook, those
+// registered for Prometheus with a gandi_ name, to a StatsD server. Gauges
+// are sent as gauges, counters as the increments since they were last sent,
+// and histograms as the counters of their observations and of their sum.
+// StatsD has no labels: label names and values are appended to the metric
+// name, e.g. gandi_challenges_in_flight.state.presented.
+type statsdExporter struct {
+	conn     io.Writer
+	prefix   string
+	interval time.Duration
+	gatherer metricsGatherer
+	// sent holds the counter values last sent, by StatsD name.
+	sent map[string]float64
+}
+
+// statsdExporterFromEnv returns the exporter to the StatsD server at
+// STATSD_ADDRESS, prefixing metric names with STATSD_PREFIX and sending
+// them every STATSD_INTERVAL, or nil if STATSD_ADDRESS is not set.
+func statsdExporterFromEnv(gatherer metricsGatherer) (*statsdExporter, error) {
+	address := os.Getenv("STATSD_ADDRESS")
+	if address == "" {
+		return nil, nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid StatsD address %q, must be host:port: %v", address, err)
+	}
+	interval, err := durationFromEnv("STATSD_INTERVAL", defaultStatsDInterval)
+	if err != nil {
+		return nil, fmt.Errorf("STATSD_INTERVAL: %v", err)
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to StatsD at %s: %v", address, err)
+	}
+	return newStatsDExporter(conn, os.Getenv("STATSD_PREFIX"), interval, gatherer), nil
+}
+
+func newStatsDExporter(conn io.Writer, prefix string, interval time.Duration, gatherer metricsGatherer) *statsdExporter {
+	return &statsdExporter{conn: conn, prefix: prefix, interval: interval, gatherer: gatherer, sent: map[string]float64{}}
+}
+
+// run sends the metrics every interval, for the lifetime of the process.
+func (e *statsdExporter) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		e.flush()
+	}
+}
+
+// flush sends the current values of the metrics. Failures are logged, the
+// counter increments not sent are sent along with the next ones.
+func (e *statsdExporter) flush() {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could gather along with the error.
+		klog.Warningf("Unable to gather some metrics for StatsD: %v", err)
+	}
+	for _, packet := range statsdPackets(e.lines(families), statsdMaxPacketSize) {
+		if _, err := e.conn.Write(packet); err != nil {
+			klog.Warningf("Unable to send metrics to StatsD: %v", err)
+			return
+		}
+	}
+}
+
+// lines returns the StatsD lines of the gandi_ metrics among families,
+// recording the counter values sent.
+func (e *statsdExporter) lines(families []*dto.MetricFamily) []string {
+	var lines []string
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "gandi_") {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = e.appendCount(lines, e.statsdName(family.GetName(), m.GetLabel()), m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				name, v := e.statsdName(family.GetName(), m.GetLabel()), m.GetGauge().GetValue()
+				if v < 0 {
+					// A signed gauge is a change of the gauge to StatsD.
+					lines = append(lines, name+":0|g")
+				}
+				lines = append(lines, name+":"+formatStatsDValue(v)+"|g")
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				lines = e.appendCount(lines, e.statsdName(family.GetName()+"_count", m.GetLabel()), float64(h.GetSampleCount()))
+				lines = e.appendCount(lines, e.statsdName(family.GetName()+"_sum", m.GetLabel()), h.GetSampleSum())
+			}
+		}
+	}
+	return lines
+}
+
+// appendCount appends the counter line of the increment of the counter name
+// to value since it was last sent, if any.
+func (e *statsdExporter) appendCount(lines []string, name string, value float64) []string {
+	delta := value - e.sent[name]
+	if delta == 0 {
+		return lines
+	}
+	e.sent[name] = value
+	return append(lines, name+":"+formatStatsDValue(delta)+"|c")
+}
+
+// statsdName returns the StatsD name of the metric name with labels.
+func (e *statsdExporter) statsdName(name string, labels []*dto.LabelPair) string {
+	var b strings.Builder
+	b.WriteString(e.prefix)
+	b.WriteString(name)
+	for _, l := range labels {
+		b.WriteString("." + sanitizeStatsDName(l.GetName()) + "." + sanitizeStatsDName(l.GetValue()))
+	}
+	return b.String()
+}
+
+// sanitizeStatsDName replaces the characters of s that separate the parts of
+// StatsD names and lines, such as the dots of a zone name, with underscores.
+func sanitizeStatsDName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func formatStatsDValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// statsdPackets joins lines into packets of at most size bytes, separated by
+// newlines. A line longer than size gets a packet of its own.
+func statsdPackets(lines []string, size int) [][]byte {
+	var packets [][]byte
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > size {
+			packets = append(packets, packet)
+			packet = nil
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		packets = append(packets, packet)
+	}
+	return packets
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// packetRecorder records the packets written to it.
+type packetRecorder struct {
+	packets []string
+}
+
+func (r *packetRecorder) Write(p []byte) (int, error) {
+	r.packets = append(r.packets, string(p))
+	return len(p), nil
+}
+
+// staticGatherer gathers the metric families it holds.
+type staticGatherer struct {
+	families []*dto.MetricFamily
+}
+
+func (g *staticGatherer) Gather() ([]*dto.MetricFamily, error) {
+	return g.families, nil
+}
+
+func metricFamily(name string, typ dto.MetricType, metrics ...*dto.Metric) *dto.MetricFamily {
+	return &dto.MetricFamily{Name: &name, Type: typ.Enum(), Metric: metrics}
+}
+
+func labels(pairs ...string) []*dto.LabelPair {
+	var labels []*dto.LabelPair
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name, value := pairs[i], pairs[i+1]
+		labels = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	return labels
+}
+
+func counterMetric(v float64, pairs ...string) *dto.Metric {
+	return &dto.Metric{Label: labels(pairs...), Counter: &dto.Counter{Value: &v}}
+}
+
+func gaugeMetric(v float64, pairs ...string) *dto.Metric {
+	return &dto.Metric{Label: labels(pairs...), Gauge: &dto.Gauge{Value: &v}}
+}
+
+func histogramMetric(count uint64, sum float64, pairs ...string) *dto.Metric {
+	return &dto.Metric{Label: labels(pairs...), Histogram: &dto.Histogram{SampleCount: &count, SampleSum: &sum}}
+}
+
+func TestStatsDExporter(t *testing.T) {
+	gatherer := &staticGatherer{}
+	conn := &packetRecorder{}
+	exporter := newStatsDExporter(conn, "webhook.", defaultStatsDInterval, gatherer)
+
+	gather := func(errors float64, inFlight float64, propagations uint64, seconds float64) {
+		gatherer.families = []*dto.MetricFamily{
+			metricFamily("go_goroutines", dto.MetricType_GAUGE, gaugeMetric(12)),
+			metricFamily("gandi_cleanup_errors_ignored_total", dto.MetricType_COUNTER, counterMetric(errors, "solver", "gandi")),
+			metricFamily("gandi_challenges_in_flight", dto.MetricType_GAUGE, gaugeMetric(inFlight, "state", "presented")),
+			metricFamily("gandi_propagation_duration_seconds", dto.MetricType_HISTOGRAM, histogramMetric(propagations, seconds, "zone", "example.com")),
+		}
+	}
+	for _, tt := range []struct {
+		name         string
+		errors       float64
+		inFlight     float64
+		propagations uint64
+		seconds      float64
+		want         []string
+	}{
+		{
+			name: "first", errors: 2, inFlight: 3, propagations: 4, seconds: 10.5,
+			want: []string{
+				"webhook.gandi_cleanup_errors_ignored_total.solver.gandi:2|c",
+				"webhook.gandi_challenges_in_flight.state.presented:3|g",
+				"webhook.gandi_propagation_duration_seconds_count.zone.example_com:4|c",
+				"webhook.gandi_propagation_duration_seconds_sum.zone.example_com:10.5|c",
+			},
+		},
+		{
+			// Counters are sent as their increments, unchanged ones not at all.
+			name: "increments", errors: 2, inFlight: 1, propagations: 5, seconds: 12.75,
+			want: []string{
+				"webhook.gandi_challenges_in_flight.state.presented:1|g",
+				"webhook.gandi_propagation_duration_seconds_count.zone.example_com:1|c",
+				"webhook.gandi_propagation_duration_seconds_sum.zone.example_com:2.25|c",
+			},
+		},
+		{
+			// A negative gauge is reset first, as it would be a change.
+			name: "negative gauge", errors: 2, inFlight: -1, propagations: 5, seconds: 12.75,
+			want: []string{
+				"webhook.gandi_challenges_in_flight.state.presented:0|g",
+				"webhook.gandi_challenges_in_flight.state.presented:-1|g",
+			},
+		},
+	} {
+		conn.packets = nil
+		gather(tt.errors, tt.inFlight, tt.propagations, tt.seconds)
+		exporter.flush()
+		if want := []string{strings.Join(tt.want, "\n")}; !reflect.DeepEqual(conn.packets, want) {
+			t.Errorf("%s: packets = %q, want %q", tt.name, conn.packets, want)
+		}
+	}
+}
+
+func TestStatsDPackets(t *testing.T) {
+	lines := []string{"a:1|c", "bb:2|c", "ccc:3|c", strings.Repeat("d", 20) + ":4|g"}
+	got := statsdPackets(lines, 14)
+	want := [][]byte{[]byte("a:1|c\nbb:2|c"), []byte("ccc:3|c"), []byte(strings.Repeat("d", 20) + ":4|g")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("statsdPackets = %q, want %q", got, want)
+	}
+}
+
+func TestStatsDExporterFromEnv(t *testing.T) {
+	t.Setenv("STATSD_ADDRESS", "")
+	if exporter, err := statsdExporterFromEnv(&staticGatherer{}); exporter != nil || err != nil {
+		t.Errorf("without STATSD_ADDRESS: exporter = %v, error = %v, want neither", exporter, err)
+	}
+
+	t.Setenv("STATSD_ADDRESS", "statsd")
+	if _, err := statsdExporterFromEnv(&staticGatherer{}); err == nil {
+		t.Error("expected an error for an address without port")
+	}
+
+	t.Setenv("STATSD_ADDRESS", "127.0.0.1:8125")
+	t.Setenv("STATSD_PREFIX", "webhook.")
+	t.Setenv("STATSD_INTERVAL", "30s")
+	exporter, err := statsdExporterFromEnv(&staticGatherer{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exporter.prefix != "webhook." || exporter.interval.String() != "30s" {
+		t.Errorf("prefix = %q, interval = %s, want webhook. and 30s", exporter.prefix, exporter.interval)
+	}
+}
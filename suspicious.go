@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gandi/go-gandi/livedns"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// suspiciousReadRetry reads a TXT RRset again before CleanUp writes it
+	// if it lacks values of other presented challenges, and fails the clean
+	// up if they are still missing.
+	suspiciousReadRetry = "retry"
+	// suspiciousReadIgnore writes back whatever was read.
+	suspiciousReadIgnore = "ignore"
+
+	// suspiciousReadAttempts and suspiciousReadInterval bound the reads of
+	// an RRset looking incomplete.
+	suspiciousReadAttempts = 3
+	suspiciousReadInterval = time.Second
+)
+
+// suspiciousReadError is returned by an expectingClient when the RRset it
+// reads keeps lacking expected values.
+type suspiciousReadError struct {
+	name    string
+	missing []string
+}
+
+func (e *suspiciousReadError) Error() string {
+	return fmt.Sprintf("TXT record %s was read %d times without the values %v of other presented challenges, not writing it to avoid dropping them",
+		e.name, suspiciousReadAttempts, keyHashAll(e.missing))
+}
+
+// expectingClient is a liveDNSClient checking the TXT values it reads hold
+// the values of the other challenges presented for the same name. A read
+// lacking some looks incomplete: writing back what it returned could drop
+// them, so it is retried, and fails if they are still missing.
+type expectingClient struct {
+	liveDNSClient
+	clock    clock
+	expected []string
+}
+
+// expectValues returns targets with their clients replaced by clients
+// expecting to read the values expected, if there are any.
+func expectValues(targets []accountTarget, clk clock, expected []string) []accountTarget {
+	if len(expected) == 0 {
+		return targets
+	}
+	expecting := make([]accountTarget, 0, len(targets))
+	for _, t := range targets {
+		t.client = &expectingClient{liveDNSClient: t.client, clock: clk, expected: expected}
+		t.expected = expected
+		expecting = append(expecting, t)
+	}
+	return expecting
+}
+
+func (e *expectingClient) GetDomainRecordsByName(fqdn, name string) ([]livedns.DomainRecord, error) {
+	var records []livedns.DomainRecord
+	err := e.read(fqdn, name, func() ([]string, error) {
+		var err error
+		records, err = e.liveDNSClient.GetDomainRecordsByName(fqdn, name)
+		var values []string
+		for _, r := range records {
+			if r.RrsetType == "TXT" {
+				values = append(values, r.RrsetValues...)
+			}
+		}
+		return values, err
+	})
+	return records, err
+}
+
+func (e *expectingClient) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	if recordtype != "TXT" {
+		return e.liveDNSClient.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	}
+	var record livedns.DomainRecord
+	err := e.read(fqdn, name, func() ([]string, error) {
+		var err error
+		record, err = e.liveDNSClient.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+		return record.RrsetValues, err
+	})
+	return record, err
+}
+
+// read calls read until the values it returns hold the expected ones, at
+// most suspiciousReadAttempts times. Failed reads are returned as is.
+func (e *expectingClient) read(fqdn, name string, read func() ([]string, error)) error {
+	for attempt := 1; ; attempt++ {
+		values, err := read()
+		if err != nil {
+			return err
+		}
+		var missing []string
+		for _, key := range e.expected {
+			if !hasTXTValue(values, key) {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		if attempt == suspiciousReadAttempts {
+			return &suspiciousReadError{name: recordName(fqdn, name), missing: missing}
+		}
+		klog.Warningf("TXT record %s was read without the values %v of other presented challenges, reading it again", recordName(fqdn, name), keyHashAll(missing))
+		e.clock.Sleep(suspiciousReadInterval)
+	}
+}
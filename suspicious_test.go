@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+// incompleteReadClient is a fakelivedns.Client whose next incomplete reads
+// by name return only the first value of each RRset.
+type incompleteReadClient struct {
+	*fakelivedns.Client
+	incomplete int
+}
+
+func (c *incompleteReadClient) GetDomainRecordsByName(fqdn, name string) ([]livedns.DomainRecord, error) {
+	records, err := c.Client.GetDomainRecordsByName(fqdn, name)
+	if c.incomplete > 0 {
+		c.incomplete--
+		for i := range records {
+			records[i].RrsetValues = records[i].RrsetValues[:1]
+		}
+	}
+	return records, err
+}
+
+func TestCleanUpSuspiciousRead(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		policy     string
+		incomplete int
+		wantErr    string
+		want       []string
+	}{
+		{name: "read again", incomplete: 2, want: []string{`"wildcard"`}},
+		{name: "still incomplete", incomplete: suspiciousReadAttempts, wantErr: "was read 3 times without the values", want: []string{`"apex"`, `"wildcard"`}},
+		// The incomplete read drops the value of the other challenge.
+		{name: "ignored", policy: suspiciousReadIgnore, incomplete: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gandiClient := &incompleteReadClient{Client: fakelivedns.New()}
+			solver := newTestSolver(gandiClient.Client)
+			solver.newClient = func(config.Config) liveDNSClient {
+				return gandiClient
+			}
+			extra := ""
+			if tt.policy != "" {
+				extra = `, "suspiciousReadPolicy": "` + tt.policy + `"`
+			}
+			for _, key := range []string{"apex", "wildcard"} {
+				if err := solver.Present(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", key, extra)); err != nil {
+					t.Fatalf("present %s: %v", key, err)
+				}
+			}
+
+			gandiClient.incomplete = tt.incomplete
+			err := solver.CleanUp(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "apex", extra))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+// valueTracker remembers which TXT values were presented by the webhook, so
+// CleanUp never removes a value it did not create.
+type valueTracker interface {
+	Add(fqdn, key string) error
+	Has(fqdn, key string) (bool, error)
+	Remove(fqdn, key string) error
+	List() ([]trackedValue, error)
+}
+
+// trackedValue is a presented value as listed by a valueTracker. The
+// challenge key itself is not kept, only its tracking key.
+type trackedValue struct {
+	FQDN        string `json:"fqdn"`
+	Zone        string `json:"zone"`
+	TrackingKey string `json:"trackingKey"`
+}
+
+// listTrackedValues returns the values of a tracker's tracking key to FQDN
+// mapping, sorted by FQDN.
+func listTrackedValues(data map[string]string) []trackedValue {
+	values := make([]trackedValue, 0, len(data))
+	for key, fqdn := range data {
+		zone, _, err := extractRootAndSubDomain(fqdn, "")
+		if err != nil {
+			zone = ""
+		}
+		values = append(values, trackedValue{FQDN: fqdn, Zone: zone, TrackingKey: key})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].FQDN != values[j].FQDN {
+			return values[i].FQDN < values[j].FQDN
+		}
+		return values[i].TrackingKey < values[j].TrackingKey
+	})
+	return values
+}
+
+// trackingKey identifies a presented value without exposing the challenge key.
+// The result is a valid ConfigMap key.
+func trackingKey(fqdn, key string) string {
+	sum := sha256.Sum256([]byte(fqdn + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// memoryTracker keeps presented values in memory. They are lost when the
+// webhook restarts.
+type memoryTracker struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newMemoryTracker() *memoryTracker {
+	return &memoryTracker{values: map[string]string{}}
+}
+
+func (t *memoryTracker) Add(fqdn, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.values[trackingKey(fqdn, key)] = fqdn
+	return nil
+}
+
+func (t *memoryTracker) Has(fqdn, key string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.values[trackingKey(fqdn, key)]
+	return ok, nil
+}
+
+func (t *memoryTracker) Remove(fqdn, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.values, trackingKey(fqdn, key))
+	return nil
+}
+
+func (t *memoryTracker) List() ([]trackedValue, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return listTrackedValues(t.values), nil
+}
+
+// fileTracker keeps presented values in memory and saves them to a local
+// file on every change, so they survive restarts of the webhook when the file
+// is on an emptyDir or a persistent volume. Unlike configMapTracker, it is not
+// shared between replicas.
+type fileTracker struct {
+	mu     sync.Mutex
+	path   string
+	values map[string]string
+}
+
+// newFileTracker returns a tracker saving to path, with the values saved
+// there before. A file that cannot be decoded is moved aside to path with a
+// .corrupt suffix and tracking starts over: CleanUp then leaves the values it
+// held in place rather than guessing. Entries that are not tracking keys are
+// dropped.
+func newFileTracker(path string) (*fileTracker, error) {
+	t := &fileTracker{path: path, values: map[string]string{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tracking file %s: %v", path, err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		klog.Warningf("Tracking file %s is corrupt, moving it aside and starting over: %v", path, err)
+		if err := os.Rename(path, path+".corrupt"); err != nil {
+			return nil, fmt.Errorf("unable to move corrupt tracking file %s aside: %v", path, err)
+		}
+		return t, nil
+	}
+	dropped := 0
+	for key, fqdn := range values {
+		if !isTrackingKey(key) || fqdn == "" {
+			dropped++
+			continue
+		}
+		t.values[key] = fqdn
+	}
+	if dropped > 0 {
+		klog.Warningf("Dropped %d invalid entries from tracking file %s", dropped, path)
+		if err := t.save(); err != nil {
+			return nil, err
+		}
+	}
+	klog.V(2).Infof("loaded %d tracked values from %s", len(t.values), path)
+	return t, nil
+}
+
+// fileTrackerFromEnv returns the tracker saving to TRACKING_FILE, or nil if
+// it is not set. It must be created once and shared by all solvers: each
+// rewrites the whole file, so trackers of their own would overwrite the
+// values of the others.
+func fileTrackerFromEnv() (*fileTracker, error) {
+	path := os.Getenv("TRACKING_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	if os.Getenv("TRACKING_CONFIGMAP") != "" {
+		return nil, fmt.Errorf("TRACKING_FILE and TRACKING_CONFIGMAP are mutually exclusive")
+	}
+	tracker, err := newFileTracker(path)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("tracking presented values in file %s", path)
+	return tracker, nil
+}
+
+// isTrackingKey reports whether key is a trackingKey result.
+func isTrackingKey(key string) bool {
+	b, err := hex.DecodeString(key)
+	return err == nil && len(b) == sha256.Size
+}
+
+func (t *fileTracker) Add(fqdn, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tk := trackingKey(fqdn, key)
+	if _, ok := t.values[tk]; ok {
+		return nil
+	}
+	t.values[tk] = fqdn
+	if err := t.save(); err != nil {
+		delete(t.values, tk)
+		return err
+	}
+	return nil
+}
+
+func (t *fileTracker) Has(fqdn, key string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.values[trackingKey(fqdn, key)]
+	return ok, nil
+}
+
+func (t *fileTracker) Remove(fqdn, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tk := trackingKey(fqdn, key)
+	prev, ok := t.values[tk]
+	if !ok {
+		return nil
+	}
+	delete(t.values, tk)
+	if err := t.save(); err != nil {
+		t.values[tk] = prev
+		return err
+	}
+	return nil
+}
+
+func (t *fileTracker) List() ([]trackedValue, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return listTrackedValues(t.values), nil
+}
+
+// save writes the values to a temporary file next to the tracking file and
+// renames it over it, so a crash never leaves a partially written file. It
+// must be called with mu held.
+func (t *fileTracker) save() error {
+	data, err := json.Marshal(t.values)
+	if err != nil {
+		return fmt.Errorf("unable to encode tracking file %s: %v", t.path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("unable to write tracking file %s: %v", t.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), t.path)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write tracking file %s: %v", t.path, err)
+	}
+	return nil
+}
+
+// configMapTracker keeps presented values in a ConfigMap, so they survive
+// restarts and are shared between replicas of the webhook.
+type configMapTracker struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func newConfigMapTracker(client kubernetes.Interface, namespace, name string) *configMapTracker {
+	return &configMapTracker{client: client, namespace: namespace, name: name}
+}
+
+func (t *configMapTracker) Add(fqdn, key string) error {
+	return t.update(func(data map[string]string) {
+		data[trackingKey(fqdn, key)] = fqdn
+	})
+}
+
+func (t *configMapTracker) Has(fqdn, key string) (bool, error) {
+	cm, err := t.client.CoreV1().ConfigMaps(t.namespace).Get(context.Background(), t.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to get configmap \"%s/%s\": %v", t.namespace, t.name, err)
+	}
+	_, ok := cm.Data[trackingKey(fqdn, key)]
+	return ok, nil
+}
+
+func (t *configMapTracker) Remove(fqdn, key string) error {
+	return t.update(func(data map[string]string) {
+		delete(data, trackingKey(fqdn, key))
+	})
+}
+
+func (t *configMapTracker) List() ([]trackedValue, error) {
+	cm, err := t.client.CoreV1().ConfigMaps(t.namespace).Get(context.Background(), t.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return listTrackedValues(nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get configmap \"%s/%s\": %v", t.namespace, t.name, err)
+	}
+	return listTrackedValues(cm.Data), nil
+}
+
+// update applies mutate to the ConfigMap data, creating the ConfigMap if it
+// does not exist yet and retrying on conflicting writes.
+func (t *configMapTracker) update(mutate func(map[string]string)) error {
+	configMaps := t.client.CoreV1().ConfigMaps(t.namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(context.Background(), t.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: t.namespace, Name: t.name},
+				Data:       map[string]string{},
+			}
+			mutate(cm.Data)
+			_, err = configMaps.Create(context.Background(), cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Report as a conflict so the update is retried against
+				// the ConfigMap created concurrently.
+				return apierrors.NewConflict(corev1.Resource("configmaps"), t.name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		mutate(cm.Data)
+		_, err = configMaps.Update(context.Background(), cm, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update configmap \"%s/%s\": %v", t.namespace, t.name, err)
+	}
+	return nil
+}
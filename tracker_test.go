@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testValueTracker(t *testing.T, tracker valueTracker) {
+	t.Helper()
+
+	if err := tracker.Add("_acme-challenge.example.com", "key-a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tracker.Add("_acme-challenge.example.com", "key-b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		fqdn string
+		key  string
+		want bool
+	}{
+		{fqdn: "_acme-challenge.example.com", key: "key-a", want: true},
+		{fqdn: "_acme-challenge.example.com", key: "key-b", want: true},
+		{fqdn: "_acme-challenge.example.com", key: "key-c", want: false},
+		{fqdn: "_acme-challenge.example.org", key: "key-a", want: false},
+	} {
+		ok, err := tracker.Has(tt.fqdn, tt.key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok != tt.want {
+			t.Errorf("Has(%q, %q) = %v, want %v", tt.fqdn, tt.key, ok, tt.want)
+		}
+	}
+
+	if err := tracker.Remove("_acme-challenge.example.com", "key-a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, _ := tracker.Has("_acme-challenge.example.com", "key-a"); ok {
+		t.Error("removed value is still tracked")
+	}
+	if ok, _ := tracker.Has("_acme-challenge.example.com", "key-b"); !ok {
+		t.Error("remaining value is no longer tracked")
+	}
+
+	values, err := tracker.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []trackedValue{{
+		FQDN:        "_acme-challenge.example.com",
+		Zone:        "example.com",
+		TrackingKey: trackingKey("_acme-challenge.example.com", "key-b"),
+	}}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("List() = %v, want %v", values, want)
+	}
+}
+
+func TestMemoryTracker(t *testing.T) {
+	testValueTracker(t, newMemoryTracker())
+}
+
+func TestConfigMapTracker(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	testValueTracker(t, newConfigMapTracker(client, "cert-manager", "gandi-values"))
+
+	cm, err := client.CoreV1().ConfigMaps("cert-manager").Get(context.Background(), "gandi-values", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cm.Data) != 1 {
+		t.Errorf("configmap holds %d values, want 1", len(cm.Data))
+	}
+	for k, v := range cm.Data {
+		if k == "key-b" || v != "_acme-challenge.example.com" {
+			t.Errorf("unexpected configmap entry %q: %q", k, v)
+		}
+	}
+
+	// A new tracker backed by the same ConfigMap, e.g. after a restart,
+	// still knows the value.
+	restarted := newConfigMapTracker(client, "cert-manager", "gandi-values")
+	if ok, _ := restarted.Has("_acme-challenge.example.com", "key-b"); !ok {
+		t.Error("value is not tracked after restart")
+	}
+}
+
+func TestFileTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.json")
+	tracker, err := newFileTracker(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	testValueTracker(t, tracker)
+
+	// A new tracker loading the same file, e.g. after a restart, still
+	// knows the value.
+	restarted, err := newFileTracker(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, _ := restarted.Has("_acme-challenge.example.com", "key-b"); !ok {
+		t.Error("value is not tracked after restart")
+	}
+	if ok, _ := restarted.Has("_acme-challenge.example.com", "key-a"); ok {
+		t.Error("removed value is tracked after restart")
+	}
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestFileTrackerCorrupt(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content string
+		want    int
+		aside   bool
+	}{
+		{name: "truncated", content: `{"` + trackingKey("a", "b") + `": "_acme-`, aside: true},
+		{name: "not an object", content: `["_acme-challenge.example.com"]`, aside: true},
+		{name: "invalid entries", content: `{"` + trackingKey("a", "b") + `": "_acme-challenge.example.com", "key-b": "_acme-challenge.example.com", "` + trackingKey("c", "d") + `": ""}`, want: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "values.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			tracker, err := newFileTracker(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			values, _ := tracker.List()
+			if len(values) != tt.want {
+				t.Errorf("%d values loaded, want %d", len(values), tt.want)
+			}
+			if _, err := os.Stat(path + ".corrupt"); (err == nil) != tt.aside {
+				t.Errorf("corrupt file moved aside = %v, want %v", err == nil, tt.aside)
+			}
+
+			// Tracking goes on, and the file can be loaded again.
+			if err := tracker.Add("_acme-challenge.example.org", "key"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			reloaded, err := newFileTracker(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if values, _ := reloaded.List(); len(values) != tt.want+1 {
+				t.Errorf("%d values reloaded, want %d", len(values), tt.want+1)
+			}
+		})
+	}
+}
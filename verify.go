@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// verifyCredentialsFromEnv reports whether VERIFY_CREDENTIALS asks for the
+// API keys of the solver defaults to be checked at startup.
+func verifyCredentialsFromEnv() bool {
+	return os.Getenv("VERIFY_CREDENTIALS") == "true"
+}
+
+// checkApiKeys checks the API keys of a map of names, domains or tags, to
+// them, returning a warning for every API key shared by several names and
+// every API key listing zones fails with. If domains is set, names are
+// domains and a warning is also returned for every domain the zones listed
+// with its API key do not cover.
+func checkApiKeys(apiKeys map[string]string, domains bool, listZones func(apiKey string) ([]string, error)) []string {
+	names := map[string][]string{}
+	for name, apiKey := range apiKeys {
+		names[apiKey] = append(names[apiKey], name)
+	}
+	keys := make([]string, 0, len(names))
+	for apiKey := range names {
+		sort.Strings(names[apiKey])
+		keys = append(keys, apiKey)
+	}
+	sort.Slice(keys, func(i, j int) bool { return names[keys[i]][0] < names[keys[j]][0] })
+
+	var warnings []string
+	for _, apiKey := range keys {
+		mapped := names[apiKey]
+		if len(mapped) > 1 {
+			warnings = append(warnings, fmt.Sprintf("%s share the same API key %s", strings.Join(mapped, ", "), keyHash(apiKey)))
+		}
+		zones, err := listZones(apiKey)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("API key %s of %s fails: %v", keyHash(apiKey), strings.Join(mapped, ", "), err))
+			continue
+		}
+		if !domains {
+			continue
+		}
+		for _, domain := range mapped {
+			if !coversDomain(zones, domain) {
+				warnings = append(warnings, fmt.Sprintf("API key %s of %s manages no zone of it", keyHash(apiKey), domain))
+			}
+		}
+	}
+	return warnings
+}
+
+// coversDomain reports whether one of zones is domain, holds it or is held
+// by it, as a domain suffix of an API key map may cover several zones.
+func coversDomain(zones []string, domain string) bool {
+	for _, zone := range zones {
+		if zone == domain || strings.HasSuffix(domain, "."+zone) || strings.HasSuffix(zone, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyCredentials logs a warning for every misconfiguration checkApiKeys
+// finds in the API keys of the solver defaults. Only the API keys of a
+// fixed namespace, with credentialScope cluster, can be read before a
+// challenge names its namespace.
+func (c *gandiDNSProviderSolver) verifyCredentials() {
+	cfg, err := c.solverConfig(nil)
+	if err != nil {
+		klog.Warningf("Unable to verify the API keys of solver %s: %v", c.name, err)
+		return
+	}
+	namespace := cfg.credentialNamespace("")
+	if namespace == "" {
+		klog.V(2).Infof("Not verifying the API keys of solver %s: they are read from the namespace of each challenge", c.name)
+		return
+	}
+
+	apiKeys, domains, err := c.configuredApiKeys(&cfg, namespace)
+	if err != nil {
+		klog.Warningf("Unable to verify the API keys of solver %s: %v", c.name, err)
+		return
+	}
+	if len(apiKeys) == 0 {
+		return
+	}
+
+	warnings := checkApiKeys(apiKeys, domains, func(apiKey string) ([]string, error) {
+		lister, ok := c.newGandiClient(&cfg, withAPIKey(apiKey)).(zoneLister)
+		if !ok {
+			return nil, fmt.Errorf("the client cannot list zones")
+		}
+		return listZones(lister)
+	})
+	for _, w := range warnings {
+		klog.Warningf("Solver %s: %s", c.name, w)
+	}
+	if len(warnings) == 0 {
+		klog.V(2).Infof("Verified the API keys of solver %s", c.name)
+	}
+}
+
+// configuredApiKeys returns the API keys cfg reads from namespace, by domain
+// if domains is set, else by tag or by the name of the single secret
+// reference.
+func (c *gandiDNSProviderSolver) configuredApiKeys(cfg *gandiDNSProviderConfig, namespace string) (map[string]string, bool, error) {
+	switch {
+	case cfg.APIKeyTagMapSecretRef != nil:
+		data, err := c.getSecretValue(cfg.APIKeyTagMapSecretRef, namespace)
+		if err != nil {
+			return nil, false, err
+		}
+		apiKeys, err := parseApiKeyTagMap(data)
+		return apiKeys, false, err
+	case cfg.APIKeyMapSecretRef != nil:
+		data, err := c.getSecretValue(cfg.APIKeyMapSecretRef, namespace)
+		if err != nil {
+			return nil, false, err
+		}
+		apiKeys, err := parseApiKeyMap(data)
+		return apiKeys, true, err
+	case cfg.APIKeySecretRef.Name != "":
+		data, err := c.getSecretValue(&cfg.APIKeySecretRef, namespace)
+		if err != nil {
+			return nil, false, err
+		}
+		return map[string]string{"apiKeySecretRef": string(data)}, false, nil
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCheckApiKeys(t *testing.T) {
+	zones := map[string][]string{
+		"key-a": {"example.com", "example.org"},
+		"key-b": {"sub.example.net"},
+	}
+	listZones := func(apiKey string) ([]string, error) {
+		if z, ok := zones[apiKey]; ok {
+			return z, nil
+		}
+		return nil, errors.New("403: Forbidden")
+	}
+
+	for _, tt := range []struct {
+		name    string
+		apiKeys map[string]string
+		domains bool
+		want    []string
+	}{
+		{
+			name:    "valid",
+			apiKeys: map[string]string{"example.com": "key-a", "example.net": "key-b"},
+			domains: true,
+		},
+		{
+			name:    "shared",
+			apiKeys: map[string]string{"example.com": "key-a", "example.org": "key-a"},
+			domains: true,
+			want:    []string{"example.com, example.org share the same API key " + keyHash("key-a")},
+		},
+		{
+			name:    "wrong account",
+			apiKeys: map[string]string{"example.com": "key-a", "example.net": "key-a"},
+			domains: true,
+			want: []string{
+				"example.com, example.net share the same API key " + keyHash("key-a"),
+				"API key " + keyHash("key-a") + " of example.net manages no zone of it",
+			},
+		},
+		{
+			name:    "failing",
+			apiKeys: map[string]string{"example.com": "key-a", "example.net": "key-c"},
+			domains: true,
+			want:    []string{"API key " + keyHash("key-c") + " of example.net fails: 403: Forbidden"},
+		},
+		{
+			name:    "tags",
+			apiKeys: map[string]string{"team-a": "key-a", "team-b": "key-b"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkApiKeys(tt.apiKeys, tt.domains, listZones); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkApiKeys() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
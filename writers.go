@@ -0,0 +1,90 @@
+package main
+
+import "fmt"
+
+// recordWriter presents and cleans up the value key of the challenge record
+// fqdn at a DNS provider. Both must be idempotent, as cert-manager retries
+// Present and CleanUp until they succeed.
+type recordWriter interface {
+	// Name identifies the writer in errors.
+	Name() string
+	Present(fqdn, key string) error
+	CleanUp(fqdn, key string) error
+}
+
+// secondaryWriters are called after Gandi for every challenge, for hybrid
+// DNS where another provider serves some of the nameservers of the zones.
+// None is built in: a build adding one registers it from an init function
+// with registerSecondaryWriter.
+var secondaryWriters []recordWriter
+
+// registerSecondaryWriter adds w to the writers called after Gandi.
+func registerSecondaryWriter(w recordWriter) {
+	secondaryWriters = append(secondaryWriters, w)
+}
+
+// gandiWriter is the default recordWriter, writing to the RRsets of the
+// Gandi accounts of a challenge.
+type gandiWriter struct {
+	solver  *gandiDNSProviderSolver
+	cfg     *gandiDNSProviderConfig
+	targets []accountTarget
+}
+
+func (g *gandiWriter) Name() string {
+	return "gandi"
+}
+
+// Present writes key to every target, reading it back with confirmWrites.
+// The RRsets of the targets are already known, fqdn is not used.
+func (g *gandiWriter) Present(fqdn, key string) error {
+	return forEachTarget(g.targets, g.cfg.writeQuorum(), func(t accountTarget) error {
+		if err := g.solver.writeValue(t, key, true, g.cfg); err != nil {
+			return explainDomainError(t.root, err)
+		}
+		if g.cfg.ConfirmWrites && !g.cfg.AuditOnly {
+			return confirmValue(t.client, g.solver.clock, t.root, t.subdomain, key)
+		}
+		return nil
+	})
+}
+
+// CleanUp removes key from every target.
+func (g *gandiWriter) CleanUp(fqdn, key string) error {
+	return forEachTarget(g.targets, g.cfg.writeQuorum(), func(t accountTarget) error {
+		return explainDomainError(t.root, g.solver.writeValue(t, key, false, g.cfg))
+	})
+}
+
+// writeRecord presents or cleans up key at fqdn in the Gandi targets, then
+// with every secondary writer unless in audit mode. It stops at the first
+// writer failing. With serializeZoneWrites, it waits for the writes of other
+// challenges to the zone to complete first.
+func (c *gandiDNSProviderSolver) writeRecord(cfg *gandiDNSProviderConfig, targets []accountTarget, fqdn, key string, present bool) error {
+	if cfg.SerializeZoneWrites {
+		defer c.zoneLocks.lock(targets[0].root)()
+	}
+	writers := []recordWriter{&gandiWriter{solver: c, cfg: cfg, targets: targets}}
+	if !cfg.AuditOnly {
+		writers = append(writers, c.secondaryWriters...)
+	}
+	for i, w := range writers {
+		var err error
+		if present {
+			err = w.Present(fqdn, key)
+		} else {
+			err = w.CleanUp(fqdn, key)
+		}
+		if err != nil && i > 0 {
+			action := "present"
+			if !present {
+				action = "clean up"
+			}
+			return fmt.Errorf("unable to %s TXT value %s for %s with secondary writer %s: %v", action, keyHash(key), fqdn, w.Name(), err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
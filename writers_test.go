@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+)
+
+// fakeWriter is a recordWriter recording the values it is asked to write.
+type fakeWriter struct {
+	calls []string
+	err   error
+}
+
+func (w *fakeWriter) Name() string {
+	return "fake"
+}
+
+func (w *fakeWriter) Present(fqdn, key string) error {
+	w.calls = append(w.calls, "present "+fqdn+" "+key)
+	return w.err
+}
+
+func (w *fakeWriter) CleanUp(fqdn, key string) error {
+	w.calls = append(w.calls, "clean up "+fqdn+" "+key)
+	return w.err
+}
+
+func TestSecondaryWriter(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	secondary := &fakeWriter{}
+	solver.secondaryWriters = []recordWriter{secondary}
+
+	ch := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", "")
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("present: %v", err)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge", "TXT"); !reflect.DeepEqual(got, []string{`"key"`}) {
+		t.Errorf("values = %v, want the key", got)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("clean up: %v", err)
+	}
+	want := []string{"present _acme-challenge.example.com key", "clean up _acme-challenge.example.com key"}
+	if !reflect.DeepEqual(secondary.calls, want) {
+		t.Errorf("secondary writer calls = %v, want %v", secondary.calls, want)
+	}
+
+	// Nothing is written in audit mode.
+	secondary.calls = nil
+	audited := newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", `, "auditOnly": true`)
+	if err := solver.Present(audited); err != nil {
+		t.Fatalf("present in audit mode: %v", err)
+	}
+	if secondary.calls != nil {
+		t.Errorf("secondary writer calls in audit mode = %v, want none", secondary.calls)
+	}
+}
+
+func TestSecondaryWriterFailure(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	solver.secondaryWriters = []recordWriter{&fakeWriter{err: errors.New("connection refused")}}
+
+	err := solver.Present(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", "key", ""))
+	want := "unable to present TXT value " + keyHash("key") + " for _acme-challenge.example.com with secondary writer fake: connection refused"
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// zoneLocks serializes the writes to the same zone within the process, for
+// serializeZoneWrites.
+type zoneLocks struct {
+	mu    sync.Mutex
+	locks map[string]*zoneLock
+}
+
+// zoneLock is the lock of a zone, with the number of writers holding or
+// waiting for it so it can be dropped once unused.
+type zoneLock struct {
+	sync.Mutex
+	users int
+}
+
+func newZoneLocks() *zoneLocks {
+	return &zoneLocks{locks: map[string]*zoneLock{}}
+}
+
+// lock blocks until no other writer holds the lock of zone, and returns the
+// function releasing it.
+func (z *zoneLocks) lock(zone string) func() {
+	zone = strings.ToLower(strings.Trim(zone, "."))
+	z.mu.Lock()
+	l, ok := z.locks[zone]
+	if !ok {
+		l = &zoneLock{}
+		z.locks[zone] = l
+	}
+	l.users++
+	z.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		z.mu.Lock()
+		defer z.mu.Unlock()
+		if l.users--; l.users == 0 {
+			delete(z.locks, zone)
+		}
+	}
+}
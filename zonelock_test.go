@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+// racyReadClient widens the window between reading and writing an RRset, in
+// which concurrent writes race.
+type racyReadClient struct {
+	*fakelivedns.Client
+}
+
+func (s racyReadClient) GetDomainRecordsByName(fqdn, name string) ([]livedns.DomainRecord, error) {
+	records, err := s.Client.GetDomainRecordsByName(fqdn, name)
+	time.Sleep(time.Millisecond)
+	return records, err
+}
+
+func (s racyReadClient) GetDomainRecordByNameAndType(fqdn, name, recordtype string) (livedns.DomainRecord, error) {
+	record, err := s.Client.GetDomainRecordByNameAndType(fqdn, name, recordtype)
+	time.Sleep(time.Millisecond)
+	return record, err
+}
+
+func TestSerializeZoneWrites(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	solver := newTestSolver(gandiClient)
+	solver.newClient = func(config.Config) liveDNSClient {
+		return racyReadClient{gandiClient}
+	}
+
+	const n = 10
+	var want []string
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		want = append(want, `"`+key+`"`)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- solver.Present(newTestChallengeRequest("_acme-challenge.example.com.", "example.com.", key, `, "serializeZoneWrites": true`))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := gandiClient.Values("example.com", "_acme-challenge", "TXT")
+	sort.Strings(got)
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("values = %v, want all %d presented values", got, n)
+	}
+	if len(solver.zoneLocks.locks) != 0 {
+		t.Errorf("%d zone locks left, want none once unused", len(solver.zoneLocks.locks))
+	}
+}
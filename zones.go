@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gandi/go-gandi/config"
+	"github.com/go-gandi/go-gandi/livedns"
+	"k8s.io/klog/v2"
+)
+
+// zoneListTTL is how long the zones managed by an account are cached.
+const zoneListTTL = time.Minute
+
+// zoneLister is implemented by clients able to list the zones LiveDNS
+// manages for an account, such as *livedns.LiveDNS.
+type zoneLister interface {
+	ListDomains() ([]livedns.Domain, error)
+}
+
+// zoneCache caches the zones managed per account, by keyHash of API key.
+type zoneCache struct {
+	mu      sync.Mutex
+	entries map[string]zoneCacheEntry
+}
+
+type zoneCacheEntry struct {
+	zones   []string
+	expires time.Time
+}
+
+func newZoneCache() *zoneCache {
+	return &zoneCache{entries: map[string]zoneCacheEntry{}}
+}
+
+// zones returns the zones managed by the account of credential, listed with
+// lister unless they were less than zoneListTTL ago.
+func (zc *zoneCache) zones(clk clock, credential string, lister zoneLister) ([]string, error) {
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	if entry, ok := zc.entries[credential]; ok && clk.Now().Before(entry.expires) {
+		return entry.zones, nil
+	}
+	zones, err := listZones(lister)
+	if err != nil {
+		return nil, err
+	}
+	zc.entries[credential] = zoneCacheEntry{zones: zones, expires: clk.Now().Add(zoneListTTL)}
+	return zones, nil
+}
+
+// listZones returns the zones listed by lister, in lower case without
+// surrounding dots.
+func listZones(lister zoneLister) ([]string, error) {
+	domains, err := lister.ListDomains()
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]string, 0, len(domains))
+	for _, d := range domains {
+		zones = append(zones, strings.ToLower(strings.Trim(d.FQDN, ".")))
+	}
+	return zones, nil
+}
+
+// mostSpecificZone returns the longest of zones fqdn belongs to.
+func mostSpecificZone(fqdn string, zones []string) (string, bool) {
+	fqdn = strings.ToLower(strings.Trim(fqdn, "."))
+	best := ""
+	for _, zone := range zones {
+		if (fqdn == zone || strings.HasSuffix(fqdn, "."+zone)) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	return best, best != ""
+}
+
+// discoverZone returns the RRset subdomain of zone root split at the most
+// specific zone LiveDNS manages for the account that it belongs to, such as
+// a sub-zone delegated from root in the same account. root and subdomain are
+// returned as they are if the zones cannot be listed or none matches.
+func (c *gandiDNSProviderSolver) discoverZone(clientcfg config.Config, credential, root, subdomain string) (string, string) {
+	lister, ok := c.newClient(clientcfg).(zoneLister)
+	if !ok {
+		return root, subdomain
+	}
+	zones, err := c.zones.zones(c.clock, credential, lister)
+	if err != nil {
+		klog.Warningf("Unable to list the zones of the account, using zone %s: %v", root, err)
+		return root, subdomain
+	}
+	fqdn := recordName(root, subdomain)
+	zone, ok := mostSpecificZone(fqdn, zones)
+	if !ok || zone == root {
+		return root, subdomain
+	}
+	sub, err := subdomainInZone(fqdn, zone)
+	if err != nil {
+		return root, subdomain
+	}
+	klog.V(6).Infof("using zone %s managed by the account for %s", zone, fqdn)
+	return zone, sub
+}
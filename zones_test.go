@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwolf/cert-manager-webhook-gandi/internal/fakelivedns"
+	"github.com/go-gandi/go-gandi/livedns"
+)
+
+func TestMostSpecificZone(t *testing.T) {
+	zones := []string{"example.com", "sub.example.com", "deep.sub.example.com", "example.org"}
+	for _, tt := range []struct {
+		fqdn string
+		want string
+	}{
+		{fqdn: "_acme-challenge.example.com", want: "example.com"},
+		{fqdn: "_acme-challenge.sub.example.com", want: "sub.example.com"},
+		{fqdn: "_acme-challenge.a.sub.example.com.", want: "sub.example.com"},
+		{fqdn: "_acme-challenge.deep.sub.example.com", want: "deep.sub.example.com"},
+		{fqdn: "_acme-challenge.Sub.Example.com", want: "sub.example.com"},
+		{fqdn: "sub.example.com", want: "sub.example.com"},
+		{fqdn: "_acme-challenge.mysub.example.com", want: "example.com"},
+		{fqdn: "_acme-challenge.example.net", want: ""},
+	} {
+		got, ok := mostSpecificZone(tt.fqdn, zones)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("mostSpecificZone(%q) = %q, %t, want %q", tt.fqdn, got, ok, tt.want)
+		}
+	}
+}
+
+func TestDiscoverZone(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	gandiClient.Set("example.com", livedns.DomainRecord{RrsetName: "www", RrsetType: "A", RrsetValues: []string{"192.0.2.1"}})
+	gandiClient.Set("sub.example.com", livedns.DomainRecord{RrsetName: "www", RrsetType: "A", RrsetValues: []string{"192.0.2.2"}})
+	solver := newTestSolver(gandiClient)
+
+	for _, key := range []string{"apex", "wildcard"} {
+		ch := newTestChallengeRequest("_acme-challenge.sub.example.com.", "example.com.", key, `, "discoverZone": true`)
+		if err := solver.Present(ch); err != nil {
+			t.Fatalf("present %s: %v", key, err)
+		}
+	}
+	if got, want := gandiClient.Values("sub.example.com", "_acme-challenge", "TXT"), []string{`"apex"`, `"wildcard"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values in sub-zone = %v, want %v", got, want)
+	}
+	if got := gandiClient.Values("example.com", "_acme-challenge.sub", "TXT"); got != nil {
+		t.Errorf("values in parent zone = %v, want none", got)
+	}
+	if n := gandiClient.Calls("ListDomains"); n != 1 {
+		t.Errorf("listed zones %d times, want once while cached", n)
+	}
+
+	solver.clock.Sleep(zoneListTTL)
+	ch := newTestChallengeRequest("_acme-challenge.sub.example.com.", "example.com.", "wildcard", `, "discoverZone": true`)
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("clean up: %v", err)
+	}
+	if got, want := gandiClient.Values("sub.example.com", "_acme-challenge", "TXT"), []string{`"apex"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values in sub-zone = %v, want %v", got, want)
+	}
+	if n := gandiClient.Calls("ListDomains"); n != 2 {
+		t.Errorf("listed zones %d times, want twice once expired", n)
+	}
+}
+
+func TestDiscoverZoneListError(t *testing.T) {
+	gandiClient := fakelivedns.New()
+	gandiClient.Fail("ListDomains", 500, 1)
+	solver := newTestSolver(gandiClient)
+
+	ch := newTestChallengeRequest("_acme-challenge.sub.example.com.", "example.com.", "key", `, "discoverZone": true`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := gandiClient.Values("example.com", "_acme-challenge.sub", "TXT"), []string{`"key"`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values = %v, want %v in the registrable domain", got, want)
+	}
+}